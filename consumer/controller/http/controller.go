@@ -10,7 +10,10 @@ import (
 	"github.com/viswals/core/interfaces"
 	"github.com/viswals/core/models"
 	"github.com/viswals/core/pkg/logger"
+	"github.com/viswals/core/pkg/metrics"
 	"github.com/viswals/core/pkg/utils"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.uber.org/zap"
 )
 
 const (
@@ -20,6 +23,12 @@ const (
 type IConsumerService interface {
 	GetAllUsers(ctx context.Context, paginationParams utils.PaginationParams, filters []utils.Filter) (users []models.User, totalUsers int, err error)
 	GetUserById(ctx context.Context, id int64) (user models.User, err error)
+	GetUserByEmail(ctx context.Context, email string) (user models.User, err error)
+	CreateUser(ctx context.Context, user models.User) (created models.User, err error)
+	ReplaceUser(ctx context.Context, id int64, user models.User) (updated models.User, err error)
+	PatchUser(ctx context.Context, id int64, patch models.UserPatch) (updated models.User, err error)
+	DeleteUser(ctx context.Context, id int64) (err error)
+	RestoreUser(ctx context.Context, id int64) (user models.User, err error)
 }
 
 type Controller struct {
@@ -28,6 +37,13 @@ type Controller struct {
 	usecase        IConsumerService
 	httpMux        *http.ServeMux
 	httpPort       string
+	server         *http.Server
+
+	healthChecks       []HealthCheck
+	healthCheckTimeout time.Duration
+
+	// logLevel is served on /log/level when set, see WithLogLevel
+	logLevel *zap.AtomicLevel
 }
 
 func (c *Controller) setDefaults() {
@@ -43,6 +59,14 @@ func (c *Controller) setDefaults() {
 	if c.httpPort == "" {
 		c.httpPort = defaultHttpPort
 	}
+
+	if c.httpMux == nil {
+		c.httpMux = http.NewServeMux()
+	}
+
+	if c.healthCheckTimeout <= 0 {
+		c.healthCheckTimeout = defaultHealthCheckTimeout
+	}
 }
 
 type Option func(*Controller)
@@ -54,6 +78,12 @@ func WithCorsMiddleware(corsMiddleware gin.HandlerFunc) func(*Controller) {
 	}
 }
 
+func WithLogger(logger interfaces.ILogger) func(*Controller) {
+	return func(c *Controller) {
+		c.logger = logger
+	}
+}
+
 func WithHttpMux(httpMux *http.ServeMux) func(*Controller) {
 	return func(c *Controller) {
 		c.httpMux = httpMux
@@ -66,6 +96,28 @@ func WithHttpPort(port string) func(*Controller) {
 	}
 }
 
+// WithHealthChecks adds the dependencies reported by the /healthz and /readyz probes.
+func WithHealthChecks(checks ...HealthCheck) func(*Controller) {
+	return func(c *Controller) {
+		c.healthChecks = append(c.healthChecks, checks...)
+	}
+}
+
+// WithHealthCheckTimeout bounds how long a single dependency is checked by the probes.
+func WithHealthCheckTimeout(timeout time.Duration) func(*Controller) {
+	return func(c *Controller) {
+		c.healthCheckTimeout = timeout
+	}
+}
+
+// WithLogLevel serves the level of the logger on /log/level, GET returns it and PUT changes it without a restart,
+// for example with the {"level":"debug"} JSON body.
+func WithLogLevel(level zap.AtomicLevel) func(*Controller) {
+	return func(c *Controller) {
+		c.logLevel = &level
+	}
+}
+
 func New(usecase IConsumerService, opts ...Option) *Controller {
 	ac := &Controller{
 		usecase: usecase,
@@ -78,23 +130,38 @@ func New(usecase IConsumerService, opts ...Option) *Controller {
 	// set default options
 	ac.setDefaults()
 
+	ac.server = &http.Server{
+		Handler:           ac.httpMux,
+		Addr:              fmt.Sprintf(":%v", ac.httpPort),
+		ReadHeaderTimeout: 3 * time.Second,
+	}
+
 	return ac
 }
 
+// Start serves the routes until Shutdown is called, it then returns http.ErrServerClosed.
 func (c *Controller) Start() error {
-	c.registerRoutes()
+	c.httpMux.Handle("/", c.Router())
 
-	server := &http.Server{
-		Handler:           c.httpMux,
-		Addr:              fmt.Sprintf(":%v", c.httpPort),
-		ReadHeaderTimeout: 3 * time.Second,
-	}
+	return c.server.ListenAndServe()
+}
 
-	return server.ListenAndServe()
+// Shutdown stops accepting new requests and waits for the in-flight ones until the context is done.
+func (c *Controller) Shutdown(ctx context.Context) error {
+	return c.server.Shutdown(ctx)
 }
 
-func (c *Controller) registerRoutes() {
-	router := gin.Default()
+// Router returns the handler serving the routes of the controller.
+func (c *Controller) Router() http.Handler {
+	// the default logger of gin writes the path and query of the requests, which may carry an email
+	router := gin.New()
+	router.Use(c.requestLogger, gin.Recovery())
+	router.Use(metricsMiddleware)
+
+	// continues the trace of the caller, if any, and makes the request context carry the span down to the repository
+	router.Use(otelgin.Middleware("consumer", otelgin.WithFilter(func(r *http.Request) bool {
+		return !unobserved(r)
+	})), redactSpanTarget)
 
 	// define cors middleware if provided
 	if c.corsMiddleware != nil {
@@ -105,7 +172,26 @@ func (c *Controller) registerRoutes() {
 	{
 		routes.GET("/users", c.GetAllUsers)
 		routes.GET("/users/:id", c.GetUserById)
+		routes.GET("/users/by-email/:email", c.GetUserByEmail)
+		routes.POST("/users", c.CreateUser)
+		routes.PUT("/users/:id", c.ReplaceUser)
+		routes.PATCH("/users/:id", c.PatchUser)
+		routes.DELETE("/users/:id", c.DeleteUser)
+		routes.POST("/users/:id/restore", c.RestoreUser)
+
+		// kubernetes probes
+		routes.GET("/healthz", c.Healthz)
+		routes.GET("/readyz", c.Readyz)
+
+		// prometheus metrics
+		routes.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+		// runtime log level
+		if c.logLevel != nil {
+			routes.GET("/log/level", gin.WrapH(c.logLevel))
+			routes.PUT("/log/level", gin.WrapH(c.logLevel))
+		}
 	}
 
-	c.httpMux.Handle("/", router)
+	return router
 }
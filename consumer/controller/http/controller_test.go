@@ -0,0 +1,45 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	controller "github.com/viswals/consumer/controller/http"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+	"go.uber.org/zap"
+)
+
+func TestLogLevel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	router := controller.New(nil, controller.WithLogger(mockLogger), controller.WithLogLevel(level)).Router()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/log/level", strings.NewReader(`{"level":"debug"}`)))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, zap.DebugLevel, level.Level())
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/log/level", strings.NewReader(`{"level":"verbose"}`)))
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, zap.DebugLevel, level.Level())
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/log/level", nil))
+
+	assert.JSONEq(t, `{"level":"debug"}`, recorder.Body.String())
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/viswals/core/interfaces"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthCheckTimeout = 2 * time.Second
+
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusOk       = "ok"
+	StatusDegraded = "degraded"
+	StatusUnready  = "unready"
+)
+
+// HealthCheck is an infrastructure dependency reported by the health and readiness probes.
+type HealthCheck struct {
+	Name    string
+	Checker interfaces.IHealthChecker
+	// Critical dependencies make the service unready when they are down, the others only degrade it.
+	Critical bool
+}
+
+// DependencyStatus is the result of checking a single dependency.
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthResponse is the body of the health and readiness probes.
+type HealthResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Healthz is the liveness probe. It reports whether the dependencies are reachable, but responds with 200 as long as
+// the service is serving, restarting the service does not bring a dependency back.
+func (c *Controller) Healthz(g *gin.Context) {
+	response := c.checkDependencies(g.Request.Context(), interfaces.IHealthChecker.CheckHealth)
+	if response.Status == StatusUnready {
+		response.Status = StatusDegraded
+	}
+
+	g.JSON(http.StatusOK, response)
+}
+
+// Readyz is the readiness probe. It responds with 503 when a critical dependency is not ready, so no traffic is
+// routed to the service, and with 200 and a degraded status when only optional dependencies, such as the cache, are down.
+func (c *Controller) Readyz(g *gin.Context) {
+	response := c.checkDependencies(g.Request.Context(), interfaces.IHealthChecker.CheckReadiness)
+
+	status := http.StatusOK
+	if response.Status == StatusUnready {
+		status = http.StatusServiceUnavailable
+		c.logger.Warn("service is not ready", zap.Any("dependencies", response.Dependencies))
+	}
+
+	g.JSON(status, response)
+}
+
+// checkDependencies runs the check of every dependency concurrently, each bounded by the health check timeout.
+func (c *Controller) checkDependencies(ctx context.Context, check func(interfaces.IHealthChecker, context.Context) error) HealthResponse {
+	response := HealthResponse{
+		Status:       StatusOk,
+		Dependencies: make(map[string]DependencyStatus, len(c.healthChecks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, healthCheck := range c.healthChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, c.healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(healthCheck.Checker, checkCtx)
+			latency := time.Since(start)
+
+			status := DependencyStatus{
+				Status:    StatusUp,
+				Critical:  healthCheck.Critical,
+				LatencyMs: float64(latency.Microseconds()) / 1000,
+			}
+			if err != nil {
+				status.Status = StatusDown
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			response.Dependencies[healthCheck.Name] = status
+		}()
+	}
+	wg.Wait()
+
+	for _, status := range response.Dependencies {
+		switch {
+		case status.Status == StatusUp:
+		case status.Critical:
+			response.Status = StatusUnready
+		case response.Status == StatusOk:
+			response.Status = StatusDegraded
+		}
+	}
+
+	return response
+}
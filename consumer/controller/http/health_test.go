@@ -0,0 +1,78 @@
+package http_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	controller "github.com/viswals/consumer/controller/http"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+)
+
+func TestProbes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	errDown := errors.New("connection refused")
+
+	tests := []struct {
+		name             string
+		path             string
+		postgresErr      error
+		redisErr         error
+		expectedCode     int
+		expectedStatus   string
+		expectedPostgres string
+		expectedRedis    string
+	}{
+		{name: "ready", path: "/readyz", expectedCode: http.StatusOK, expectedStatus: controller.StatusOk,
+			expectedPostgres: controller.StatusUp, expectedRedis: controller.StatusUp},
+		{name: "cache down is degraded but ready", path: "/readyz", redisErr: errDown, expectedCode: http.StatusOK,
+			expectedStatus: controller.StatusDegraded, expectedPostgres: controller.StatusUp, expectedRedis: controller.StatusDown},
+		{name: "database down is unready", path: "/readyz", postgresErr: errDown, expectedCode: http.StatusServiceUnavailable,
+			expectedStatus: controller.StatusUnready, expectedPostgres: controller.StatusDown, expectedRedis: controller.StatusUp},
+		{name: "database down keeps the service alive", path: "/healthz", postgresErr: errDown, expectedCode: http.StatusOK,
+			expectedStatus: controller.StatusDegraded, expectedPostgres: controller.StatusDown, expectedRedis: controller.StatusUp},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockLogger := mock_interfaces.NewMockILogger(ctrl)
+			mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+
+			postgres := mock_interfaces.NewMockIHealthChecker(ctrl)
+			redis := mock_interfaces.NewMockIHealthChecker(ctrl)
+			if test.path == "/readyz" {
+				postgres.EXPECT().CheckReadiness(gomock.Any()).Return(test.postgresErr)
+				redis.EXPECT().CheckReadiness(gomock.Any()).Return(test.redisErr)
+			} else {
+				postgres.EXPECT().CheckHealth(gomock.Any()).Return(test.postgresErr)
+				redis.EXPECT().CheckHealth(gomock.Any()).Return(test.redisErr)
+			}
+
+			c := controller.New(nil, controller.WithLogger(mockLogger), controller.WithHealthChecks(
+				controller.HealthCheck{Name: "postgres", Checker: postgres, Critical: true},
+				controller.HealthCheck{Name: "redis", Checker: redis},
+			))
+
+			recorder := httptest.NewRecorder()
+			c.Router().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			var response controller.HealthResponse
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			assert.Equal(t, test.expectedStatus, response.Status)
+			assert.Equal(t, test.expectedPostgres, response.Dependencies["postgres"].Status)
+			assert.Equal(t, test.expectedRedis, response.Dependencies["redis"].Status)
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package http
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/viswals/core/pkg/metrics"
+)
+
+// metricsMiddleware counts the requests and observes their latency by route. Requests matching no route are
+// grouped together, so unknown paths do not create new series.
+func metricsMiddleware(g *gin.Context) {
+	start := time.Now()
+
+	g.Next()
+
+	route := g.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+
+	metrics.HTTPRequests.WithLabelValues(g.Request.Method, route, strconv.Itoa(g.Writer.Status())).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(g.Request.Method, route).Observe(time.Since(start).Seconds())
+}
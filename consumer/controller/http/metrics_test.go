@@ -0,0 +1,37 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	controller "github.com/viswals/consumer/controller/http"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+)
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+
+	router := controller.New(nil, controller.WithLogger(mockLogger)).Router()
+
+	// requests are counted by route, not by path
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/abc", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `viswals_http_requests_total{code="400",method="GET",route="/users/:id"} 1`)
+	assert.Contains(t, recorder.Body.String(), `viswals_http_requests_total{code="404",method="GET",route="unmatched"} 1`)
+	assert.Contains(t, recorder.Body.String(), "viswals_consumer_workers_busy")
+}
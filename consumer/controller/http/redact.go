@@ -0,0 +1,52 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// sensitiveRoutes carry an email in their path, it is never logged nor traced
+var sensitiveRoutes = map[string]bool{
+	"/users/by-email/:email": true,
+}
+
+// unobserved tells the requests of the probes and metrics, which are polled and neither logged nor traced.
+func unobserved(r *http.Request) bool {
+	return r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics"
+}
+
+// requestLogger logs the requests by route rather than by path, and without their query, so the emails of
+// /users/by-email/:email and /users?email= are not written to the logs. The probes and metrics are not logged.
+func (c *Controller) requestLogger(g *gin.Context) {
+	if unobserved(g.Request) {
+		g.Next()
+		return
+	}
+
+	start := time.Now()
+
+	g.Next()
+
+	route := g.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+
+	c.logger.Info("HTTP request", zap.String("method", g.Request.Method), zap.String("route", route),
+		zap.Int("status", g.Writer.Status()), zap.Duration("latency", time.Since(start)), zap.String("client_ip", g.ClientIP()))
+}
+
+// redactSpanTarget replaces the path otelgin records on the span of the sensitive routes with the route.
+// It must run after the otelgin middleware started the span.
+func redactSpanTarget(g *gin.Context) {
+	if route := g.FullPath(); sensitiveRoutes[route] {
+		trace.SpanFromContext(g.Request.Context()).SetAttributes(attribute.String("http.target", route))
+	}
+
+	g.Next()
+}
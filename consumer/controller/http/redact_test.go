@@ -0,0 +1,66 @@
+package http_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	controller "github.com/viswals/consumer/controller/http"
+	"github.com/viswals/core/models"
+	"github.com/viswals/core/pkg/logger"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEmailsAreNotLoggedNorTraced(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	// gin writes its own logs there, the logs of the controller go to the file
+	var ginLogs bytes.Buffer
+	previousWriter := gin.DefaultWriter
+	gin.DefaultWriter = &ginLogs
+	defer func() { gin.DefaultWriter = previousWriter }()
+
+	logFile := filepath.Join(t.TempDir(), "consumer.log")
+	log, _, err := logger.NewFromConfig(logger.Config{Level: "debug", OutputPaths: []string{logFile}})
+	require.NoError(t, err)
+
+	service := &usersService{user: models.User{Id: 42, Email: "john@example.com"}}
+	router := controller.New(service, controller.WithLogger(log)).Router()
+
+	// a malformed address is not masked by the logger, it must not be logged at all
+	for _, path := range []string{"/users/by-email/john@example.com", "/users?email=john@example.com", "/users?email=john.example.com"} {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+
+	require.NoError(t, log.Sync())
+	logs, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(logs), `"route":"/users/by-email/:email"`)
+	assert.Contains(t, string(logs), `"filters":["deleted_at:eq","email:eq"]`)
+	assert.NotContains(t, string(logs), "example.com")
+	assert.NotContains(t, ginLogs.String(), "john@example.com")
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	for _, span := range spans {
+		for _, attr := range span.Attributes() {
+			assert.False(t, strings.Contains(attr.Value.Emit(), "example.com"), "%s: %s", attr.Key, attr.Value.Emit())
+		}
+	}
+}
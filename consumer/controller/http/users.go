@@ -1,15 +1,80 @@
 package http
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/mail"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/viswals/core/models"
 	"github.com/viswals/core/pkg/utils"
 	"go.uber.org/zap"
 )
 
+// maxUserRequestSize bounds the body of the requests creating and updating a user.
+const maxUserRequestSize = 64 << 10
+
+// userRequest is the body of the requests creating and updating a user. Every field but the parent is required,
+// unless patching where only the fields present are changed.
+type userRequest struct {
+	Email        *string `json:"email"`
+	FirstName    *string `json:"firstname"`
+	LastName     *string `json:"lastname"`
+	ParentUserId *int64  `json:"parent_user_id"`
+}
+
+// validate checks the fields of the request, partial allows the required ones to be missing. id is the user being
+// updated, 0 when creating.
+func (r userRequest) validate(id int64, partial bool) error {
+	required := []struct {
+		name  string
+		value *string
+	}{
+		{name: "email", value: r.Email},
+		{name: "firstname", value: r.FirstName},
+		{name: "lastname", value: r.LastName},
+	}
+
+	for _, field := range required {
+		if field.value == nil {
+			if partial {
+				continue
+			}
+			return fmt.Errorf("%s is required", field.name)
+		}
+
+		if strings.TrimSpace(*field.value) == "" {
+			return fmt.Errorf("%s must not be empty", field.name)
+		}
+	}
+
+	if r.Email != nil {
+		address, err := mail.ParseAddress(*r.Email)
+		if err != nil || address.Address != *r.Email {
+			return errors.New("invalid email")
+		}
+	}
+
+	if r.ParentUserId != nil && (*r.ParentUserId <= 0 || *r.ParentUserId == id) {
+		return errors.New("invalid parent_user_id")
+	}
+
+	return nil
+}
+
+// user returns the user of a request validated in full.
+func (r userRequest) user() models.User {
+	return models.User{Email: *r.Email, FirstName: *r.FirstName, LastName: *r.LastName, ParentUserId: r.ParentUserId}
+}
+
+func (r userRequest) patch() models.UserPatch {
+	return models.UserPatch{Email: r.Email, FirstName: r.FirstName, LastName: r.LastName, ParentUserId: r.ParentUserId}
+}
+
 func (c *Controller) GetAllUsers(g *gin.Context) {
 
 	// pagination details
@@ -25,11 +90,23 @@ func (c *Controller) GetAllUsers(g *gin.Context) {
 		return
 	}
 
+	withDeleted, ok := includeDeleted(g)
+	if !ok {
+		return
+	}
+
 	// create filters
 	queryParams := g.Request.URL.Query()
 	filters := make([]utils.Filter, 0)
 
-	c.logger.Info("query parameters", zap.Any("query", queryParams))
+	// deleted users are only listed on request
+	if !withDeleted {
+		filters = append(filters, utils.Filter{
+			Field:    "deleted_at",
+			Operator: utils.FilterOperatorEq,
+			Value:    nil,
+		})
+	}
 
 	// sort filter
 	sort := g.Query("sort")
@@ -68,11 +145,20 @@ func (c *Controller) GetAllUsers(g *gin.Context) {
 		})
 	}
 
-	c.logger.Debug("filters applied", zap.Any("filters", filters))
+	// filter by email, matched through its blind index
+	if email := queryParams.Get("email"); email != "" {
+		filters = append(filters, utils.Filter{
+			Field:    "email",
+			Operator: utils.FilterOperatorEq,
+			Value:    email,
+		})
+	}
+
+	c.logger.Debug("filters applied", zap.Strings("filters", filterNames(filters)))
 
 	// TODO: add other filters
 
-	users, totalUsers, err := c.usecase.GetAllUsers(g, paginationParams, filters)
+	users, totalUsers, err := c.usecase.GetAllUsers(g.Request.Context(), paginationParams, filters)
 	if err != nil {
 		g.JSON(http.StatusInternalServerError, gin.H{"error": "error while fetching users data"})
 		return
@@ -95,11 +181,215 @@ func (c *Controller) GetUserById(g *gin.Context) {
 		return
 	}
 
-	user, err := c.usecase.GetUserById(g, id)
+	withDeleted, ok := includeDeleted(g)
+	if !ok {
+		return
+	}
+
+	user, err := c.usecase.GetUserById(g.Request.Context(), id)
+	if err == nil && user.DeletedAt != nil && !withDeleted {
+		err = models.ErrUserNotFound
+	}
+	if err != nil {
+		c.userError(g, err, "error while fetching user by id")
+		return
+	}
+
+	g.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// filterNames returns the field and operator of the filters without their values, which may hold an email.
+func filterNames(filters []utils.Filter) []string {
+	names := make([]string, 0, len(filters))
+	for _, filter := range filters {
+		if filter.Sort {
+			names = append(names, filter.Field+":"+filter.Order)
+			continue
+		}
+		names = append(names, filter.Field+":"+string(filter.Operator))
+	}
+
+	return names
+}
+
+// includeDeleted tells whether the request asks for the deleted users as well, with ?include_deleted=true. It responds
+// with 400 and returns false when the parameter is invalid.
+func includeDeleted(g *gin.Context) (include bool, ok bool) {
+	include, err := strconv.ParseBool(g.DefaultQuery("include_deleted", "false"))
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": "invalid include_deleted"})
+		return false, false
+	}
+
+	return include, true
+}
+
+func (c *Controller) GetUserByEmail(g *gin.Context) {
+	c.logger.Info("get user by email")
+
+	email := g.Param("email")
+	if !strings.Contains(email, "@") {
+		g.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+		return
+	}
+
+	user, err := c.usecase.GetUserByEmail(g.Request.Context(), email)
+	if errors.Is(err, models.ErrUserNotFound) {
+		g.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		g.JSON(http.StatusInternalServerError, gin.H{"error": "error while fetching user by email"})
+		return
+	}
+
+	g.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+func (c *Controller) CreateUser(g *gin.Context) {
+	c.logger.Info("create user")
+
+	request, ok := c.bindUserRequest(g, 0, false)
+	if !ok {
+		return
+	}
+
+	user, err := c.usecase.CreateUser(g.Request.Context(), request.user())
+	if err != nil {
+		c.userError(g, err, "error while creating user")
+		return
+	}
+
+	g.Header("Location", fmt.Sprintf("/users/%d", user.Id))
+	g.JSON(http.StatusCreated, gin.H{"data": user})
+}
+
+// ReplaceUser replaces the email, names and parent of the user, a missing parent removes it.
+func (c *Controller) ReplaceUser(g *gin.Context) {
+	c.logger.Info("replace user")
+
+	id, ok := userId(g)
+	if !ok {
+		return
+	}
+
+	request, ok := c.bindUserRequest(g, id, false)
+	if !ok {
+		return
+	}
+
+	user, err := c.usecase.ReplaceUser(g.Request.Context(), id, request.user())
+	if err != nil {
+		c.userError(g, err, "error while updating user")
+		return
+	}
+
+	g.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// PatchUser changes the fields present in the body, the parent can only be removed by ReplaceUser.
+func (c *Controller) PatchUser(g *gin.Context) {
+	c.logger.Info("patch user")
+
+	id, ok := userId(g)
+	if !ok {
+		return
+	}
+
+	request, ok := c.bindUserRequest(g, id, true)
+	if !ok {
+		return
+	}
+
+	user, err := c.usecase.PatchUser(g.Request.Context(), id, request.patch())
 	if err != nil {
-		g.JSON(http.StatusInternalServerError, gin.H{"error": "error while fetching user by id"})
+		c.userError(g, err, "error while updating user")
 		return
 	}
 
 	g.JSON(http.StatusOK, gin.H{"data": user})
 }
+
+// DeleteUser soft deletes the user, it can be restored with RestoreUser.
+func (c *Controller) DeleteUser(g *gin.Context) {
+	c.logger.Info("delete user")
+
+	id, ok := userId(g)
+	if !ok {
+		return
+	}
+
+	err := c.usecase.DeleteUser(g.Request.Context(), id)
+	if err != nil {
+		c.userError(g, err, "error while deleting user")
+		return
+	}
+
+	g.Status(http.StatusNoContent)
+}
+
+func (c *Controller) RestoreUser(g *gin.Context) {
+	c.logger.Info("restore user")
+
+	id, ok := userId(g)
+	if !ok {
+		return
+	}
+
+	user, err := c.usecase.RestoreUser(g.Request.Context(), id)
+	if err != nil {
+		c.userError(g, err, "error while restoring user")
+		return
+	}
+
+	g.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// bindUserRequest decodes and validates the body, it responds with 400 and reports false when it is invalid.
+// Unknown fields are refused, so a misspelled field is not silently left unchanged.
+func (c *Controller) bindUserRequest(g *gin.Context, id int64, partial bool) (request userRequest, ok bool) {
+	decoder := json.NewDecoder(http.MaxBytesReader(g.Writer, g.Request.Body, maxUserRequestSize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		c.logger.Debug("invalid user request", zap.Error(err))
+		g.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return request, false
+	}
+
+	err = request.validate(id, partial)
+	if err != nil {
+		g.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return request, false
+	}
+
+	return request, true
+}
+
+// userId parses the id of the path, it responds with 400 and reports false when it is invalid.
+func userId(g *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(g.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		g.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return 0, false
+	}
+
+	return id, true
+}
+
+// userError responds with the status of an error of the users usecase, message is the error of a failure.
+func (c *Controller) userError(g *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		g.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, models.ErrUserExists):
+		g.JSON(http.StatusConflict, gin.H{"error": "a user with this email already exists"})
+	case errors.Is(err, models.ErrUserDeleted):
+		g.JSON(http.StatusConflict, gin.H{"error": "user is deleted"})
+	case errors.Is(err, models.ErrUserNotDeleted):
+		g.JSON(http.StatusConflict, gin.H{"error": "user is not deleted"})
+	default:
+		g.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
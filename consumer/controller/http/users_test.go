@@ -0,0 +1,235 @@
+package http_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	controller "github.com/viswals/consumer/controller/http"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+	"github.com/viswals/core/models"
+	"github.com/viswals/core/pkg/utils"
+)
+
+// usersService serves a single user, and records the filters of the listed users.
+type usersService struct {
+	user    models.User
+	filters []utils.Filter
+}
+
+func (s *usersService) GetAllUsers(ctx context.Context, pagination utils.PaginationParams, filters []utils.Filter) ([]models.User, int, error) {
+	s.filters = filters
+	return []models.User{s.user}, 1, nil
+}
+
+func (s *usersService) GetUserById(ctx context.Context, id int64) (models.User, error) {
+	if id != s.user.Id {
+		return models.User{}, models.ErrUserNotFound
+	}
+
+	return s.user, nil
+}
+
+func (s *usersService) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if email != s.user.Email {
+		return models.User{}, models.ErrUserNotFound
+	}
+
+	return s.user, nil
+}
+
+// CreateUser refuses the email of the user it serves.
+func (s *usersService) CreateUser(ctx context.Context, user models.User) (models.User, error) {
+	if user.Email == s.user.Email {
+		return models.User{}, models.ErrUserExists
+	}
+
+	user.Id = s.user.Id + 1
+	return user, nil
+}
+
+func (s *usersService) ReplaceUser(ctx context.Context, id int64, user models.User) (models.User, error) {
+	return s.PatchUser(ctx, id, models.UserPatch{Email: &user.Email, FirstName: &user.FirstName, LastName: &user.LastName})
+}
+
+func (s *usersService) PatchUser(ctx context.Context, id int64, patch models.UserPatch) (models.User, error) {
+	if id != s.user.Id {
+		return models.User{}, models.ErrUserNotFound
+	}
+
+	if s.user.DeletedAt != nil {
+		return models.User{}, models.ErrUserDeleted
+	}
+
+	user := s.user
+	if patch.FirstName != nil {
+		user.FirstName = *patch.FirstName
+	}
+
+	return user, nil
+}
+
+func (s *usersService) DeleteUser(ctx context.Context, id int64) error {
+	if id != s.user.Id {
+		return models.ErrUserNotFound
+	}
+
+	if s.user.DeletedAt != nil {
+		return models.ErrUserDeleted
+	}
+
+	return nil
+}
+
+func (s *usersService) RestoreUser(ctx context.Context, id int64) (models.User, error) {
+	if id != s.user.Id {
+		return models.User{}, models.ErrUserNotFound
+	}
+
+	if s.user.DeletedAt == nil {
+		return models.User{}, models.ErrUserNotDeleted
+	}
+
+	return s.user, nil
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+	}{
+		{name: "found", path: "/users/by-email/john@example.com", expectedCode: http.StatusOK},
+		{name: "not found", path: "/users/by-email/jane@example.com", expectedCode: http.StatusNotFound},
+		{name: "invalid email", path: "/users/by-email/john", expectedCode: http.StatusBadRequest},
+		{name: "by id is still routed", path: "/users/42", expectedCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockLogger := mock_interfaces.NewMockILogger(ctrl)
+			mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+
+			service := &usersService{user: models.User{Id: 42, Email: "john@example.com"}}
+			router := controller.New(service, controller.WithLogger(mockLogger)).Router()
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			assert.Equal(t, test.expectedCode, recorder.Code)
+		})
+	}
+}
+
+func TestGetAllUsers_Filters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	active := utils.Filter{Field: "deleted_at", Operator: utils.FilterOperatorEq, Value: nil}
+	email := utils.Filter{Field: "email", Operator: utils.FilterOperatorEq, Value: "john@example.com"}
+
+	tests := []struct {
+		name            string
+		path            string
+		expectedCode    int
+		expectedFilters []utils.Filter
+	}{
+		{name: "without deleted users", path: "/users", expectedCode: http.StatusOK, expectedFilters: []utils.Filter{active}},
+		{name: "with deleted users", path: "/users?include_deleted=true", expectedCode: http.StatusOK, expectedFilters: []utils.Filter{}},
+		{name: "invalid include_deleted", path: "/users?include_deleted=maybe", expectedCode: http.StatusBadRequest},
+		{name: "email", path: "/users?email=john@example.com", expectedCode: http.StatusOK, expectedFilters: []utils.Filter{active, email}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockLogger := mock_interfaces.NewMockILogger(ctrl)
+			mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Debug(gomock.Any(), gomock.Any()).AnyTimes()
+
+			service := &usersService{}
+			router := controller.New(service, controller.WithLogger(mockLogger)).Router()
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			assert.Equal(t, test.expectedFilters, service.filters)
+		})
+	}
+}
+
+func TestUsersCRUD(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	deletedAt := time.Now()
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		deleted      bool
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "create", method: http.MethodPost, path: "/users", body: `{"email":"jane@example.com","firstname":"Jane","lastname":"Doe","parent_user_id":42}`, expectedCode: http.StatusCreated, expectedBody: `"id":43`},
+		{name: "create with existing email", method: http.MethodPost, path: "/users", body: `{"email":"john@example.com","firstname":"John","lastname":"Doe"}`, expectedCode: http.StatusConflict},
+		{name: "create without names", method: http.MethodPost, path: "/users", body: `{"email":"jane@example.com"}`, expectedCode: http.StatusBadRequest, expectedBody: "firstname is required"},
+		{name: "create with blank name", method: http.MethodPost, path: "/users", body: `{"email":"jane@example.com","firstname":" ","lastname":"Doe"}`, expectedCode: http.StatusBadRequest},
+		{name: "create with invalid email", method: http.MethodPost, path: "/users", body: `{"email":"Jane <jane@example.com>","firstname":"Jane","lastname":"Doe"}`, expectedCode: http.StatusBadRequest, expectedBody: "invalid email"},
+		{name: "create with unknown field", method: http.MethodPost, path: "/users", body: `{"email":"jane@example.com","firstname":"Jane","lastname":"Doe","admin":true}`, expectedCode: http.StatusBadRequest},
+		{name: "create with invalid body", method: http.MethodPost, path: "/users", body: `{`, expectedCode: http.StatusBadRequest},
+		{name: "replace", method: http.MethodPut, path: "/users/42", body: `{"email":"john@example.com","firstname":"Johnny","lastname":"Doe"}`, expectedCode: http.StatusOK, expectedBody: `"firstname":"Johnny"`},
+		{name: "replace partially", method: http.MethodPut, path: "/users/42", body: `{"firstname":"Johnny"}`, expectedCode: http.StatusBadRequest},
+		{name: "replace unknown user", method: http.MethodPut, path: "/users/7", body: `{"email":"john@example.com","firstname":"John","lastname":"Doe"}`, expectedCode: http.StatusNotFound},
+		{name: "replace with itself as parent", method: http.MethodPut, path: "/users/42", body: `{"email":"john@example.com","firstname":"John","lastname":"Doe","parent_user_id":42}`, expectedCode: http.StatusBadRequest},
+		{name: "patch", method: http.MethodPatch, path: "/users/42", body: `{"firstname":"Johnny"}`, expectedCode: http.StatusOK, expectedBody: `"firstname":"Johnny"`},
+		{name: "patch deleted user", method: http.MethodPatch, path: "/users/42", body: `{"firstname":"Johnny"}`, deleted: true, expectedCode: http.StatusConflict},
+		{name: "patch with invalid id", method: http.MethodPatch, path: "/users/john", body: `{"firstname":"Johnny"}`, expectedCode: http.StatusBadRequest},
+		{name: "delete", method: http.MethodDelete, path: "/users/42", expectedCode: http.StatusNoContent},
+		{name: "delete deleted user", method: http.MethodDelete, path: "/users/42", deleted: true, expectedCode: http.StatusConflict},
+		{name: "delete unknown user", method: http.MethodDelete, path: "/users/7", expectedCode: http.StatusNotFound},
+		{name: "restore", method: http.MethodPost, path: "/users/42/restore", deleted: true, expectedCode: http.StatusOK},
+		{name: "restore user not deleted", method: http.MethodPost, path: "/users/42/restore", expectedCode: http.StatusConflict},
+		{name: "get unknown user", method: http.MethodGet, path: "/users/7", expectedCode: http.StatusNotFound},
+		{name: "get deleted user", method: http.MethodGet, path: "/users/42", deleted: true, expectedCode: http.StatusNotFound},
+		{name: "get deleted user on request", method: http.MethodGet, path: "/users/42?include_deleted=true", deleted: true, expectedCode: http.StatusOK, expectedBody: `"updated_at"`},
+		{name: "get with invalid include_deleted", method: http.MethodGet, path: "/users/42?include_deleted=maybe", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockLogger := mock_interfaces.NewMockILogger(ctrl)
+			mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Debug(gomock.Any(), gomock.Any()).AnyTimes()
+
+			service := &usersService{user: models.User{Id: 42, Email: "john@example.com", FirstName: "John", LastName: "Doe"}}
+			if test.deleted {
+				service.user.DeletedAt = &deletedAt
+			}
+			router := controller.New(service, controller.WithLogger(mockLogger)).Router()
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			assert.Contains(t, recorder.Body.String(), test.expectedBody)
+		})
+	}
+}
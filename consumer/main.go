@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
 	"github.com/viswals/consumer/config"
 	controller "github.com/viswals/consumer/controller/http"
 	"github.com/viswals/consumer/usecase"
@@ -16,40 +21,120 @@ import (
 	"github.com/viswals/core/infrastructure/redis"
 	"github.com/viswals/core/interfaces"
 	"github.com/viswals/core/pkg/logger"
+	"github.com/viswals/core/pkg/tracing"
 	"go.uber.org/zap"
 )
 
+// abortTimeout bounds how long the workers are waited for once aborted, after the shutdown timeout
+const abortTimeout = 5 * time.Second
+
 func main() {
 	// load app configuration
 	config, err := config.LoadConfig()
 	if err != nil {
-		panic("failed to load configuration")
+		panic("failed to load configuration: " + err.Error())
 	}
 
 	// initialize Logger
-	logger, err := logger.NewDefaultLogger()
+	logger, logLevel, err := logger.NewFromConfig(config.Logger)
 	if err != nil {
-		panic("failed to initialize logger")
+		panic("failed to initialize logger: " + err.Error())
 	}
 	logger.Info("Logger initialized")
 
 	// take consume flag
 	var startConsumerService bool
 	consumeFlag := flag.Bool("consume", false, "consume used to specify wheather to consume data from rabbitmq queue")
+
+	// concurrency flags override the environment
+	flag.IntVar(&config.Workers, "workers", config.Workers, "number of messages, or batches when batching, processed concurrently, the initial number when adaptive")
+	flag.IntVar(&config.Prefetch, "prefetch", config.Prefetch, "number of unacknowledged messages delivered to the consumer, 0 derives it from the workers")
+	flag.BoolVar(&config.Adaptive, "adaptive", config.Adaptive, "scale the workers on queue depth and database latency")
+	flag.IntVar(&config.MinWorkers, "min-workers", config.MinWorkers, "minimum number of workers when adaptive")
+	flag.IntVar(&config.MaxWorkers, "max-workers", config.MaxWorkers, "maximum number of workers when adaptive")
+
+	// re-encryption job, run instead of the service
+	reencrypt := flag.Bool("reencrypt", false, "re-encrypt the encrypted fields of the users with the active encryption key, then exit")
+	reencryptBatchSize := flag.Int("reencrypt-batch-size", 500, "number of users re-encrypted at once")
+	reencryptCheckpoint := flag.String("reencrypt-checkpoint", "reencrypt.checkpoint.json", "file the progress of the re-encryption is saved to and resumed from")
+	createKeystore := flag.Bool("create-keystore", false, "create the keystore with a generated master key, then exit")
+	rotateMasterKey := flag.Bool("rotate-master-key", false, "generate a new master key in the keystore and make it active, then exit")
 	flag.Parse()
 	if consumeFlag != nil {
 		startConsumerService = *consumeFlag
 	}
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName:    config.ServiceName,
+		ServiceVersion: config.ServiceVersion,
+		Exporter:       config.TracingExporter,
+	})
+	if err != nil {
+		logger.Fatal("failed to set up tracing", zap.Error(err))
+	}
+
+	if (*createKeystore || *rotateMasterKey) && (config.KeyProvider == nil || config.KeyProvider.Type != encryption.KeyProviderKeystore) {
+		logger.Fatal("master keys are only generated in the keystore, set ENCRYPTION_KEY_PROVIDER=keystore")
+	}
+
+	if *createKeystore {
+		keystore, err := encryption.CreateKeystore(config.KeyProvider.KeystorePath)
+		if err != nil {
+			logger.Fatal("failed to create keystore", zap.Error(err))
+		}
+
+		logger.Info("keystore created", zap.String("path", config.KeyProvider.KeystorePath), zap.String("key_id", keystore.KeyID()))
+		return
+	}
+
+	if *rotateMasterKey {
+
+		keystore, err := encryption.OpenKeystore(config.KeyProvider.KeystorePath)
+		if err != nil {
+			logger.Fatal("failed to open keystore", zap.Error(err))
+		}
+
+		keyID, err := keystore.Rotate()
+		if err != nil {
+			logger.Fatal("failed to rotate master key", zap.Error(err))
+		}
+
+		logger.Info("master key rotated", zap.String("key_id", keyID))
+		return
+	}
+
+	if *reencrypt {
+		err = reencryptUsers(config, logger, usecase.ReencryptOptions{BatchSize: *reencryptBatchSize, CheckpointPath: *reencryptCheckpoint})
+		if err != nil {
+			logger.Error("failed to re-encrypt users", zap.Error(err))
+		}
+
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("failed to flush spans", zap.Error(err))
+		}
+
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// initialize RabbitMQ
-	rmq, err := rabbitmq.New(config.RabbitMQURL, 10, time.Second*5)
+	rmq, err := rabbitmq.New(config.RabbitMQURL, 10, time.Second*5, rabbitmq.WithLogger(logger))
 	if err != nil {
 		logger.Fatal("failed to initialize RabbitMQ", zap.Error(err))
 	}
-	defer rmq.Channel.Close()
-	defer rmq.Close()
 
-	queue, err := rmq.QueueDeclare(config.QueueName, rabbitmq.WithDurable(true))
+	// log connection state changes, the connection is re-established automatically
+	go func() {
+		for event := range rmq.NotifyState(make(chan rabbitmq.StateEvent, 10)) {
+			logger.Info("RabbitMQ connection state changed", zap.Stringer("state", event.State), zap.Int("attempt", event.Attempt), zap.Error(event.Err))
+		}
+	}()
+
+	// rejected messages of the queue are dead-lettered by the broker
+	queue, err := rmq.QueueDeclare(config.QueueName, rabbitmq.WithDurable(true),
+		rabbitmq.WithDeadLetterExchange("", rabbitmq.DeadLetterQueueName(config.QueueName)))
 	if err != nil {
 		logger.Fatal("failed to declare queue", zap.Error(err))
 	}
@@ -67,41 +152,214 @@ func main() {
 		cm = redis.NewNoOpCache()
 	}
 
-	em, err := encryption.New([]byte(config.EncryptionKey))
+	em, err := newEncryptionService(config)
 	if err != nil {
 		logger.Fatal("failed to initialize encryption manager", zap.Error(err))
 	}
 
-	usecase := usecase.New(postgresDB, rmq, em, usecase.WithLogger(logger), usecase.WithCacheManager(cm))
+	bi, err := encryption.NewBlindIndex([]byte(config.BlindIndexKey))
+	if err != nil {
+		logger.Fatal("failed to initialize email blind index", zap.Error(err))
+	}
+
+	usecaseOptions := []usecase.Option{
+		usecase.WithLogger(logger),
+		usecase.WithCacheManager(cm),
+		usecase.WithBlindIndex(bi),
+		usecase.WithRetryPolicy(config.RetryPolicy),
+		usecase.WithBatching(config.BatchSize, config.BatchMaxLatency),
+		usecase.WithConcurrency(config.Workers, config.Prefetch),
+	}
+
+	if config.Adaptive {
+		usecaseOptions = append(usecaseOptions, usecase.WithAdaptiveConcurrency(usecase.AdaptiveConcurrency{
+			MinWorkers:      config.MinWorkers,
+			MaxWorkers:      config.MaxWorkers,
+			Interval:        config.AdaptiveInterval,
+			TargetDBLatency: config.TargetDBLatency,
+		}))
+	}
+
+	usecase := usecase.New(postgresDB, rmq, em, usecaseOptions...)
+
+	// declared with the retry policy of the usecase, the one failed messages are republished with
+	err = usecase.DeclareRetryTopology(config.QueueName, rabbitmq.WithDurable(true))
+	if err != nil {
+		logger.Fatal("failed to declare retry queues", zap.Error(err))
+	}
 
 	httpMux := http.NewServeMux()
-	httpController := controller.New(usecase, controller.WithHttpMux(httpMux), controller.WithHttpPort(config.HttpPort))
+	// the cache is optional, without it the service is degraded but still ready.
+	// RabbitMQ is only required to consume messages, the API is served from the database
+	healthChecks := []controller.HealthCheck{
+		{Name: "postgres", Checker: postgresDB, Critical: true},
+		{Name: "rabbitmq", Checker: rmq, Critical: startConsumerService},
+	}
+	if checker, ok := cm.(interfaces.IHealthChecker); ok {
+		healthChecks = append(healthChecks, controller.HealthCheck{Name: "redis", Checker: checker})
+	}
+
+	httpController := controller.New(usecase, controller.WithLogger(logger), controller.WithHttpMux(httpMux), controller.WithHttpPort(config.HttpPort),
+		controller.WithHealthChecks(healthChecks...), controller.WithLogLevel(logLevel))
 
-	// wg for grpc and http servers
-	var wg sync.WaitGroup
+	// stop consuming and serving on SIGINT / SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	wg.Add(2)
+	consumerDone := make(chan struct{})
 	go func() {
-		defer wg.Done()
+		defer close(consumerDone)
 
 		if startConsumerService {
 			logger.Info("starting consumer service")
-			usecase.ConsumeUserData(context.Background(), config.QueueName)
+			usecase.ConsumeUserData(ctx, config.QueueName)
 		} else {
 			logger.Info("consume flag not passed, skipping consumer service")
 		}
 	}()
 
+	serverErr := make(chan error, 1)
 	go func() {
-		defer wg.Done()
 		logger.Info("starting http server", zap.String("port", config.HttpPort))
-		if err := httpController.Start(); err != nil {
-			logger.Error("cannot run http server", zap.Error(err), zap.String("port", config.HttpPort))
-			panic(err)
+		if err := httpController.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
 		}
 	}()
 
-	wg.Wait()
+	select {
+	case <-ctx.Done():
+		logger.Info("shutdown signal received, stopping consumer")
+	case err := <-serverErr:
+		logger.Error("cannot run http server", zap.Error(err), zap.String("port", config.HttpPort))
+	}
+
+	// cancels the consumer when the http server failed
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	// stop accepting requests and wait for the in-flight ones
+	err = httpController.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Error("failed to shut down http server", zap.Error(err))
+	}
+
+	// wait for the workers to process and acknowledge the messages already received
+	select {
+	case <-consumerDone:
+	case <-shutdownCtx.Done():
+		logger.Warn("consumer did not drain before the shutdown timeout, aborting, unacknowledged messages are delivered again")
+
+		// the database is only closed once the workers stopped writing to it
+		usecase.Abort()
+		select {
+		case <-consumerDone:
+		case <-time.After(abortTimeout):
+			logger.Error("consumer workers did not stop after being aborted")
+		}
+	}
+
+	if err := postgresDB.Close(); err != nil {
+		logger.Error("failed to close postgres", zap.Error(err))
+	}
+
+	if err := cm.Close(); err != nil {
+		logger.Error("failed to close redis", zap.Error(err))
+	}
+
+	if err := rmq.Close(); err != nil {
+		logger.Error("failed to close RabbitMQ", zap.Error(err))
+	}
+
+	// flush the spans of the last messages and requests
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("failed to flush spans", zap.Error(err))
+	}
+
 	logger.Info("consumer stopped!")
-	// Initialize redis cache layer for consumer
+}
+
+// newEncryptionService returns the envelope encryption of the key provider when there is one, with the keyring
+// decrypting the values written before, and the keyring otherwise.
+func newEncryptionService(cfg *config.Config) (interfaces.IEncryptionService, error) {
+	hasher, err := encryption.NewHasher(cfg.HashParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyring *encryption.Keyring
+	if len(cfg.EncryptionKeys) > 0 || cfg.KeyProvider == nil {
+		keyring, err = encryption.NewKeyring(cfg.EncryptionKeys, cfg.EncryptionActiveKeyID, encryption.WithAlgorithm(cfg.EncryptionAlgorithm),
+			encryption.WithHasher(hasher))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.KeyProvider == nil {
+		return keyring, nil
+	}
+
+	provider, err := encryption.NewKeyProvider(cfg.KeyProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize key provider: %w", err)
+	}
+
+	options := []encryption.EnvelopeOption{encryption.WithDataKeyUses(cfg.EncryptionDataKeyUses), encryption.WithEnvelopeHasher(hasher)}
+	if keyring != nil {
+		options = append(options, encryption.WithFallback(keyring))
+	}
+
+	return encryption.NewEnvelope(provider, options...), nil
+}
+
+// reencryptUsers encrypts the fields of the users again with the active key and fills their missing blind index and digest,
+// until every user is done or SIGINT / SIGTERM.
+// The progress is saved to the checkpoint, so an interrupted job resumes where it stopped.
+func reencryptUsers(cfg *config.Config, logger *otelzap.Logger, options usecase.ReencryptOptions) error {
+	em, err := newEncryptionService(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize encryption manager: %w", err)
+	}
+
+	bi, err := encryption.NewBlindIndex([]byte(cfg.BlindIndexKey))
+	if err != nil {
+		return fmt.Errorf("failed to initialize email blind index: %w", err)
+	}
+
+	postgresDB, err := postgres.New(cfg.DBConfig)
+	if err != nil {
+		return fmt.Errorf("failed to initialize postgres: %w", err)
+	}
+	defer postgresDB.Close()
+
+	// cached users are invalidated as they are re-encrypted
+	var cm interfaces.ICacheService
+	cm, err = redis.New(cfg.RedisConfig)
+	if err != nil {
+		logger.Warn("failed to initialize redis, cached users keep the former ciphertext until they expire", zap.Error(err))
+		cm = redis.NewNoOpCache()
+	}
+	defer cm.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	options.Progress = func(progress usecase.ReencryptProgress) {
+		logger.Info("re-encrypted a batch of users", zap.Int64("last_id", progress.LastId), zap.Int64("scanned", progress.Scanned),
+			zap.Int64("reencrypted", progress.Reencrypted), zap.Int64("indexed", progress.Indexed), zap.Int64("conflicted", progress.Conflicted),
+			zap.Int64("failed", progress.Failed))
+	}
+
+	logger.Info("re-encrypting users", zap.String("active_key_id", cfg.EncryptionActiveKeyID), zap.Bool("envelope", cfg.KeyProvider != nil))
+	uc := usecase.New(postgresDB, nil, em, usecase.WithLogger(logger), usecase.WithCacheManager(cm), usecase.WithBlindIndex(bi))
+
+	progress, err := uc.ReencryptUsers(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("users re-encrypted", zap.Any("progress", progress))
+	return nil
 }
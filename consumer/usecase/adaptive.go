@@ -0,0 +1,200 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultWorkers                 = 10
+	defaultAdaptiveInterval        = 5 * time.Second
+	defaultAdaptiveTargetDBLatency = 100 * time.Millisecond
+)
+
+// AdaptiveConcurrency scales the workers between MinWorkers and MaxWorkers.
+// Workers are added while messages pile up in the queue, and removed when the database slows down or the queue is empty.
+type AdaptiveConcurrency struct {
+	MinWorkers int
+	MaxWorkers int
+	// Interval between two adjustments of the number of workers
+	Interval time.Duration
+	// TargetDBLatency is the average database write latency above which workers are removed, the write of a batch when batching
+	TargetDBLatency time.Duration
+}
+
+// NextWorkers returns the number of workers to run, given the messages ready in the queue and the average
+// database write latency since the last adjustment. A negative depth means the queue depth is unknown.
+// Workers grow by a quarter while the queue holds more messages than workers, and are halved when the
+// database is slower than the target, so an overloaded database recovers quickly.
+func (a AdaptiveConcurrency) NextWorkers(current int, depth int, latency time.Duration) int {
+	next := current
+
+	switch {
+	case latency > a.TargetDBLatency:
+		next = current / 2
+	case depth > current:
+		next = current + max(1, current/4)
+	case depth == 0:
+		next = current - 1
+	}
+
+	return min(max(next, a.MinWorkers), a.MaxWorkers)
+}
+
+func (a *AdaptiveConcurrency) setDefaults() {
+	if a.MinWorkers <= 0 {
+		a.MinWorkers = 1
+	}
+
+	if a.MaxWorkers < a.MinWorkers {
+		a.MaxWorkers = a.MinWorkers
+	}
+
+	if a.Interval <= 0 {
+		a.Interval = defaultAdaptiveInterval
+	}
+
+	if a.TargetDBLatency <= 0 {
+		a.TargetDBLatency = defaultAdaptiveTargetDBLatency
+	}
+}
+
+// startAdapting resizes the worker pool in the background when the concurrency is adaptive.
+// The returned function stops resizing it and waits until it no longer is.
+func (c *ConsumerUsecase) startAdapting(ctx context.Context, queue string, pool *workerPool) (stop func()) {
+	if c.adaptive == nil {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	adapted := make(chan struct{})
+	go func() {
+		defer close(adapted)
+		c.adaptWorkers(ctx, queue, pool)
+	}()
+
+	return func() {
+		cancel()
+		<-adapted
+	}
+}
+
+// adaptWorkers resizes the worker pool every interval until the context is done.
+// When batching, every worker writes a batch at a time, so the queue depth is counted in batches.
+func (c *ConsumerUsecase) adaptWorkers(ctx context.Context, queue string, pool *workerPool) {
+	ticker := time.NewTicker(c.adaptive.Interval)
+	defer ticker.Stop()
+
+	// only the latency of the current interval matters
+	c.dbLatency.reset()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		depth := -1
+		q, err := c.rmq.QueueInspect(queue)
+		if err != nil {
+			c.logger.Warn("failed to inspect queue, scaling on database latency only", zap.String("queue", queue), zap.Error(err))
+		} else {
+			depth = (q.Messages + c.batchSize - 1) / c.batchSize
+		}
+
+		latency := c.dbLatency.reset()
+		current := pool.size()
+		next := c.adaptive.NextWorkers(current, depth, latency)
+		if next == current {
+			continue
+		}
+
+		pool.resize(next)
+		c.logger.Info("Consumer workers resized", zap.Int("from", current), zap.Int("to", next),
+			zap.Int("queue_depth", depth), zap.Duration("db_latency", latency))
+	}
+}
+
+// workerPool runs a resizable number of workers. Removed workers finish the message they are processing first.
+type workerPool struct {
+	run func(quit <-chan struct{})
+
+	mu    sync.Mutex
+	wg    sync.WaitGroup
+	quits []chan struct{}
+}
+
+func newWorkerPool(size int, run func(quit <-chan struct{})) *workerPool {
+	pool := &workerPool{run: run}
+	pool.resize(size)
+
+	return pool
+}
+
+func (p *workerPool) resize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.quits) < size {
+		quit := make(chan struct{})
+		p.quits = append(p.quits, quit)
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run(quit)
+		}()
+	}
+
+	for len(p.quits) > size {
+		last := len(p.quits) - 1
+		close(p.quits[last])
+		p.quits = p.quits[:last]
+	}
+}
+
+func (p *workerPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.quits)
+}
+
+// wait waits for every worker to return, including the removed ones.
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
+
+// latencyTracker averages the latencies observed since it was last reset.
+type latencyTracker struct {
+	mu    sync.Mutex
+	total time.Duration
+	count int
+}
+
+func (l *latencyTracker) observe(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total += latency
+	l.count++
+}
+
+// reset returns the average latency and starts over.
+func (l *latencyTracker) reset() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var average time.Duration
+	if l.count > 0 {
+		average = l.total / time.Duration(l.count)
+	}
+
+	l.total, l.count = 0, 0
+
+	return average
+}
@@ -0,0 +1,36 @@
+package usecase_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals/consumer/usecase"
+)
+
+func TestAdaptiveConcurrency_NextWorkers(t *testing.T) {
+	adaptive := usecase.AdaptiveConcurrency{MinWorkers: 2, MaxWorkers: 20, TargetDBLatency: 100 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		current  int
+		depth    int
+		latency  time.Duration
+		expected int
+	}{
+		{name: "backlog adds a quarter of the workers", current: 8, depth: 500, latency: 20 * time.Millisecond, expected: 10},
+		{name: "backlog adds at least one worker", current: 2, depth: 500, latency: 20 * time.Millisecond, expected: 3},
+		{name: "workers do not exceed the maximum", current: 19, depth: 500, latency: 20 * time.Millisecond, expected: 20},
+		{name: "slow database halves the workers despite the backlog", current: 16, depth: 500, latency: 300 * time.Millisecond, expected: 8},
+		{name: "workers do not go below the minimum", current: 3, depth: 500, latency: 300 * time.Millisecond, expected: 2},
+		{name: "empty queue removes a worker", current: 8, depth: 0, latency: 20 * time.Millisecond, expected: 7},
+		{name: "workers keeping up are kept", current: 8, depth: 5, latency: 20 * time.Millisecond, expected: 8},
+		{name: "unknown depth only scales on latency", current: 8, depth: -1, latency: 20 * time.Millisecond, expected: 8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, adaptive.NextWorkers(test.current, test.depth, test.latency))
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/viswals/consumer/usecase/repository/database"
+	"github.com/viswals/core/models"
+	"github.com/viswals/core/pkg/metrics"
+	"github.com/viswals/core/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBatchMaxLatency = 200 * time.Millisecond
+
+	// the prefetch count is a 16 bit field of the protocol
+	maxPrefetch = math.MaxUint16
+)
+
+// consumeBatches groups the deliveries into batches and writes them with the worker pool, a batch per worker.
+// A batch is written once it holds batchSize messages, or batchMaxLatency after its first message arrived.
+// Once ctx is done the pending batch is written with processCtx, which outlives it.
+func (c *ConsumerUsecase) consumeBatches(ctx context.Context, processCtx context.Context, queue string, consumeCh <-chan amqp091.Delivery) {
+	batches := make(chan pendingBatch, c.workers)
+
+	pool := newWorkerPool(c.workers, func(quit <-chan struct{}) {
+		c.batchWorker(processCtx, queue, batches, quit)
+	})
+	stopAdapting := c.startAdapting(ctx, queue, pool)
+
+	c.logger.Info("Consumer started", zap.String("queue", queue), zap.Int("workers", c.workers), zap.Int("prefetch", c.prefetch),
+		zap.Int("batch_size", c.batchSize), zap.Bool("adaptive", c.adaptive != nil))
+
+	// batches are written concurrently but acknowledged in delivery order, as required to acknowledge them with
+	// multiple=true, every batch waits for the previous one to be settled
+	settled := make(chan struct{})
+	close(settled)
+	batch := make([]amqp091.Delivery, 0, c.batchSize)
+	timer := time.NewTimer(c.batchMaxLatency)
+	timer.Stop()
+
+	flush := func() {
+		timer.Stop()
+		if len(batch) == 0 {
+			return
+		}
+
+		done := make(chan struct{})
+		batches <- pendingBatch{deliveries: batch, previous: settled, settled: done}
+		batch = make([]amqp091.Delivery, 0, c.batchSize)
+		settled = done
+	}
+
+	defer func() {
+		// the pool is no longer resized while it drains
+		stopAdapting()
+		close(batches)
+		pool.wait()
+		c.logger.Info("Consumer batches drained")
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Info("Context done, stopping consumer")
+			flush()
+			return
+		case <-timer.C:
+			flush()
+		case msg, ok := <-consumeCh:
+			if !ok {
+				c.logger.Warn("Consumer channel closed, stopping")
+				flush()
+				return
+			}
+
+			metrics.Messages.WithLabelValues(queue, metrics.MessageConsumed).Inc()
+			if len(batch) == 0 {
+				timer.Reset(c.batchMaxLatency)
+			}
+
+			batch = append(batch, msg)
+			if len(batch) >= c.batchSize {
+				flush()
+			}
+		}
+	}
+}
+
+// pendingBatch is a batch waiting for a worker. Its messages are acknowledged once previous is closed, and settled is
+// closed once they are.
+type pendingBatch struct {
+	deliveries []amqp091.Delivery
+	previous   <-chan struct{}
+	settled    chan struct{}
+}
+
+// batchWorker writes batches until the channel is closed or quit is closed to remove the worker.
+func (c *ConsumerUsecase) batchWorker(ctx context.Context, queue string, batches <-chan pendingBatch, quit <-chan struct{}) {
+	for {
+		var batch pendingBatch
+		select {
+		case <-quit:
+			return
+		case pending, ok := <-batches:
+			if !ok {
+				return
+			}
+			batch = pending
+		}
+
+		// aborted, the batches left are delivered again
+		if ctx.Err() != nil {
+			close(batch.settled)
+			continue
+		}
+
+		metrics.WorkersBusy.Inc()
+		c.processBatch(ctx, queue, batch.deliveries, batch.previous)
+		metrics.WorkersBusy.Dec()
+		close(batch.settled)
+	}
+}
+
+// ProcessBatch writes the users of the deliveries in a single transaction and acknowledges them together.
+// Messages which can not be parsed are retried or dead-lettered on their own. If writing the batch fails,
+// its messages are written one by one, so only the bad message is retried.
+// Every message continues its own trace, the write of the batch is traced separately with links to them.
+func (c *ConsumerUsecase) ProcessBatch(ctx context.Context, queue string, deliveries []amqp091.Delivery) {
+	c.processBatch(ctx, queue, deliveries, nil)
+}
+
+// processBatch is ProcessBatch, acknowledging the batch once previous is closed. A nil previous does not wait.
+func (c *ConsumerUsecase) processBatch(ctx context.Context, queue string, deliveries []amqp091.Delivery, previous <-chan struct{}) {
+	var pending []batchMessage
+
+	for _, msg := range deliveries {
+		msgCtx, span := startConsumeSpan(ctx, queue, msg)
+
+		user, err := c.prepareUser(msgCtx, msg.Body)
+		if err != nil {
+			c.logger.Error("failed to process message", zap.Error(err))
+			c.handleFailedMessage(msgCtx, queue, msg, err)
+			tracing.End(span, err)
+			continue
+		}
+
+		pending = append(pending, batchMessage{ctx: msgCtx, span: span, msg: msg, user: user})
+	}
+
+	if len(pending) == 0 {
+		return
+	}
+
+	users := make([]models.User, len(pending))
+	messages := make([]amqp091.Delivery, len(pending))
+	links := make([]trace.Link, len(pending))
+	for i, message := range pending {
+		users[i] = message.user
+		messages[i] = message.msg
+		links[i] = trace.LinkFromContext(message.ctx)
+	}
+
+	batchCtx, batchSpan := tracing.Start(ctx, "write batch", trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(users))))
+
+	start := time.Now()
+	results, err := c.db.UpsertUsers(batchCtx, users)
+	latency := time.Since(start)
+	c.dbLatency.observe(latency)
+	metrics.DBWriteDuration.WithLabelValues("upsert_users").Observe(latency.Seconds())
+	tracing.End(batchSpan, err)
+	if err != nil {
+		c.logger.Warn("failed to write batch, writing its messages one by one", zap.Int("size", len(users)), zap.Error(err))
+
+		for _, message := range pending {
+			err := c.upsertAndAck(message.ctx, queue, message.msg, message.user)
+			tracing.End(message.span, err)
+		}
+		return
+	}
+
+	if previous != nil {
+		select {
+		case <-previous:
+		case <-ctx.Done():
+			// aborted, the messages are delivered again and written once more
+			for _, message := range pending {
+				tracing.End(message.span, ctx.Err())
+			}
+			return
+		}
+	}
+
+	c.ackBatch(queue, messages)
+	for _, message := range pending {
+		tracing.End(message.span, nil)
+	}
+
+	counts := make(map[database.UpsertResult]int)
+	for _, result := range results {
+		counts[result.Result]++
+	}
+
+	c.logger.Info("Processed and saved user data batch", zap.Int("size", len(users)),
+		zap.Int("inserted", counts[database.UpsertResultInserted]),
+		zap.Int("updated", counts[database.UpsertResultUpdated]),
+		zap.Int("skipped", counts[database.UpsertResultSkipped]))
+}
+
+// batchMessage is a message of a batch waiting for the batch to be written.
+type batchMessage struct {
+	// ctx carries the span of the message, it ends once the message is acknowledged
+	ctx  context.Context
+	span trace.Span
+	msg  amqp091.Delivery
+	user models.User
+}
+
+// upsertAndAck writes a single user and acknowledges its message, or hands it over to the retry queues.
+// It returns the error which failed the message, if any.
+func (c *ConsumerUsecase) upsertAndAck(ctx context.Context, queue string, msg amqp091.Delivery, user models.User) error {
+	userId, result, err := c.upsertUser(ctx, user)
+	if err != nil {
+		err = fmt.Errorf("failed to upsert user in database: %w", err)
+		c.logger.Error("failed to process message", zap.Error(err))
+		c.handleFailedMessage(ctx, queue, msg, err)
+		return err
+	}
+
+	err = msg.Ack(false)
+	if err != nil {
+		c.logger.Error("failed to acknowledge message", zap.Error(err))
+	} else {
+		metrics.Messages.WithLabelValues(queue, metrics.MessageAcked).Inc()
+	}
+
+	c.logger.Info("Processed and saved user data", zap.String("user_id", userId), zap.String("result", string(result)))
+
+	return nil
+}
+
+// ackBatch acknowledges the deliveries with multiple=true, acknowledging the last one also acknowledges the previous
+// ones. Delivery tags start over on every channel, so it is done once per channel the deliveries came from.
+// Every other message delivered before them on the channel must have been acknowledged or rejected already.
+func (c *ConsumerUsecase) ackBatch(queue string, deliveries []amqp091.Delivery) {
+	last := make(map[amqp091.Acknowledger]int)
+	count := make(map[amqp091.Acknowledger]int)
+	for i, msg := range deliveries {
+		last[msg.Acknowledger] = i
+		count[msg.Acknowledger]++
+	}
+
+	for i, msg := range deliveries {
+		if last[msg.Acknowledger] != i {
+			continue
+		}
+
+		err := msg.Ack(true)
+		if err != nil {
+			c.logger.Error("failed to acknowledge message batch", zap.Uint64("deliveryTag", msg.DeliveryTag), zap.Error(err))
+			continue
+		}
+
+		metrics.Messages.WithLabelValues(queue, metrics.MessageAcked).Add(float64(count[msg.Acknowledger]))
+	}
+}
@@ -0,0 +1,190 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals/consumer/usecase"
+	"github.com/viswals/consumer/usecase/repository/database"
+	mock_usecase "github.com/viswals/consumer/usecase/repository/database/mock"
+	"github.com/viswals/core/infrastructure/postgres"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+	"github.com/viswals/core/models"
+)
+
+// acknowledger records the acknowledgements of the deliveries.
+type acknowledger struct {
+	mu    sync.Mutex
+	acks  []ack
+	nacks []uint64
+}
+
+type ack struct {
+	tag      uint64
+	multiple bool
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.acks = append(a.acks, ack{tag: tag, multiple: multiple})
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.nacks = append(a.nacks, tag)
+	return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.nacks = append(a.nacks, tag)
+	return nil
+}
+
+func TestProcessBatch(t *testing.T) {
+	validMessage := func(id string) []byte {
+		return []byte(`{"Id":` + id + `,"FirstName":"Test","LastName":"User","Email":"test@example.com","ParentUserId":-1}`)
+	}
+
+	tests := []struct {
+		name         string
+		bodies       [][]byte
+		mockFunc     func(repo *mock_usecase.MockIConsumerRepository, queue *mock_interfaces.MockIQueueService)
+		expectedAcks []ack
+	}{
+		{
+			name:   "batch is written at once and acknowledged with its last message",
+			bodies: [][]byte{validMessage("1"), validMessage("2"), validMessage("3")},
+			mockFunc: func(repo *mock_usecase.MockIConsumerRepository, queue *mock_interfaces.MockIQueueService) {
+				repo.EXPECT().UpsertUsers(gomock.Any(), gomock.Len(3)).Return([]database.UpsertedUser{
+					{Id: "a", Result: database.UpsertResultInserted},
+					{Id: "b", Result: database.UpsertResultUpdated},
+					{Id: "c", Result: database.UpsertResultSkipped},
+				}, nil)
+			},
+			expectedAcks: []ack{{tag: 3, multiple: true}},
+		},
+		{
+			name:   "malformed message is dead-lettered on its own",
+			bodies: [][]byte{validMessage("1"), []byte("{invalid"), validMessage("3")},
+			mockFunc: func(repo *mock_usecase.MockIConsumerRepository, queue *mock_interfaces.MockIQueueService) {
+				queue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).Return(nil)
+				repo.EXPECT().UpsertUsers(gomock.Any(), gomock.Len(2)).Return(make([]database.UpsertedUser, 2), nil)
+			},
+			expectedAcks: []ack{{tag: 2, multiple: false}, {tag: 3, multiple: true}},
+		},
+		{
+			name:   "failed batch is written one by one to isolate the bad message",
+			bodies: [][]byte{validMessage("1"), validMessage("2"), validMessage("3")},
+			mockFunc: func(repo *mock_usecase.MockIConsumerRepository, queue *mock_interfaces.MockIQueueService) {
+				repo.EXPECT().UpsertUsers(gomock.Any(), gomock.Len(3)).Return(nil, errors.New("batch failed"))
+				gomock.InOrder(
+					repo.EXPECT().UpsertUser(gomock.Any(), gomock.Any()).Return("a", database.UpsertResultInserted, nil),
+					repo.EXPECT().UpsertUser(gomock.Any(), gomock.Any()).Return("", database.UpsertResult(""), constraintError{code: "23503"}),
+					repo.EXPECT().UpsertUser(gomock.Any(), gomock.Any()).Return("c", database.UpsertResultInserted, nil),
+				)
+				// the constraint violation can not succeed later, it goes straight to the dead letter queue
+				queue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedAcks: []ack{{tag: 1}, {tag: 2}, {tag: 3}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockRepo := mock_usecase.NewMockIConsumerRepository(ctrl)
+			mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+			mockEncryption := mock_interfaces.NewMockIEncryptionService(ctrl)
+			mockLogger := mock_interfaces.NewMockILogger(ctrl)
+
+			mockEncryption.EXPECT().Encrypt(gomock.Any()).Return("encrypted", nil).AnyTimes()
+			mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+			test.mockFunc(mockRepo, mockQueue)
+
+			consumer := usecase.New(postgres.Postgres{}, nil, mockEncryption, usecase.WithLogger(mockLogger),
+				usecase.WithRepository(mockRepo), usecase.WithQueueService(mockQueue))
+
+			acknowledger := &acknowledger{}
+			deliveries := make([]amqp091.Delivery, len(test.bodies))
+			for i, body := range test.bodies {
+				deliveries[i] = amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: uint64(i + 1), Body: body}
+			}
+
+			consumer.ProcessBatch(context.Background(), "users", deliveries)
+
+			assert.Equal(t, test.expectedAcks, acknowledger.acks)
+			assert.Empty(t, acknowledger.nacks)
+		})
+	}
+}
+
+func TestConsumeUserData_ConcurrentBatches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mock_usecase.NewMockIConsumerRepository(ctrl)
+	mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+	mockEncryption := mock_interfaces.NewMockIEncryptionService(ctrl)
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+
+	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	mockEncryption.EXPECT().Encrypt(gomock.Any()).Return("encrypted", nil).AnyTimes()
+
+	deliveries := make(chan amqp091.Delivery)
+	mockQueue.EXPECT().Qos(6, 0, false).Return(nil)
+	mockQueue.EXPECT().ConsumeWithContext(gomock.Any(), "users", gomock.Any()).Return((<-chan amqp091.Delivery)(deliveries), nil)
+
+	// the first batch is only written once the second one is, they are written concurrently
+	secondWritten := make(chan struct{})
+	var writes atomic.Int32
+	mockRepo.EXPECT().UpsertUsers(gomock.Any(), gomock.Len(2)).DoAndReturn(
+		func(ctx context.Context, users []models.User) ([]database.UpsertedUser, error) {
+			if writes.Add(1) == 1 {
+				<-secondWritten
+			} else {
+				close(secondWritten)
+			}
+			return make([]database.UpsertedUser, len(users)), nil
+		}).Times(2)
+
+	consumer := usecase.New(postgres.Postgres{}, nil, mockEncryption, usecase.WithLogger(mockLogger),
+		usecase.WithRepository(mockRepo), usecase.WithQueueService(mockQueue),
+		usecase.WithBatching(2, time.Minute), usecase.WithConcurrency(2, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.ConsumeUserData(ctx, "users")
+	}()
+
+	acknowledger := &acknowledger{}
+	for tag := uint64(1); tag <= 4; tag++ {
+		deliveries <- amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: tag, Body: []byte(`{"Id":1,"Email":"test@example.com"}`)}
+	}
+
+	cancel()
+	<-done
+
+	// the second batch is acknowledged after the first, acknowledging it with multiple=true would acknowledge both
+	assert.Equal(t, []ack{{tag: 2, multiple: true}, {tag: 4, multiple: true}}, acknowledger.acks)
+}
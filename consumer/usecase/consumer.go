@@ -3,18 +3,38 @@ package usecase
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/rabbitmq/amqp091-go"
+	"github.com/viswals/consumer/usecase/repository/database"
 	coreDto "github.com/viswals/core/dto"
+	"github.com/viswals/core/infrastructure/encryption"
+	"github.com/viswals/core/infrastructure/postgres"
 	"github.com/viswals/core/infrastructure/rabbitmq"
 	"github.com/viswals/core/models"
+	"github.com/viswals/core/pkg/logger"
+	"github.com/viswals/core/pkg/metrics"
+	"github.com/viswals/core/pkg/tracing"
 	"github.com/viswals/core/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// ConsumeUserData consumes the queue until the context is done. The consumer is then cancelled and the call returns
+// once the messages already received have been processed and acknowledged.
 func (c *ConsumerUsecase) ConsumeUserData(ctx context.Context, queue string) {
+	// messages already received are still written once the context is done, rather than interrupted mid-insert,
+	// unless the processing is aborted
+	processCtx, cancelProcessing := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelProcessing()
+	stopAbort := context.AfterFunc(c.aborted, cancelProcessing)
+	defer stopAbort()
 
-	err := c.rmq.Qos(10, 0, false) // Prefetch up to 10 messages per consumer
+	err := c.rmq.Qos(c.prefetch, 0, false)
 	if err != nil {
 		c.logger.Error("failed to set QoS for consumer", zap.Error(err))
 		return
@@ -26,78 +46,284 @@ func (c *ConsumerUsecase) ConsumeUserData(ctx context.Context, queue string) {
 		return
 	}
 
-	// Worker pool configuration
-	workerCount := 10
-	messageChan := make(chan amqp091.Delivery, workerCount)
+	if c.batchSize > 1 {
+		c.consumeBatches(ctx, processCtx, queue, consumeCh)
+		return
+	}
+
+	messageChan := make(chan amqp091.Delivery, c.workers)
 
 	// Start worker goroutines
-	for i := 0; i < workerCount; i++ {
-		go c.worker(ctx, messageChan)
-	}
+	pool := newWorkerPool(c.workers, func(quit <-chan struct{}) {
+		c.worker(processCtx, queue, messageChan, quit)
+	})
+
+	stopAdapting := c.startAdapting(ctx, queue, pool)
+
+	// Stop workers gracefully, they drain the messages already received
+	defer func() {
+		// the pool is no longer resized while it drains
+		stopAdapting()
+		close(messageChan)
+		pool.wait()
+		c.logger.Info("Consumer workers drained")
+	}()
+
+	c.logger.Info("Consumer started", zap.String("queue", queue), zap.Int("workers", c.workers), zap.Int("prefetch", c.prefetch),
+		zap.Bool("adaptive", c.adaptive != nil))
 
 	// Feed messages into the worker pool
 	for {
 		select {
 		case <-ctx.Done():
 			c.logger.Info("Context done, stopping consumer")
-			close(messageChan) // Stop workers gracefully
 			return
 		case msg, ok := <-consumeCh:
 			if !ok {
 				c.logger.Warn("Consumer channel closed, stopping")
-				close(messageChan) // Stop workers gracefully
 				return
 			}
 
-			messageChan <- msg
+			metrics.Messages.WithLabelValues(queue, metrics.MessageConsumed).Inc()
+			select {
+			case messageChan <- msg:
+			case <-processCtx.Done():
+				// aborted, the workers are gone
+				return
+			}
 		}
 	}
 }
 
-// worker processes messages concurrently
-func (c *ConsumerUsecase) worker(ctx context.Context, messageChan <-chan amqp091.Delivery) {
-	for msg := range messageChan {
-		rawUserData, err := c.GetRawUserDataFromMessage(ctx, msg.Body)
-		if err != nil {
-			c.logger.Error("failed to process message", zap.Error(err))
-			msg.Nack(false, true)
-			continue
-		}
+// Abort cancels the processing of the messages already received, which ConsumeUserData otherwise finishes once its
+// context is done. The writes in flight are canceled and the messages left unacknowledged, they are delivered again.
+func (c *ConsumerUsecase) Abort() {
+	c.abort()
+}
 
-		// Convert raw user data into database model
-		user, err := c.ParseRawUserData(ctx, rawUserData)
-		if err != nil {
-			c.logger.Error("failed to parse raw user data", zap.Error(err))
-			msg.Nack(false, true)
-			continue
+// worker processes messages concurrently, until the channel is closed or quit is closed to remove the worker
+func (c *ConsumerUsecase) worker(ctx context.Context, queue string, messageChan <-chan amqp091.Delivery, quit <-chan struct{}) {
+	for {
+		var msg amqp091.Delivery
+		select {
+		case <-quit:
+			return
+		case <-ctx.Done():
+			return
+		case delivery, ok := <-messageChan:
+			if !ok {
+				return
+			}
+			msg = delivery
 		}
 
-		// Encrypt data before storing it in the database
-		encryptedUserEmail, err := c.em.Encrypt(user.Email)
-		if err != nil {
-			c.logger.Error("failed to encrypt user email", zap.String("email", user.Email), zap.Error(err))
-			msg.Nack(false, true)
-			continue
-		}
+		c.processDelivery(ctx, queue, msg)
+	}
+}
 
-		// Update user email to encrypted user email
-		user.Email = encryptedUserEmail
+// processDelivery processes a single message and acknowledges it, or hands it over to the retry queues.
+func (c *ConsumerUsecase) processDelivery(ctx context.Context, queue string, msg amqp091.Delivery) {
+	metrics.WorkersBusy.Inc()
+	defer metrics.WorkersBusy.Dec()
 
-		userId, err := c.db.CreateUser(ctx, user)
-		if err != nil {
-			c.logger.Error("failed to create user in database", zap.Error(err))
-			msg.Nack(false, true)
-			continue
+	ctx, span := startConsumeSpan(ctx, queue, msg)
+
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	userId, result, err := c.ProcessMessage(ctx, msg.Body)
+	if err != nil {
+		c.logger.Error("failed to process message", zap.Error(err))
+		c.handleFailedMessage(ctx, queue, msg, err)
+		return
+	}
+
+	// Acknowledge the message after successful processing
+	err = msg.Ack(false)
+	if err != nil {
+		c.logger.Error("failed to acknowledge message", zap.Error(err))
+	} else {
+		metrics.Messages.WithLabelValues(queue, metrics.MessageAcked).Inc()
+	}
+
+	c.logger.Info("Processed and saved user data", zap.String("user_id", userId), zap.String("result", string(result)))
+}
+
+// startConsumeSpan continues the trace propagated in the headers of the message with a span for its processing.
+func startConsumeSpan(ctx context.Context, queue string, msg amqp091.Delivery) (context.Context, trace.Span) {
+	ctx = rabbitmq.ExtractTraceContext(ctx, msg.Headers)
+
+	return tracing.Start(ctx, "process "+queue, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		semconv.MessagingSystemRabbitmq,
+		semconv.MessagingDestinationName(queue),
+		semconv.MessagingMessageID(msg.MessageId),
+		attribute.Int("messaging.rabbitmq.attempts", rabbitmq.GetAttempts(msg.Headers)+1),
+	))
+}
+
+// ProcessMessage parses the message body, encrypts the user data and stores it in the database.
+// Messages are keyed by the source id of the user, so processing the same message twice is safe.
+func (c *ConsumerUsecase) ProcessMessage(ctx context.Context, messageBody []byte) (userId string, result database.UpsertResult, err error) {
+	user, err := c.prepareUser(ctx, messageBody)
+	if err != nil {
+		return "", "", err
+	}
+
+	userId, result, err = c.upsertUser(ctx, user)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to upsert user in database: %w", err)
+	}
+
+	return userId, result, nil
+}
+
+// upsertUser writes a single user, recording the latency of the write.
+func (c *ConsumerUsecase) upsertUser(ctx context.Context, user models.User) (userId string, result database.UpsertResult, err error) {
+	start := time.Now()
+	defer func() {
+		latency := time.Since(start)
+		c.dbLatency.observe(latency)
+		metrics.DBWriteDuration.WithLabelValues("upsert_user").Observe(latency.Seconds())
+	}()
+
+	return c.db.UpsertUser(ctx, user)
+}
+
+// prepareUser parses the message body into the user to store, with its encrypted fields encrypted and indexed.
+func (c *ConsumerUsecase) prepareUser(ctx context.Context, messageBody []byte) (user models.User, err error) {
+	user, err = c.parseUser(ctx, messageBody)
+	if err != nil {
+		return user, err
+	}
+
+	err = c.encryptUser(ctx, &user)
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// encryptUser sets the email blind index and digest of the user, then encrypts the fields of the encryption policy in
+// place before the user is stored.
+func (c *ConsumerUsecase) encryptUser(ctx context.Context, user *models.User) error {
+	// the blind index and digest are computed from the plaintext, the user is looked up and compared through them
+	user.EmailIndex = c.emailIndex(user.Email)
+	user.PiiDigest = c.piiDigest(*user)
+
+	// Encrypt data before storing it in the database
+	_, span := tracing.Start(ctx, "encrypt user")
+	err := encryption.EncryptFields(c.em, user)
+	tracing.End(span, err)
+	if err != nil {
+		metrics.EncryptionErrors.WithLabelValues("encrypt").Inc()
+		c.logger.Error("failed to encrypt user", logger.MaskedEmail("email", user.Email), zap.Error(err))
+		return fmt.Errorf("failed to encrypt user: %w", err)
+	}
+
+	return nil
+}
+
+// parseUser parses the message body into the user to store.
+func (c *ConsumerUsecase) parseUser(ctx context.Context, messageBody []byte) (user models.User, err error) {
+	ctx, span := tracing.Start(ctx, "parse message")
+	defer func() { tracing.End(span, err) }()
+
+	rawUserData, err := c.GetRawUserDataFromMessage(ctx, messageBody)
+	if err != nil {
+		return user, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	span.SetAttributes(attribute.Int64("user.source_id", rawUserData.Id))
+
+	// Convert raw user data into database model
+	user, err = c.ParseRawUserData(ctx, rawUserData)
+	if err != nil {
+		return user, fmt.Errorf("failed to parse raw user data: %w", err)
+	}
+
+	return user, nil
+}
+
+// DeclareRetryTopology declares the dead letter queue of the queue and the retry queues of the retry policy, the ones
+// failed messages are republished to.
+func (c *ConsumerUsecase) DeclareRetryTopology(queue string, options ...rabbitmq.QueueOption) error {
+	return rabbitmq.DeclareRetryQueues(c.rmq, queue, c.retryPolicy, options...)
+}
+
+// handleFailedMessage republishes a failed message to the retry queue of its next attempt, or to the
+// dead letter queue once the attempts are exhausted or the failure can never succeed. The original
+// delivery is acknowledged only after the copy has been published.
+func (c *ConsumerUsecase) handleFailedMessage(ctx context.Context, queue string, msg amqp091.Delivery, processErr error) {
+	// aborted, the message did not fail and is delivered again
+	if ctx.Err() != nil {
+		c.logger.Warn("processing aborted, message left unacknowledged", zap.Error(processErr))
+		return
+	}
+
+	attempts := rabbitmq.GetAttempts(msg.Headers) + 1
+
+	headers := amqp091.Table{}
+	for key, value := range msg.Headers {
+		// x-death is maintained by the broker for messages coming back from retry queues
+		if key != "x-death" {
+			headers[key] = value
 		}
+	}
+	headers[rabbitmq.HeaderAttempts] = int32(attempts)
+	headers[rabbitmq.HeaderFailureReason] = processErr.Error()
+
+	target := rabbitmq.DeadLetterQueueName(queue)
+	if IsRetryable(processErr) && attempts < c.retryPolicy.MaxAttempts {
+		target = rabbitmq.RetryQueueName(queue, attempts)
+	}
 
-		// Acknowledge the message after successful processing
-		err = msg.Ack(false)
+	err := c.rmq.PublishWithContext(ctx,
+		rabbitmq.WithRoutingKey(target),
+		rabbitmq.WithContentType(msg.ContentType),
+		rabbitmq.WithHeaders(headers),
+		rabbitmq.WithPersistent(true),
+		rabbitmq.WithBody(msg.Body),
+		rabbitmq.WithTraceContext(ctx),
+	)
+	if err != nil {
+		c.logger.Error("failed to republish message, rejecting it", zap.String("queue", target), zap.Error(err))
+
+		// rejected messages are moved to the dead letter queue by the broker
+		err = msg.Nack(false, false)
 		if err != nil {
-			c.logger.Error("failed to acknowledge message", zap.Error(err))
+			c.logger.Error("failed to reject message", zap.Error(err))
+		} else {
+			metrics.Messages.WithLabelValues(queue, metrics.MessageNacked).Inc()
 		}
+		return
+	}
+
+	err = msg.Ack(false)
+	if err != nil {
+		c.logger.Error("failed to acknowledge message", zap.Error(err))
+	}
+
+	outcome := metrics.MessageRetried
+	if target == rabbitmq.DeadLetterQueueName(queue) {
+		outcome = metrics.MessageDeadLettered
+	}
+	metrics.Messages.WithLabelValues(queue, outcome).Inc()
 
-		c.logger.Info("Processed and saved user data", zap.String("user_id", userId))
+	c.logger.Warn("message moved after failed attempt", zap.String("queue", target), zap.Int("attempts", attempts))
+}
+
+// IsRetryable reports whether processing a message may succeed on a later attempt.
+// Malformed payloads, rows without a source id and constraint violations fail the same way every time.
+func IsRetryable(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || postgres.IsConstraintViolation(err) ||
+		errors.Is(err, database.ErrMissingSourceId) {
+		return false
 	}
+
+	return true
 }
 
 // GetRawUserDataFromMessage processes the incoming message body (implement your logic here)
@@ -112,6 +338,12 @@ func (c *ConsumerUsecase) GetRawUserDataFromMessage(ctx context.Context, message
 }
 
 func (p *ConsumerUsecase) ParseRawUserData(ctx context.Context, rawUserData coreDto.RawUserData) (user models.User, err error) {
+	// users are upserted by their source id, a row without one would be inserted again on every replay
+	if rawUserData.Id <= 0 {
+		return user, database.ErrMissingSourceId
+	}
+	user.SourceId = &rawUserData.Id
+
 	user.Email = rawUserData.Email
 	user.FirstName = rawUserData.FirstName
 	user.LastName = rawUserData.LastName
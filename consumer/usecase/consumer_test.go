@@ -1,3 +1,240 @@
 package usecase_test
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/consumer/usecase"
+	"github.com/viswals/consumer/usecase/repository/database"
+	mock_usecase "github.com/viswals/consumer/usecase/repository/database/mock"
+	"github.com/viswals/core/infrastructure/postgres"
+	"github.com/viswals/core/infrastructure/rabbitmq"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+)
+
 // TODO: write test cases related to consumer service
+
+// constraintError mimics the postgres driver errors which expose the SQLSTATE code.
+type constraintError struct {
+	code string
+}
+
+func (e constraintError) Error() string    { return "pq: " + e.code }
+func (e constraintError) SQLState() string { return e.code }
+
+func TestIsRetryable(t *testing.T) {
+	var syntaxErr error = json.Unmarshal([]byte("{invalid"), &struct{}{})
+	var typeErr error = json.Unmarshal([]byte(`{"Id":"abc"}`), &struct{ Id int64 }{})
+
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "invalid json", err: fmt.Errorf("failed to unmarshal message: %w", syntaxErr), expected: false},
+		{name: "invalid field type", err: fmt.Errorf("failed to unmarshal message: %w", typeErr), expected: false},
+		{name: "unique violation", err: fmt.Errorf("failed to create user in database: %w", constraintError{code: "23505"}), expected: false},
+		{name: "missing source id", err: fmt.Errorf("failed to parse raw user data: %w", database.ErrMissingSourceId), expected: false},
+		{name: "connection failure", err: fmt.Errorf("failed to create user in database: %w", constraintError{code: "08006"}), expected: true},
+		{name: "unknown error", err: errors.New("timeout"), expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, usecase.IsRetryable(test.err))
+		})
+	}
+}
+
+func TestConsumeUserData_DrainsOnShutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mock_usecase.NewMockIConsumerRepository(ctrl)
+	mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+	mockEncryption := mock_interfaces.NewMockIEncryptionService(ctrl)
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+
+	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	mockEncryption.EXPECT().Encrypt(gomock.Any()).Return("encrypted", nil).AnyTimes()
+
+	deliveries := make(chan amqp091.Delivery)
+	mockQueue.EXPECT().Qos(gomock.Any(), 0, false).Return(nil)
+	mockQueue.EXPECT().ConsumeWithContext(gomock.Any(), "users", gomock.Any()).Return((<-chan amqp091.Delivery)(deliveries), nil)
+
+	// in-flight writes are not interrupted by the shutdown
+	mockRepo.EXPECT().UpsertUser(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, user any) (string, database.UpsertResult, error) {
+			time.Sleep(50 * time.Millisecond)
+			return "id", database.UpsertResultInserted, ctx.Err()
+		}).Times(3)
+
+	consumer := usecase.New(postgres.Postgres{}, nil, mockEncryption, usecase.WithLogger(mockLogger),
+		usecase.WithRepository(mockRepo), usecase.WithQueueService(mockQueue))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.ConsumeUserData(ctx, "users")
+	}()
+
+	acknowledger := &acknowledger{}
+	for tag := uint64(1); tag <= 3; tag++ {
+		deliveries <- amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: tag, Body: []byte(`{"Id":1,"Email":"test@example.com"}`)}
+	}
+
+	cancel()
+	<-done
+
+	assert.ElementsMatch(t, []ack{{tag: 1}, {tag: 2}, {tag: 3}}, acknowledger.acks)
+}
+
+func TestConsumeUserData_Abort(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mock_usecase.NewMockIConsumerRepository(ctrl)
+	mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+	mockEncryption := mock_interfaces.NewMockIEncryptionService(ctrl)
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+
+	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+	mockEncryption.EXPECT().Encrypt(gomock.Any()).Return("encrypted", nil).AnyTimes()
+
+	deliveries := make(chan amqp091.Delivery)
+	mockQueue.EXPECT().Qos(gomock.Any(), 0, false).Return(nil)
+	mockQueue.EXPECT().ConsumeWithContext(gomock.Any(), "users", gomock.Any()).Return((<-chan amqp091.Delivery)(deliveries), nil)
+
+	// a write which would outlive the shutdown timeout is canceled by the abort
+	writing := make(chan struct{})
+	mockRepo.EXPECT().UpsertUser(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, user any) (string, database.UpsertResult, error) {
+			close(writing)
+			<-ctx.Done()
+			return "", "", ctx.Err()
+		})
+
+	consumer := usecase.New(postgres.Postgres{}, nil, mockEncryption, usecase.WithLogger(mockLogger),
+		usecase.WithRepository(mockRepo), usecase.WithQueueService(mockQueue), usecase.WithConcurrency(1, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.ConsumeUserData(ctx, "users")
+	}()
+
+	acknowledger := &acknowledger{}
+	deliveries <- amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: 1, Body: []byte(`{"Id":1,"Email":"test@example.com"}`)}
+	<-writing
+
+	cancel()
+	consumer.Abort()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop after being aborted")
+	}
+
+	// neither acknowledged nor dead-lettered, the message is delivered again
+	assert.Empty(t, acknowledger.acks)
+}
+
+func TestDeclareRetryTopology(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []usecase.Option
+		expected []string
+	}{
+		{
+			name:     "default policy",
+			expected: []string{"users.dlq", "users.retry.1", "users.retry.2"},
+		},
+		{
+			name:     "configured policy",
+			options:  []usecase.Option{usecase.WithRetryPolicy(rabbitmq.RetryPolicy{MaxAttempts: 2, Delay: time.Second})},
+			expected: []string{"users.dlq", "users.retry.1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockRepo := mock_usecase.NewMockIConsumerRepository(ctrl)
+			mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+			mockEncryption := mock_interfaces.NewMockIEncryptionService(ctrl)
+			mockLogger := mock_interfaces.NewMockILogger(ctrl)
+
+			mockEncryption.EXPECT().Encrypt(gomock.Any()).Return("encrypted", nil).AnyTimes()
+			mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+			// every write fails with an error worth retrying
+			mockRepo.EXPECT().UpsertUsers(gomock.Any(), gomock.Any()).Return(nil, errors.New("timeout")).AnyTimes()
+			mockRepo.EXPECT().UpsertUser(gomock.Any(), gomock.Any()).Return("", database.UpsertResult(""), errors.New("timeout")).AnyTimes()
+
+			var declared, published []string
+			mockQueue.EXPECT().QueueDeclare(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(name string, options ...rabbitmq.QueueOption) (amqp091.Queue, error) {
+					declared = append(declared, name)
+					return amqp091.Queue{Name: name}, nil
+				}).AnyTimes()
+			mockQueue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, options ...rabbitmq.PublishOption) error {
+					publishOptions := &rabbitmq.PublishOptions{}
+					for _, option := range options {
+						option(publishOptions)
+					}
+					published = append(published, publishOptions.RoutingKey)
+					return nil
+				}).AnyTimes()
+
+			options := append([]usecase.Option{usecase.WithLogger(mockLogger), usecase.WithRepository(mockRepo),
+				usecase.WithQueueService(mockQueue)}, test.options...)
+			consumer := usecase.New(postgres.Postgres{}, nil, mockEncryption, options...)
+
+			require.NoError(t, consumer.DeclareRetryTopology("users"))
+			assert.Equal(t, test.expected, declared)
+
+			// a message failing on every attempt goes through the retry queues to the dead letter queue
+			for attempts := 0; attempts < len(test.expected); attempts++ {
+				delivery := amqp091.Delivery{
+					Acknowledger: &acknowledger{},
+					Headers:      amqp091.Table{rabbitmq.HeaderAttempts: int32(attempts)},
+					Body:         []byte(`{"Id":1,"Email":"test@example.com"}`),
+				}
+				consumer.ProcessBatch(context.Background(), "users", []amqp091.Delivery{delivery})
+			}
+
+			assert.ElementsMatch(t, declared, published)
+		})
+	}
+}
+
+func TestDeclareRetryTopology_InvalidPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+
+	consumer := usecase.New(postgres.Postgres{}, nil, nil, usecase.WithLogger(mockLogger), usecase.WithQueueService(mockQueue),
+		usecase.WithRetryPolicy(rabbitmq.RetryPolicy{MaxAttempts: 0}))
+
+	assert.ErrorIs(t, consumer.DeclareRetryTopology("users"), rabbitmq.ErrInvalidRetryPolicy)
+}
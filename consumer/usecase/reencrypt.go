@@ -0,0 +1,256 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/viswals/consumer/usecase/repository/database"
+	"github.com/viswals/core/infrastructure/encryption"
+	"github.com/viswals/core/infrastructure/postgres"
+	"github.com/viswals/core/infrastructure/redis"
+	"github.com/viswals/core/interfaces"
+	"github.com/viswals/core/models"
+	"go.uber.org/zap"
+)
+
+const defaultReencryptBatchSize = 500
+
+var (
+	ErrKeyRotationUnsupported = errors.New("encryption service does not support key rotation")
+	ErrCheckpointKeyMismatch  = errors.New("checkpoint was saved while re-encrypting with another key")
+)
+
+// ReencryptOptions configures ReencryptUsers.
+type ReencryptOptions struct {
+	// BatchSize is the number of users read and updated at once
+	BatchSize int
+	// CheckpointPath is where the progress is saved after every batch, a job started again with the same path
+	// resumes after the last saved batch. It is removed once every user was walked, and a checkpoint saved for another
+	// active key is refused. The progress is not saved when it is empty.
+	CheckpointPath string
+	// Progress is called after every batch
+	Progress func(ReencryptProgress)
+}
+
+// ReencryptProgress reports how far the users table has been walked, it is also the checkpoint of the job.
+type ReencryptProgress struct {
+	// KeyID is the id of the active key the users are re-encrypted with, empty when the encryption service has none
+	KeyID string `json:"key_id,omitempty"`
+	// LastId is the id of the last user walked, users are walked by id
+	LastId int64 `json:"last_id"`
+	// Scanned users, Reencrypted ones, whose encrypted fields moved to the active key or which got their blind index or
+	// digest, and the ones whose encrypted fields changed while they were re-encrypted, which already use the active key.
+	// Indexed users were given their missing blind index. Failed users could not be decrypted or share their email with
+	// another user, they are logged and left untouched.
+	Scanned     int64     `json:"scanned"`
+	Reencrypted int64     `json:"reencrypted"`
+	Indexed     int64     `json:"indexed"`
+	Conflicted  int64     `json:"conflicted"`
+	Failed      int64     `json:"failed"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ReencryptUsers walks the users table in batches and encrypts the fields of the encryption policy again with the
+// active key of the keyring, so the retired keys can be removed once it is done. Fields which still hold the plaintext
+// written before they were encrypted are encrypted. It also fills the blind index and digest of the users written
+// before they existed, when the usecase has a blind index. It is safe to run while the consumer is running and to run
+// again, users already encrypted with the active key are left untouched.
+func (c *ConsumerUsecase) ReencryptUsers(ctx context.Context, options ReencryptOptions) (progress ReencryptProgress, err error) {
+	rotator, ok := c.em.(interfaces.IKeyRotator)
+	if !ok {
+		return progress, ErrKeyRotationUnsupported
+	}
+
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultReencryptBatchSize
+	}
+
+	var keyID string
+	if keyed, ok := c.em.(interface{ KeyID() string }); ok {
+		keyID = keyed.KeyID()
+	}
+
+	if options.CheckpointPath != "" {
+		progress, err = loadReencryptProgress(options.CheckpointPath)
+		if err != nil {
+			return progress, err
+		}
+
+		// resuming the walk of a former rotation would skip the users before its last id
+		if progress.LastId > 0 && progress.KeyID != keyID {
+			return ReencryptProgress{}, fmt.Errorf("%w %q, the active key is %q, remove %s to start over",
+				ErrCheckpointKeyMismatch, progress.KeyID, keyID, options.CheckpointPath)
+		}
+
+		if progress.LastId > 0 {
+			c.logger.Info("resuming user re-encryption", zap.Int64("last_id", progress.LastId))
+		}
+	}
+
+	progress.KeyID = keyID
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		users, err := c.db.GetEncryptedUsers(ctx, progress.LastId, options.BatchSize)
+		if err != nil {
+			return progress, fmt.Errorf("failed to read users: %w", err)
+		}
+
+		if len(users) == 0 {
+			// the next rotation walks every user again
+			if options.CheckpointPath != "" {
+				err = os.Remove(options.CheckpointPath)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return progress, fmt.Errorf("failed to remove checkpoint file: %w", err)
+				}
+			}
+
+			return progress, nil
+		}
+
+		var updates []database.EncryptedUserUpdate
+		for _, user := range users {
+			update, ok, err := c.reencryptUser(rotator, user)
+			if err != nil {
+				progress.Failed++
+				c.logger.Error("failed to re-encrypt user", zap.Int64("user_id", user.Id), zap.Error(err))
+				continue
+			}
+
+			if ok {
+				updates = append(updates, update)
+			}
+		}
+
+		updated, failed, err := c.updateEncryptedUsers(ctx, updates)
+		if err != nil {
+			return progress, fmt.Errorf("failed to update users: %w", err)
+		}
+
+		// only the blind index of a user violates a constraint, failed updates never filled their index
+		progress.Indexed -= failed
+		for _, update := range updates {
+			if update.Old.EmailIndex == nil && update.New.EmailIndex != nil {
+				progress.Indexed++
+			}
+
+			// cached users hold the former ciphertext
+			c.cm.Delete(ctx, redis.GetKey("users", update.Old.Id))
+		}
+
+		progress.LastId = users[len(users)-1].Id
+		progress.Scanned += int64(len(users))
+		progress.Reencrypted += updated
+		progress.Conflicted += int64(len(updates)) - updated - failed
+		progress.Failed += failed
+		progress.UpdatedAt = time.Now()
+
+		if options.CheckpointPath != "" {
+			err = progress.save(options.CheckpointPath)
+			if err != nil {
+				return progress, err
+			}
+		}
+
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+	}
+}
+
+// reencryptUser returns the update moving the encrypted fields of the user to the active key and filling its blind
+// index and digest, ok is false when the user is up to date.
+func (c *ConsumerUsecase) reencryptUser(rotator interfaces.IKeyRotator, user models.User) (update database.EncryptedUserUpdate, ok bool, err error) {
+	update = database.EncryptedUserUpdate{Old: user, New: user}
+
+	changed, err := encryption.ReencryptFields(c.em, rotator, &update.New)
+	if err != nil {
+		return update, false, err
+	}
+
+	// the current values are written again, only the missing ones are filled
+	update.New.EmailIndex, update.New.PiiDigest = nil, nil
+	if c.bi != nil && (user.EmailIndex == nil || user.PiiDigest == nil) {
+		plainText := update.New
+		err = encryption.DecryptFields(c.em, &plainText)
+		if err != nil {
+			return update, false, err
+		}
+
+		if user.EmailIndex == nil {
+			update.New.EmailIndex = c.emailIndex(plainText.Email)
+		}
+		if user.PiiDigest == nil {
+			update.New.PiiDigest = c.piiDigest(plainText)
+		}
+	}
+
+	return update, changed || update.New.EmailIndex != nil || update.New.PiiDigest != nil, nil
+}
+
+// updateEncryptedUsers writes the updates at once. When an email is shared by several users, its blind index violates
+// the unique constraint, the updates are then written one by one so only the duplicates fail.
+func (c *ConsumerUsecase) updateEncryptedUsers(ctx context.Context, updates []database.EncryptedUserUpdate) (updated int64, failed int64, err error) {
+	updated, err = c.db.UpdateEncryptedUsers(ctx, updates)
+	if err == nil || !postgres.IsConstraintViolation(err) {
+		return updated, 0, err
+	}
+
+	updated = 0
+	for _, update := range updates {
+		n, err := c.db.UpdateEncryptedUsers(ctx, []database.EncryptedUserUpdate{update})
+		if postgres.IsConstraintViolation(err) {
+			failed++
+			c.logger.Error("user email is not unique, its blind index is not filled", zap.Int64("user_id", update.Old.Id), zap.Error(err))
+			continue
+		}
+		if err != nil {
+			return updated, failed, err
+		}
+
+		updated += n
+	}
+
+	return updated, failed, nil
+}
+
+// loadReencryptProgress reads the checkpoint of a previous job, the progress is empty when there is none.
+func loadReencryptProgress(path string) (progress ReencryptProgress, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return progress, nil
+	}
+	if err != nil {
+		return progress, err
+	}
+
+	err = json.Unmarshal(data, &progress)
+	if err != nil {
+		return progress, fmt.Errorf("failed to parse checkpoint file: %w", err)
+	}
+
+	return progress, nil
+}
+
+// save writes the progress atomically, a crash while saving leaves the previous checkpoint in place.
+func (p ReencryptProgress) save(path string) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,210 @@
+package usecase_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/consumer/usecase"
+	"github.com/viswals/consumer/usecase/repository/database"
+	mock_usecase "github.com/viswals/consumer/usecase/repository/database/mock"
+	"github.com/viswals/core/infrastructure/encryption"
+	"github.com/viswals/core/infrastructure/postgres"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+	"github.com/viswals/core/models"
+)
+
+func TestReencryptUsers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	oldKey := encryption.Key{ID: "2024", Secret: []byte("abcdefghabcdefghabcdefghabcdefgh")}
+	newKey := encryption.Key{ID: "2025", Secret: []byte("hgfedcbahgfedcbahgfedcbahgfedcba")}
+
+	before, err := encryption.NewKeyring([]encryption.Key{oldKey}, "")
+	require.NoError(t, err)
+	after, err := encryption.NewKeyring([]encryption.Key{oldKey, newKey}, "2025")
+	require.NoError(t, err)
+
+	encrypt := func(em encryption.Cipher, email, firstName, lastName string) models.User {
+		user := models.User{Email: email, FirstName: firstName, LastName: lastName}
+		require.NoError(t, encryption.EncryptFields(em, &user))
+		return user
+	}
+
+	rotated := encrypt(before, "rotated@example.com", "John", "Doe")
+	rotated.Id = 1
+	current := encrypt(after, "current@example.com", "Jane", "Doe")
+	current.Id = 2
+	// names written in clear before they were encrypted
+	plainNames := encrypt(after, "plain@example.com", "", "")
+	plainNames.Id, plainNames.FirstName, plainNames.LastName = 3, "Jim", "Beam"
+
+	mockRepo := mock_usecase.NewMockIConsumerRepository(ctrl)
+	mockCache := mock_interfaces.NewMockICacheService(ctrl)
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	gomock.InOrder(
+		mockRepo.EXPECT().GetEncryptedUsers(gomock.Any(), int64(0), 4).Return([]models.User{
+			rotated,
+			current,
+			plainNames,
+			{Id: 4, Email: "v1:2023:AAAA"}, // encrypted with a key no longer in the keyring
+		}, nil),
+		mockRepo.EXPECT().UpdateEncryptedUsers(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, updates []database.EncryptedUserUpdate) (int64, error) {
+				require.Len(t, updates, 2)
+				assert.Equal(t, rotated, updates[0].Old)
+				assert.Equal(t, plainNames, updates[1].Old)
+
+				for i, expected := range []models.User{
+					{Id: 1, Email: "rotated@example.com", FirstName: "John", LastName: "Doe"},
+					{Id: 3, Email: "plain@example.com", FirstName: "Jim", LastName: "Beam"},
+				} {
+					for _, value := range encryption.FieldValues(updates[i].New) {
+						assert.True(t, strings.HasPrefix(value, "v1:2025:"), value)
+					}
+
+					user := updates[i].New
+					assert.NoError(t, encryption.DecryptFields(after, &user))
+					assert.Equal(t, expected, user)
+				}
+
+				return 2, nil
+			}),
+		mockRepo.EXPECT().GetEncryptedUsers(gomock.Any(), int64(4), 4).Return(nil, nil),
+	)
+	mockCache.EXPECT().Delete(gomock.Any(), "users:1").Return(nil)
+	mockCache.EXPECT().Delete(gomock.Any(), "users:3").Return(nil)
+
+	uc := usecase.New(postgres.Postgres{}, nil, after, usecase.WithLogger(mockLogger), usecase.WithCacheManager(mockCache),
+		usecase.WithRepository(mockRepo))
+
+	checkpoint := filepath.Join(t.TempDir(), "reencrypt.checkpoint.json")
+	var batches int
+	progress, err := uc.ReencryptUsers(context.Background(), usecase.ReencryptOptions{
+		BatchSize:      4,
+		CheckpointPath: checkpoint,
+		Progress:       func(usecase.ReencryptProgress) { batches++ },
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, batches)
+	assert.Equal(t, int64(4), progress.LastId)
+	assert.Equal(t, int64(4), progress.Scanned)
+	assert.Equal(t, int64(2), progress.Reencrypted)
+	assert.Equal(t, int64(1), progress.Failed)
+
+	// the next rotation walks every user again
+	assert.NoFileExists(t, checkpoint)
+
+	// a job interrupted after its first batch resumes after the checkpoint
+	interrupted := progress
+	interrupted.LastId = 2
+	data, err := json.Marshal(interrupted)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(checkpoint, data, 0644))
+
+	mockRepo.EXPECT().GetEncryptedUsers(gomock.Any(), int64(2), 4).Return(nil, nil)
+
+	resumed, err := uc.ReencryptUsers(context.Background(), usecase.ReencryptOptions{BatchSize: 4, CheckpointPath: checkpoint})
+	require.NoError(t, err)
+	assert.Equal(t, progress.Scanned, resumed.Scanned)
+	assert.Equal(t, progress.Reencrypted, resumed.Reencrypted)
+
+	// the checkpoint of a rotation to another key is not resumed
+	interrupted.KeyID = "2024"
+	data, err = json.Marshal(interrupted)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(checkpoint, data, 0644))
+
+	_, err = uc.ReencryptUsers(context.Background(), usecase.ReencryptOptions{BatchSize: 4, CheckpointPath: checkpoint})
+	assert.ErrorIs(t, err, usecase.ErrCheckpointKeyMismatch)
+}
+
+func TestReencryptUsers_Unsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	uc := usecase.New(postgres.Postgres{}, nil, mock_interfaces.NewMockIEncryptionService(ctrl),
+		usecase.WithLogger(mock_interfaces.NewMockILogger(ctrl)), usecase.WithRepository(mock_usecase.NewMockIConsumerRepository(ctrl)))
+
+	_, err := uc.ReencryptUsers(context.Background(), usecase.ReencryptOptions{})
+	assert.ErrorIs(t, err, usecase.ErrKeyRotationUnsupported)
+}
+
+// uniqueViolation is the error of the driver for a unique constraint violation.
+type uniqueViolation struct{}
+
+func (uniqueViolation) Error() string    { return "duplicate key value violates unique constraint" }
+func (uniqueViolation) SQLState() string { return "23505" }
+
+func TestReencryptUsers_BlindIndex(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	em, err := encryption.NewKeyring([]encryption.Key{{ID: "2025", Secret: []byte("hgfedcbahgfedcbahgfedcbahgfedcba")}}, "")
+	require.NoError(t, err)
+	bi, err := encryption.NewBlindIndex([]byte("blind-index-key-blind-index-key!"))
+	require.NoError(t, err)
+
+	encrypt := func(id int64, email string) models.User {
+		user := models.User{Id: id, Email: email, FirstName: "John", LastName: "Doe"}
+		require.NoError(t, encryption.EncryptFields(em, &user))
+		return user
+	}
+
+	index := bi.Index("john@example.com")
+	digest := "digest"
+
+	john := encrypt(1, "john@example.com")
+	duplicate := encrypt(2, "John@example.com")
+	indexed := encrypt(3, "john@example.com")
+	indexed.EmailIndex, indexed.PiiDigest = &index, &digest
+
+	mockRepo := mock_usecase.NewMockIConsumerRepository(ctrl)
+	mockCache := mock_interfaces.NewMockICacheService(ctrl)
+	mockCache.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	gomock.InOrder(
+		mockRepo.EXPECT().GetEncryptedUsers(gomock.Any(), int64(0), 10).Return([]models.User{john, duplicate, indexed}, nil),
+		// both users get the same index, the batch fails and the users are updated one by one
+		mockRepo.EXPECT().UpdateEncryptedUsers(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, updates []database.EncryptedUserUpdate) (int64, error) {
+				require.Len(t, updates, 2)
+				for i, old := range []models.User{john, duplicate} {
+					// the ciphertexts are up to date, only the index and digest are filled
+					assert.Equal(t, encryption.FieldValues(old), encryption.FieldValues(updates[i].New))
+					assert.Equal(t, &index, updates[i].New.EmailIndex)
+					assert.NotNil(t, updates[i].New.PiiDigest)
+				}
+
+				return 0, uniqueViolation{}
+			}),
+		mockRepo.EXPECT().UpdateEncryptedUsers(gomock.Any(), gomock.Len(1)).Return(int64(1), nil),
+		mockRepo.EXPECT().UpdateEncryptedUsers(gomock.Any(), gomock.Len(1)).Return(int64(0), uniqueViolation{}),
+		mockRepo.EXPECT().GetEncryptedUsers(gomock.Any(), int64(3), 10).Return(nil, nil),
+	)
+
+	uc := usecase.New(postgres.Postgres{}, nil, em, usecase.WithLogger(mockLogger), usecase.WithCacheManager(mockCache),
+		usecase.WithRepository(mockRepo), usecase.WithBlindIndex(bi))
+
+	progress, err := uc.ReencryptUsers(context.Background(), usecase.ReencryptOptions{BatchSize: 10})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(3), progress.Scanned)
+	assert.Equal(t, int64(1), progress.Reencrypted)
+	assert.Equal(t, int64(1), progress.Indexed)
+	assert.Equal(t, int64(1), progress.Failed)
+	assert.Equal(t, int64(0), progress.Conflicted)
+}
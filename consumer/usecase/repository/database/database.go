@@ -1,9 +1,45 @@
 package database
 
 import (
+	"context"
+	"errors"
+
 	"github.com/viswals/core/interfaces"
+	"github.com/viswals/core/models"
+	"github.com/viswals/core/pkg/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maximum rows of a single insert statement, postgres accepts at most 65535 parameters
+const maxUpsertRows = 1000
+
+var (
+	ErrMissingSourceId = errors.New("user has no source id")
+)
+
+// UpsertResult tells what happened to a user row written by UpsertUser.
+type UpsertResult string
+
+const (
+	UpsertResultInserted UpsertResult = "inserted"
+	UpsertResultUpdated  UpsertResult = "updated"
+	UpsertResultSkipped  UpsertResult = "skipped"
 )
 
+// UpsertedUser is the outcome of a user written by UpsertUsers.
+type UpsertedUser struct {
+	Id     string
+	Result UpsertResult
+}
+
+// EncryptedUserUpdate replaces the encrypted fields of a user, provided they are still the ones of Old.
+type EncryptedUserUpdate struct {
+	Old models.User
+	// New holds the encrypted fields to write, its EmailIndex and PiiDigest are set when not nil
+	New models.User
+}
+
 type ConsumerDB struct {
 	DB     interfaces.ISqlDatabase
 	logger interfaces.ILogger
@@ -15,3 +51,12 @@ func New(db interfaces.ISqlDatabase, logger interfaces.ILogger) *ConsumerDB {
 		logger: logger,
 	}
 }
+
+// startSpan starts the span of a query on the users table, named after the repository method.
+func startSpan(ctx context.Context, method string, operation string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "ConsumerDB."+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperationName(operation),
+		semconv.DBCollectionName("users"),
+	))
+}
@@ -9,6 +9,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	database "github.com/viswals/consumer/usecase/repository/database"
 	models "github.com/viswals/core/models"
 	utils "github.com/viswals/core/pkg/utils"
 )
@@ -37,10 +38,10 @@ func (m *MockIConsumerRepository) EXPECT() *MockIConsumerRepositoryMockRecorder
 }
 
 // CreateUser mocks base method.
-func (m *MockIConsumerRepository) CreateUser(ctx context.Context, user models.User) (string, error) {
+func (m *MockIConsumerRepository) CreateUser(ctx context.Context, user models.User) (models.User, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "CreateUser", ctx, user)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(models.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -51,6 +52,20 @@ func (mr *MockIConsumerRepositoryMockRecorder) CreateUser(ctx, user interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockIConsumerRepository)(nil).CreateUser), ctx, user)
 }
 
+// DeleteUser mocks base method.
+func (m *MockIConsumerRepository) DeleteUser(ctx context.Context, id int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUser", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUser indicates an expected call of DeleteUser.
+func (mr *MockIConsumerRepositoryMockRecorder) DeleteUser(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockIConsumerRepository)(nil).DeleteUser), ctx, id)
+}
+
 // GetAllUsers mocks base method.
 func (m *MockIConsumerRepository) GetAllUsers(ctx context.Context, pagination utils.PaginationParams, filters []utils.Filter) ([]models.User, int, error) {
 	m.ctrl.T.Helper()
@@ -67,19 +82,34 @@ func (mr *MockIConsumerRepositoryMockRecorder) GetAllUsers(ctx, pagination, filt
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllUsers", reflect.TypeOf((*MockIConsumerRepository)(nil).GetAllUsers), ctx, pagination, filters)
 }
 
-// GetUserByEmail mocks base method.
-func (m *MockIConsumerRepository) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+// GetEncryptedUsers mocks base method.
+func (m *MockIConsumerRepository) GetEncryptedUsers(ctx context.Context, afterId int64, limit int) ([]models.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUserByEmail", ctx, email)
+	ret := m.ctrl.Call(m, "GetEncryptedUsers", ctx, afterId, limit)
+	ret0, _ := ret[0].([]models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEncryptedUsers indicates an expected call of GetEncryptedUsers.
+func (mr *MockIConsumerRepositoryMockRecorder) GetEncryptedUsers(ctx, afterId, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEncryptedUsers", reflect.TypeOf((*MockIConsumerRepository)(nil).GetEncryptedUsers), ctx, afterId, limit)
+}
+
+// GetUserByEmailIndex mocks base method.
+func (m *MockIConsumerRepository) GetUserByEmailIndex(ctx context.Context, emailIndex string) (models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByEmailIndex", ctx, emailIndex)
 	ret0, _ := ret[0].(models.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetUserByEmail indicates an expected call of GetUserByEmail.
-func (mr *MockIConsumerRepositoryMockRecorder) GetUserByEmail(ctx, email interface{}) *gomock.Call {
+// GetUserByEmailIndex indicates an expected call of GetUserByEmailIndex.
+func (mr *MockIConsumerRepositoryMockRecorder) GetUserByEmailIndex(ctx, emailIndex interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByEmail", reflect.TypeOf((*MockIConsumerRepository)(nil).GetUserByEmail), ctx, email)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByEmailIndex", reflect.TypeOf((*MockIConsumerRepository)(nil).GetUserByEmailIndex), ctx, emailIndex)
 }
 
 // GetUserById mocks base method.
@@ -96,3 +126,77 @@ func (mr *MockIConsumerRepositoryMockRecorder) GetUserById(ctx, id interface{})
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserById", reflect.TypeOf((*MockIConsumerRepository)(nil).GetUserById), ctx, id)
 }
+
+// RestoreUser mocks base method.
+func (m *MockIConsumerRepository) RestoreUser(ctx context.Context, id int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreUser", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreUser indicates an expected call of RestoreUser.
+func (mr *MockIConsumerRepositoryMockRecorder) RestoreUser(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreUser", reflect.TypeOf((*MockIConsumerRepository)(nil).RestoreUser), ctx, id)
+}
+
+// UpdateEncryptedUsers mocks base method.
+func (m *MockIConsumerRepository) UpdateEncryptedUsers(ctx context.Context, updates []database.EncryptedUserUpdate) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateEncryptedUsers", ctx, updates)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateEncryptedUsers indicates an expected call of UpdateEncryptedUsers.
+func (mr *MockIConsumerRepositoryMockRecorder) UpdateEncryptedUsers(ctx, updates interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateEncryptedUsers", reflect.TypeOf((*MockIConsumerRepository)(nil).UpdateEncryptedUsers), ctx, updates)
+}
+
+// UpdateUser mocks base method.
+func (m *MockIConsumerRepository) UpdateUser(ctx context.Context, user models.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUser", ctx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateUser indicates an expected call of UpdateUser.
+func (mr *MockIConsumerRepositoryMockRecorder) UpdateUser(ctx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockIConsumerRepository)(nil).UpdateUser), ctx, user)
+}
+
+// UpsertUser mocks base method.
+func (m *MockIConsumerRepository) UpsertUser(ctx context.Context, user models.User) (string, database.UpsertResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertUser", ctx, user)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(database.UpsertResult)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// UpsertUser indicates an expected call of UpsertUser.
+func (mr *MockIConsumerRepositoryMockRecorder) UpsertUser(ctx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertUser", reflect.TypeOf((*MockIConsumerRepository)(nil).UpsertUser), ctx, user)
+}
+
+// UpsertUsers mocks base method.
+func (m *MockIConsumerRepository) UpsertUsers(ctx context.Context, users []models.User) ([]database.UpsertedUser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertUsers", ctx, users)
+	ret0, _ := ret[0].([]database.UpsertedUser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpsertUsers indicates an expected call of UpsertUsers.
+func (mr *MockIConsumerRepositoryMockRecorder) UpsertUsers(ctx, users interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertUsers", reflect.TypeOf((*MockIConsumerRepository)(nil).UpsertUsers), ctx, users)
+}
@@ -2,30 +2,239 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
+	"github.com/viswals/core/infrastructure/encryption"
+	"github.com/viswals/core/infrastructure/postgres"
 	"github.com/viswals/core/models"
+	"github.com/viswals/core/pkg/tracing"
 	"github.com/viswals/core/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
-func (a *ConsumerDB) CreateUser(ctx context.Context, user models.User) (id string, err error) {
+// upsertUserConflict updates the existing user with the same source id, unless nothing changed. The encrypted fields
+// are compared through their digest, a user written without one keeps the email index it has and is always updated.
+// A deleted user stays deleted, with its first deletion time, so replaying the source never restores a user deleted
+// through the API.
+const upsertUserConflict = `ON CONFLICT (source_id) DO UPDATE SET
+			email = EXCLUDED.email,
+			email_index = COALESCE(EXCLUDED.email_index, users.email_index),
+			firstname = EXCLUDED.firstname,
+			lastname = EXCLUDED.lastname,
+			pii_digest = EXCLUDED.pii_digest,
+			parent_user_id = EXCLUDED.parent_user_id,
+			created_at = EXCLUDED.created_at,
+			deleted_at = COALESCE(users.deleted_at, EXCLUDED.deleted_at),
+			merged_at = EXCLUDED.merged_at,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE (users.parent_user_id, users.created_at, users.deleted_at, users.merged_at)
+			IS DISTINCT FROM (EXCLUDED.parent_user_id, EXCLUDED.created_at, COALESCE(users.deleted_at, EXCLUDED.deleted_at), EXCLUDED.merged_at)
+			OR EXCLUDED.pii_digest IS NULL
+			OR users.pii_digest IS DISTINCT FROM EXCLUDED.pii_digest`
 
-	query := `INSERT INTO users (email, firstname, lastname, parent_user_id, created_at, deleted_at, merged_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
-	err = a.DB.QueryRowContext(ctx, query, user.Email, user.FirstName, user.LastName, user.ParentUserId, user.CreatedAt, user.DeletedAt, user.MergedAt).Scan(&id)
+// UpsertUser inserts the user or updates the existing user with the same source id, which is required.
+// Reprocessing an unchanged user leaves the row untouched and reports it as skipped.
+// NOTE: the encrypted fields use a random nonce, they are only compared when the user has a digest of them.
+func (a *ConsumerDB) UpsertUser(ctx context.Context, user models.User) (id string, result UpsertResult, err error) {
+	ctx, span := startSpan(ctx, "UpsertUser", "INSERT")
+	defer func() { tracing.End(span, err) }()
+
+	if user.SourceId == nil {
+		return "", "", ErrMissingSourceId
+	}
+
+	query := `INSERT INTO users (source_id, email, email_index, firstname, lastname, pii_digest, parent_user_id, created_at, deleted_at, merged_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ` + upsertUserConflict + `
+		RETURNING id, (xmax = 0) AS inserted`
+
+	var inserted bool
+	err = a.DB.QueryRowContext(ctx, query, user.SourceId, user.Email, user.EmailIndex, user.FirstName, user.LastName, user.PiiDigest, user.ParentUserId, user.CreatedAt, user.DeletedAt, user.MergedAt).Scan(&id, &inserted)
+	if errors.Is(err, sql.ErrNoRows) {
+		// conflicting row is identical, nothing has been written
+		err = a.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE source_id = $1", user.SourceId).Scan(&id)
+		if err != nil {
+			return "", "", err
+		}
+
+		return id, UpsertResultSkipped, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if inserted {
+		return id, UpsertResultInserted, nil
+	}
+
+	return id, UpsertResultUpdated, nil
+}
+
+// UpsertUsers upserts a batch of users like UpsertUser, with multi-row inserts inside a single transaction,
+// so either the whole batch is written or none of it. Every user must have a source id.
+// Results are returned in the order of the users, when a source id appears more than once the last user wins
+// and the others are reported as skipped.
+func (a *ConsumerDB) UpsertUsers(ctx context.Context, users []models.User) (results []UpsertedUser, err error) {
+	ctx, span := startSpan(ctx, "UpsertUsers", "INSERT")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(attribute.Int("db.rows", len(users)))
+
+	// a statement can not update the same row twice, keep the last user of every source id
+	last := make(map[int64]int, len(users))
+	for i, user := range users {
+		if user.SourceId == nil {
+			return nil, ErrMissingSourceId
+		}
+
+		last[*user.SourceId] = i
+	}
+
+	unique := make([]models.User, 0, len(last))
+	for i, user := range users {
+		if last[*user.SourceId] == i {
+			unique = append(unique, user)
+		}
+	}
+
+	tx, err := a.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	written := make(map[int64]UpsertedUser, len(unique))
+	for start := 0; start < len(unique); start += maxUpsertRows {
+		end := min(start+maxUpsertRows, len(unique))
+
+		err = upsertUsersChunk(ctx, tx, unique[start:end], written)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// identical rows are not returned by the insert, look up their id
+	var skipped []int64
+	for sourceId := range last {
+		if _, ok := written[sourceId]; !ok {
+			skipped = append(skipped, sourceId)
+		}
+	}
+
+	if len(skipped) > 0 {
+		err = selectSkippedUsers(ctx, tx, skipped, written)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return id, nil
+	results = make([]UpsertedUser, len(users))
+	for i, user := range users {
+		results[i] = written[*user.SourceId]
+		if last[*user.SourceId] != i {
+			results[i].Result = UpsertResultSkipped
+		}
+	}
+
+	return results, nil
 }
 
-func (g *ConsumerDB) GetUserByEmail(ctx context.Context, email string) (user models.User, err error) {
+func upsertUsersChunk(ctx context.Context, tx *sqlx.Tx, users []models.User, written map[int64]UpsertedUser) error {
+	insert := sq.Insert("users").
+		Columns("source_id", "email", "email_index", "firstname", "lastname", "pii_digest", "parent_user_id", "created_at", "deleted_at", "merged_at").
+		PlaceholderFormat(sq.Dollar)
+
+	for _, user := range users {
+		insert = insert.Values(user.SourceId, user.Email, user.EmailIndex, user.FirstName, user.LastName, user.PiiDigest, user.ParentUserId, user.CreatedAt, user.DeletedAt, user.MergedAt)
+	}
+
+	query, args, err := insert.Suffix(upsertUserConflict + " RETURNING source_id, id, (xmax = 0) AS inserted").ToSql()
+	if err != nil {
+		return err
+	}
 
-	query := "SELECT id, email, firstname, lastname, parent_user_id FROM users WHERE email = $1"
-	row := g.DB.QueryRowContext(ctx, query, email)
-	err = row.Scan(&user.Id, &user.Email, &user.FirstName, &user.LastName, &user.ParentUserId)
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sourceId int64
+		var user UpsertedUser
+		var inserted bool
+
+		err = rows.Scan(&sourceId, &user.Id, &inserted)
+		if err != nil {
+			return err
+		}
+
+		user.Result = UpsertResultUpdated
+		if inserted {
+			user.Result = UpsertResultInserted
+		}
+
+		written[sourceId] = user
+	}
+
+	return rows.Err()
+}
+
+func selectSkippedUsers(ctx context.Context, tx *sqlx.Tx, sourceIds []int64, written map[int64]UpsertedUser) error {
+	query, args, err := sq.Select("source_id", "id").From("users").
+		Where(sq.Eq{"source_id": sourceIds}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sourceId int64
+		user := UpsertedUser{Result: UpsertResultSkipped}
+
+		err = rows.Scan(&sourceId, &user.Id)
+		if err != nil {
+			return err
+		}
+
+		written[sourceId] = user
+	}
+
+	return rows.Err()
+}
+
+// GetUserByEmailIndex returns the user with the blind index of an email, the email itself is never stored in clear.
+// Only users which are not deleted are looked up, the email of a deleted user may have been taken by another one.
+func (g *ConsumerDB) GetUserByEmailIndex(ctx context.Context, emailIndex string) (user models.User, err error) {
+	ctx, span := startSpan(ctx, "GetUserByEmailIndex", "SELECT")
+	defer func() { tracing.End(span, err) }()
+
+	query := "SELECT id, source_id, email, firstname, lastname, parent_user_id, created_at, deleted_at, merged_at FROM users WHERE email_index = $1 AND deleted_at IS NULL"
+	row := g.DB.QueryRowContext(ctx, query, emailIndex)
+	err = row.Scan(&user.Id, &user.SourceId, &user.Email, &user.FirstName, &user.LastName, &user.ParentUserId, &user.CreatedAt, &user.DeletedAt, &user.MergedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, models.ErrUserNotFound
+	}
 	if err != nil {
 		return user, err
 	}
@@ -34,10 +243,36 @@ func (g *ConsumerDB) GetUserByEmail(ctx context.Context, email string) (user mod
 }
 
 func (g *ConsumerDB) GetUserById(ctx context.Context, id int64) (user models.User, err error) {
+	ctx, span := startSpan(ctx, "GetUserById", "SELECT")
+	defer func() { tracing.End(span, err) }()
 
-	query := "SELECT id, email, firstname, lastname, parent_user_id, created_at, deleted_at, merged_at FROM users WHERE id = $1"
+	query := "SELECT id, source_id, email, firstname, lastname, parent_user_id, created_at, deleted_at, merged_at FROM users WHERE id = $1"
 	row := g.DB.QueryRowContext(ctx, query, id)
-	err = row.Scan(&user.Id, &user.Email, &user.FirstName, &user.LastName, &user.ParentUserId, &user.CreatedAt, &user.DeletedAt, &user.MergedAt)
+	err = row.Scan(&user.Id, &user.SourceId, &user.Email, &user.FirstName, &user.LastName, &user.ParentUserId, &user.CreatedAt, &user.DeletedAt, &user.MergedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, models.ErrUserNotFound
+	}
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// CreateUser inserts a user which does not come from the source, it has no source id. It returns the user with its id
+// and creation time, or models.ErrUserExists when another user has the same email blind index.
+func (a *ConsumerDB) CreateUser(ctx context.Context, user models.User) (created models.User, err error) {
+	ctx, span := startSpan(ctx, "CreateUser", "INSERT")
+	defer func() { tracing.End(span, err) }()
+
+	query := `INSERT INTO users (email, email_index, firstname, lastname, pii_digest, parent_user_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	err = a.DB.QueryRowContext(ctx, query, user.Email, user.EmailIndex, user.FirstName, user.LastName, user.PiiDigest, user.ParentUserId).Scan(&user.Id, &user.CreatedAt)
+	if postgres.IsUniqueViolation(err) {
+		return user, models.ErrUserExists
+	}
 	if err != nil {
 		return user, err
 	}
@@ -45,14 +280,121 @@ func (g *ConsumerDB) GetUserById(ctx context.Context, id int64) (user models.Use
 	return user, nil
 }
 
-func (g *ConsumerDB) GetAllUsers(ctx context.Context, pagination utils.PaginationParams, filters []utils.Filter) ([]models.User, int, error) {
-	var users []models.User
+// UpdateUser replaces the email, names and parent of the user, along with its email blind index and digest. It returns
+// models.ErrUserNotFound when there is no such user, models.ErrUserDeleted when it is deleted and models.ErrUserExists
+// when another user has the same email blind index.
+func (a *ConsumerDB) UpdateUser(ctx context.Context, user models.User) (err error) {
+	ctx, span := startSpan(ctx, "UpdateUser", "UPDATE")
+	defer func() { tracing.End(span, err) }()
+
+	query := `UPDATE users SET
+			email = $2,
+			email_index = $3,
+			firstname = $4,
+			lastname = $5,
+			pii_digest = $6,
+			parent_user_id = $7,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL`
+
+	result, err := a.DB.ExecContext(ctx, query, user.Id, user.Email, user.EmailIndex, user.FirstName, user.LastName, user.PiiDigest, user.ParentUserId)
+	if postgres.IsUniqueViolation(err) {
+		return models.ErrUserExists
+	}
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return a.notUpdated(ctx, user.Id, models.ErrUserDeleted)
+	}
+
+	return nil
+}
+
+// DeleteUser soft deletes the user by setting its deletion time, its email is then free for another user as only the
+// blind indexes of the users which are not deleted are unique. Upserting the user again keeps it deleted. It returns models.ErrUserNotFound when there is no
+// such user and models.ErrUserDeleted when it is already deleted.
+func (a *ConsumerDB) DeleteUser(ctx context.Context, id int64) (err error) {
+	ctx, span := startSpan(ctx, "DeleteUser", "UPDATE")
+	defer func() { tracing.End(span, err) }()
+
+	query := "UPDATE users SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL"
+	result, err := a.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return a.notUpdated(ctx, id, models.ErrUserDeleted)
+	}
+
+	return nil
+}
+
+// RestoreUser clears the deletion time of a soft deleted user. It returns models.ErrUserNotFound when there is no such
+// user, models.ErrUserNotDeleted when it is not deleted and models.ErrUserExists when its email was taken by another
+// user since it was deleted.
+func (a *ConsumerDB) RestoreUser(ctx context.Context, id int64) (err error) {
+	ctx, span := startSpan(ctx, "RestoreUser", "UPDATE")
+	defer func() { tracing.End(span, err) }()
+
+	query := "UPDATE users SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NOT NULL"
+	result, err := a.DB.ExecContext(ctx, query, id)
+	if postgres.IsUniqueViolation(err) {
+		return models.ErrUserExists
+	}
+	if err != nil {
+		return err
+	}
+
+	restored, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if restored == 0 {
+		return a.notUpdated(ctx, id, models.ErrUserNotDeleted)
+	}
+
+	return nil
+}
+
+// notUpdated tells why an update of the user matched no row, either it does not exist or it is not in the state the
+// update expects, in which case stateErr is returned.
+func (a *ConsumerDB) notUpdated(ctx context.Context, id int64, stateErr error) error {
+	var exists bool
+	err := a.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrUserNotFound
+	}
+
+	return stateErr
+}
+
+func (g *ConsumerDB) GetAllUsers(ctx context.Context, pagination utils.PaginationParams, filters []utils.Filter) (users []models.User, totalUsers int, err error) {
+	ctx, span := startSpan(ctx, "GetAllUsers", "SELECT")
+	defer func() { tracing.End(span, err) }()
 
 	// build sql query
-	baseQuery := sq.Select("id, email, firstname, lastname, parent_user_id, created_at, deleted_at, merged_at").
+	baseQuery := sq.Select("id, source_id, email, firstname, lastname, parent_user_id, created_at, deleted_at, merged_at").
 		From("users")
 
-	queryWithFilters := utils.ApplyFilters(baseQuery, filters, false)                                            // apply filters
+	queryWithFilters := utils.ApplyFilters(baseQuery, filters, false)                                     // apply filters
 	queryWithFilters = queryWithFilters.Limit(uint64(pagination.Limit)).Offset(uint64(pagination.Offset)) // add pagination
 
 	// generate sql and arguments
@@ -72,7 +414,6 @@ func (g *ConsumerDB) GetAllUsers(ctx context.Context, pagination utils.Paginatio
 	}
 
 	// get total users
-	var totalUsers int
 	countQuery := sq.Select("COUNT(*)").From("users")
 	countQueryWithFilters := utils.ApplyFilters(countQuery, filters, true)
 	countSQL, countArgs, err := countQueryWithFilters.ToSql()
@@ -92,3 +433,97 @@ func (g *ConsumerDB) GetAllUsers(ctx context.Context, pagination utils.Paginatio
 
 	return users, totalUsers, nil
 }
+
+// GetEncryptedUsers returns the id, the encrypted fields, the email blind index and the digest of at most limit users
+// with an id greater than afterId, by id.
+func (g *ConsumerDB) GetEncryptedUsers(ctx context.Context, afterId int64, limit int) (users []models.User, err error) {
+	ctx, span := startSpan(ctx, "GetEncryptedUsers", "SELECT")
+	defer func() { tracing.End(span, err) }()
+
+	query, args, err := sq.Select("id", "email_index", "pii_digest").Columns(encryptedColumns()...).
+		From("users").
+		Where(sq.Gt{"id": afterId}).
+		OrderBy("id").
+		Limit(uint64(limit)).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.DB.SelectContext(ctx, &users, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// UpdateEncryptedUsers replaces the encrypted fields of the users in a single statement, and sets their blind index
+// and digest when the update has them. A user whose encrypted fields changed since they were read is left untouched,
+// so a concurrent write is never overwritten. It returns the updated rows.
+func (g *ConsumerDB) UpdateEncryptedUsers(ctx context.Context, updates []EncryptedUserUpdate) (updated int64, err error) {
+	ctx, span := startSpan(ctx, "UpdateEncryptedUsers", "UPDATE")
+	defer func() { tracing.End(span, err) }()
+	span.SetAttributes(attribute.Int("db.rows", len(updates)))
+
+	if len(updates) == 0 {
+		return 0, nil
+	}
+
+	columns := encryptedColumns()
+	valueColumns := []string{"id"}
+	set := make([]string, 0, len(columns)+2)
+	where := []string{"users.id = v.id"}
+	for _, column := range columns {
+		valueColumns = append(valueColumns, "old_"+column, "new_"+column)
+		set = append(set, fmt.Sprintf("%s = v.new_%s", column, column))
+		where = append(where, fmt.Sprintf("users.%s = v.old_%s", column, column))
+	}
+	valueColumns = append(valueColumns, "email_index", "pii_digest")
+	set = append(set, "email_index = COALESCE(v.email_index, users.email_index)", "pii_digest = COALESCE(v.pii_digest, users.pii_digest)")
+
+	values := make([]string, len(updates))
+	args := make([]any, 0, len(valueColumns)*len(updates))
+	for i, update := range updates {
+		placeholders := make([]string, len(valueColumns))
+		for j := range placeholders {
+			cast := "text"
+			if j == 0 {
+				cast = "bigint"
+			}
+			placeholders[j] = fmt.Sprintf("$%d::%s", len(args)+j+1, cast)
+		}
+		values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+
+		args = append(args, update.Old.Id)
+		oldValues, newValues := encryption.FieldValues(update.Old), encryption.FieldValues(update.New)
+		for j := range columns {
+			args = append(args, oldValues[j], newValues[j])
+		}
+		args = append(args, update.New.EmailIndex, update.New.PiiDigest)
+	}
+
+	query := `UPDATE users SET ` + strings.Join(set, ", ") + `
+		FROM (VALUES ` + strings.Join(values, ", ") + `) AS v(` + strings.Join(valueColumns, ", ") + `)
+		WHERE ` + strings.Join(where, " AND ")
+
+	result, err := g.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// encryptedColumns returns the columns of the fields of the user encrypted at rest.
+func encryptedColumns() []string {
+	fields := encryption.Fields(models.User{})
+
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = field.Column
+	}
+
+	return columns
+}
@@ -0,0 +1,197 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/consumer/usecase/repository/database"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+	"github.com/viswals/core/models"
+)
+
+func newConsumerDB(t *testing.T) (*database.ConsumerDB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	mockLogger := mock_interfaces.NewMockILogger(gomock.NewController(t))
+	mockLogger.EXPECT().Debug(gomock.Any(), gomock.Any()).AnyTimes()
+
+	return database.New(sqlx.NewDb(db, "postgres"), mockLogger), mock
+}
+
+func TestUpsertUser(t *testing.T) {
+	sourceId := int64(7)
+	user := models.User{SourceId: &sourceId, Email: "v2:key:Y2lwaGVy", FirstName: "v2:key:Zmlyc3Q=", LastName: "v2:key:bGFzdA=="}
+
+	// the row of the same source id is only updated when something changed
+	// and a deleted user stays deleted
+	upsert := regexp.QuoteMeta(`ON CONFLICT (source_id) DO UPDATE SET`) + `(?s:.*)` +
+		regexp.QuoteMeta(`deleted_at = COALESCE(users.deleted_at, EXCLUDED.deleted_at)`) + `(?s:.*)` +
+		regexp.QuoteMeta(`OR users.pii_digest IS DISTINCT FROM EXCLUDED.pii_digest`) + `(?s:.*)` +
+		regexp.QuoteMeta(`RETURNING id, (xmax = 0) AS inserted`)
+
+	tests := []struct {
+		name       string
+		user       models.User
+		mockFunc   func(mock sqlmock.Sqlmock)
+		wantID     string
+		wantResult database.UpsertResult
+		wantErr    error
+	}{
+		{
+			name: "inserted",
+			user: user,
+			mockFunc: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(upsert).
+					WithArgs(&sourceId, user.Email, nil, user.FirstName, user.LastName, nil, nil, nil, nil, nil).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "inserted"}).AddRow("1", true))
+			},
+			wantID:     "1",
+			wantResult: database.UpsertResultInserted,
+		},
+		{
+			name: "updated",
+			user: user,
+			mockFunc: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(upsert).WillReturnRows(sqlmock.NewRows([]string{"id", "inserted"}).AddRow("1", false))
+			},
+			wantID:     "1",
+			wantResult: database.UpsertResultUpdated,
+		},
+		{
+			name: "unchanged",
+			user: user,
+			mockFunc: func(mock sqlmock.Sqlmock) {
+				// the conflict clause filters the identical row out of the returned ones
+				mock.ExpectQuery(upsert).WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE source_id = $1")).
+					WithArgs(&sourceId).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+			},
+			wantID:     "1",
+			wantResult: database.UpsertResultSkipped,
+		},
+		{
+			name:     "missing source id",
+			user:     models.User{Email: user.Email},
+			mockFunc: func(mock sqlmock.Sqlmock) {},
+			wantErr:  database.ErrMissingSourceId,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newConsumerDB(t)
+			tt.mockFunc(mock)
+
+			id, result, err := db.UpsertUser(context.Background(), tt.user)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.wantID, id)
+			assert.Equal(t, tt.wantResult, result)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpsertUsers(t *testing.T) {
+	db, mock := newConsumerDB(t)
+
+	first, second := int64(1), int64(2)
+	users := []models.User{
+		{SourceId: &first, Email: "old"},
+		{SourceId: &second, Email: "unchanged"},
+		{SourceId: &first, Email: "new"},
+	}
+
+	// a source id appearing twice is written once, with its last user
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO users (source_id,email,email_index,firstname,lastname,pii_digest,parent_user_id,created_at,deleted_at,merged_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10),($11,$12,`)+`(?s:.*)`+regexp.QuoteMeta(`ON CONFLICT (source_id) DO UPDATE SET`)).
+		WithArgs(&second, "unchanged", nil, "", "", nil, nil, nil, nil, nil, &first, "new", nil, "", "", nil, nil, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"source_id", "id", "inserted"}).AddRow(int64(1), "10", true))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT source_id, id FROM users WHERE source_id IN ($1)")).
+		WithArgs(second).
+		WillReturnRows(sqlmock.NewRows([]string{"source_id", "id"}).AddRow(int64(2), "20"))
+	mock.ExpectCommit()
+
+	results, err := db.UpsertUsers(context.Background(), users)
+	require.NoError(t, err)
+	assert.Equal(t, []database.UpsertedUser{
+		{Id: "10", Result: database.UpsertResultSkipped},
+		{Id: "20", Result: database.UpsertResultSkipped},
+		{Id: "10", Result: database.UpsertResultInserted},
+	}, results)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = db.UpsertUsers(context.Background(), []models.User{{Email: "no source id"}})
+	assert.ErrorIs(t, err, database.ErrMissingSourceId)
+}
+
+// uniqueViolation is the error of a write violating a unique constraint.
+type uniqueViolation struct{}
+
+func (uniqueViolation) Error() string    { return "duplicate key value violates unique constraint" }
+func (uniqueViolation) SQLState() string { return "23505" }
+
+func TestRestoreUser(t *testing.T) {
+	restore := regexp.QuoteMeta("UPDATE users SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NOT NULL")
+
+	tests := []struct {
+		name    string
+		mock    func(sqlmock.Sqlmock)
+		wantErr error
+	}{
+		{
+			name: "restored",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(restore).WithArgs(int64(42)).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "email taken since the deletion",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(restore).WithArgs(int64(42)).WillReturnError(uniqueViolation{})
+			},
+			wantErr: models.ErrUserExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newConsumerDB(t)
+			tt.mock(mock)
+
+			err := db.RestoreUser(context.Background(), 42)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetUserByEmailIndex_SkipsDeletedUsers(t *testing.T) {
+	db, mock := newConsumerDB(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE email_index = $1 AND deleted_at IS NULL")).
+		WithArgs("index").
+		WillReturnError(sql.ErrNoRows)
+
+	_, err := db.GetUserByEmailIndex(context.Background(), "index")
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,6 +2,7 @@ package usecase
 
 import (
 	"context"
+	"time"
 
 	"github.com/viswals/consumer/usecase/repository/database"
 	"github.com/viswals/core/infrastructure/postgres"
@@ -14,10 +15,17 @@ import (
 )
 
 type IConsumerRepository interface {
-	CreateUser(ctx context.Context, user models.User) (id string, err error)
-	GetUserByEmail(ctx context.Context, email string) (user models.User, err error)
+	UpsertUser(ctx context.Context, user models.User) (id string, result database.UpsertResult, err error)
+	UpsertUsers(ctx context.Context, users []models.User) (results []database.UpsertedUser, err error)
+	GetUserByEmailIndex(ctx context.Context, emailIndex string) (user models.User, err error)
 	GetUserById(ctx context.Context, id int64) (user models.User, err error)
+	CreateUser(ctx context.Context, user models.User) (created models.User, err error)
+	UpdateUser(ctx context.Context, user models.User) (err error)
+	DeleteUser(ctx context.Context, id int64) (err error)
+	RestoreUser(ctx context.Context, id int64) (err error)
 	GetAllUsers(ctx context.Context, pagination utils.PaginationParams, filters []utils.Filter) (users []models.User, totalUsers int, err error)
+	GetEncryptedUsers(ctx context.Context, afterId int64, limit int) (users []models.User, err error)
+	UpdateEncryptedUsers(ctx context.Context, updates []database.EncryptedUserUpdate) (updated int64, err error)
 }
 
 type ConsumerUsecase struct {
@@ -26,6 +34,25 @@ type ConsumerUsecase struct {
 	db     IConsumerRepository
 	em     interfaces.IEncryptionService
 	cm     interfaces.ICacheService
+
+	// blind index of the emails and digest of the encrypted fields, see WithBlindIndex
+	bi interfaces.IBlindIndex
+
+	retryPolicy rabbitmq.RetryPolicy
+
+	// batching, see WithBatching
+	batchSize       int
+	batchMaxLatency time.Duration
+
+	// concurrency, see WithConcurrency and WithAdaptiveConcurrency
+	workers   int
+	prefetch  int
+	adaptive  *AdaptiveConcurrency
+	dbLatency latencyTracker
+
+	// aborted cancels the processing of the messages already received, see Abort
+	aborted context.Context
+	abort   context.CancelFunc
 }
 
 func (p *ConsumerUsecase) setDefaults() {
@@ -42,6 +69,36 @@ func (p *ConsumerUsecase) setDefaults() {
 	if p.cm == nil {
 		p.cm = redis.NewNoOpCache()
 	}
+
+	if p.batchSize <= 0 {
+		p.batchSize = 1
+	}
+
+	if p.batchMaxLatency <= 0 {
+		p.batchMaxLatency = defaultBatchMaxLatency
+	}
+
+	if p.workers <= 0 {
+		p.workers = defaultWorkers
+	}
+
+	if p.adaptive != nil {
+		p.adaptive.setDefaults()
+		p.workers = min(max(p.workers, p.adaptive.MinWorkers), p.adaptive.MaxWorkers)
+	}
+
+	// enough messages for every worker, or a batch for every worker and the next one filling while they are written
+	if p.prefetch <= 0 {
+		workers := p.workers
+		if p.adaptive != nil {
+			workers = p.adaptive.MaxWorkers
+		}
+
+		p.prefetch = workers
+		if p.batchSize > 1 {
+			p.prefetch = min((workers+1)*p.batchSize, maxPrefetch)
+		}
+	}
 }
 
 type Option func(*ConsumerUsecase)
@@ -52,24 +109,84 @@ func WithRabbitMQ(rmq *rabbitmq.RabbitMQ) Option {
 	}
 }
 
+// WithQueueService sets the queue service messages are consumed from and republished to.
+func WithQueueService(rmq interfaces.IQueueService) Option {
+	return func(p *ConsumerUsecase) {
+		p.rmq = rmq
+	}
+}
+
+// WithRepository sets the repository users are stored in, by default they are stored in the postgres database.
+func WithRepository(db IConsumerRepository) Option {
+	return func(p *ConsumerUsecase) {
+		p.db = db
+	}
+}
+
 func WithCacheManager(em interfaces.ICacheService) Option {
 	return func(p *ConsumerUsecase) {
 		p.cm = em
 	}
 }
 
+// WithBlindIndex stores the blind index of the emails along with them, so users can be looked up by email, and a
+// digest of the encrypted fields, so unchanged users are not written again. Without it users are stored without
+// either, looking them up by email fails with ErrBlindIndexNotConfigured and every upsert updates the user.
+func WithBlindIndex(bi interfaces.IBlindIndex) Option {
+	return func(p *ConsumerUsecase) {
+		p.bi = bi
+	}
+}
+
 func WithLogger(logger interfaces.ILogger) Option {
 	return func(p *ConsumerUsecase) {
 		p.logger = logger
 	}
 }
 
+// WithRetryPolicy sets how many times a failed message is retried before it is moved to the dead letter queue, by
+// default rabbitmq.DefaultRetryPolicy. The retry queues must be declared with the same policy, see DeclareRetryTopology.
+func WithRetryPolicy(policy rabbitmq.RetryPolicy) Option {
+	return func(p *ConsumerUsecase) {
+		p.retryPolicy = policy
+	}
+}
+
+// WithBatching makes the consumer write users in batches of up to size messages, a batch is written at the latest
+// maxLatency after its first message arrived. A size of 1 writes every message on its own.
+func WithBatching(size int, maxLatency time.Duration) Option {
+	return func(p *ConsumerUsecase) {
+		p.batchSize = size
+		p.batchMaxLatency = maxLatency
+	}
+}
+
+// WithConcurrency sets the number of workers processing messages, or writing batches when batching, and the number of
+// messages prefetched from the queue. A prefetch of 0 prefetches enough messages for every worker, or a batch for
+// every worker and one more when batching.
+func WithConcurrency(workers int, prefetch int) Option {
+	return func(p *ConsumerUsecase) {
+		p.workers = workers
+		p.prefetch = prefetch
+	}
+}
+
+// WithAdaptiveConcurrency scales the workers between the minimum and maximum of the config instead of running a
+// fixed number of them, the workers set by WithConcurrency are started first.
+func WithAdaptiveConcurrency(adaptive AdaptiveConcurrency) Option {
+	return func(p *ConsumerUsecase) {
+		p.adaptive = &adaptive
+	}
+}
+
 func New(db postgres.Postgres, rmq *rabbitmq.RabbitMQ, em interfaces.IEncryptionService, options ...Option) *ConsumerUsecase {
 
 	usecase := &ConsumerUsecase{
-		em:  em,
-		rmq: rmq,
+		em:          em,
+		rmq:         rmq,
+		retryPolicy: rabbitmq.DefaultRetryPolicy(),
 	}
+	usecase.aborted, usecase.abort = context.WithCancel(context.Background())
 
 	for _, option := range options {
 		option(usecase)
@@ -78,8 +195,9 @@ func New(db postgres.Postgres, rmq *rabbitmq.RabbitMQ, em interfaces.IEncryption
 	usecase.setDefaults()
 
 	// initialize repo layer
-	consumerDB := database.New(db.DB, usecase.logger)
-	usecase.db = consumerDB
+	if usecase.db == nil {
+		usecase.db = database.New(db.DB, usecase.logger)
+	}
 
 	return usecase
 }
@@ -3,15 +3,35 @@ package usecase
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"strings"
 	"time"
 
+	"github.com/viswals/core/infrastructure/encryption"
 	"github.com/viswals/core/infrastructure/redis"
 	"github.com/viswals/core/models"
+	"github.com/viswals/core/pkg/metrics"
+	"github.com/viswals/core/pkg/tracing"
 	"github.com/viswals/core/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+var (
+	ErrBlindIndexNotConfigured = errors.New("email blind index is not configured")
+)
+
+// GetAllUsers returns a page of users. A filter on the email is applied to its blind index, only the eq operator
+// matches as the index does not preserve anything of the email.
 func (c *ConsumerUsecase) GetAllUsers(ctx context.Context, pagination utils.PaginationParams, filters []utils.Filter) (users []models.User, totalUsers int, err error) {
+	ctx, span := tracing.Start(ctx, "ConsumerUsecase.GetAllUsers")
+	defer func() { tracing.End(span, err) }()
+
+	filters, err = c.indexEmailFilters(filters)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	users, totalUsers, err = c.db.GetAllUsers(ctx, pagination, filters)
 	if err != nil {
@@ -19,29 +39,32 @@ func (c *ConsumerUsecase) GetAllUsers(ctx context.Context, pagination utils.Pagi
 		return users, totalUsers, err
 	}
 
-	// decrypt email
-	for i, u := range users {
-		decryptedEmail, err := c.em.Decrypt(u.Email)
-		if err != nil {
-			c.logger.Error("Failed to decrypt user email", zap.Error(err))
-			users[i].Email = "" // do not expose internal data format of email
-		} else {
-			users[i].Email = decryptedEmail
-		}
+	// a user which can not be decrypted is returned with the fields emptied
+	for i := range users {
+		c.decryptUser(&users[i])
 	}
 
 	return users, totalUsers, nil
 }
 
 func (c *ConsumerUsecase) GetUserById(ctx context.Context, id int64) (user models.User, err error) {
+	ctx, span := tracing.Start(ctx, "ConsumerUsecase.GetUserById", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer func() { tracing.End(span, err) }()
 
 	// fetch data from cache service
-	data, err := c.cm.Get(ctx, redis.GetKey("users", id))
+	cacheCtx, cacheSpan := tracing.Start(ctx, "cache get", trace.WithSpanKind(trace.SpanKindClient))
+	data, err := c.cm.Get(cacheCtx, redis.GetKey("users", id))
+	cacheSpan.SetAttributes(attribute.Bool("cache.hit", err == nil))
+	cacheSpan.End()
 	if err != nil {
+		metrics.CacheRequests.WithLabelValues("get_user_by_id", metrics.CacheMiss).Inc()
+
 		// fetch data from repository service
 		user, err := c.db.GetUserById(ctx, id)
 		if err != nil {
-			c.logger.Error("Failed to get user data", zap.Error(err))
+			if !errors.Is(err, models.ErrUserNotFound) {
+				c.logger.Error("Failed to get user data", zap.Error(err))
+			}
 			return user, err
 		}
 
@@ -51,24 +74,20 @@ func (c *ConsumerUsecase) GetUserById(ctx context.Context, id int64) (user model
 			c.logger.Error("Failed to marshal user data", zap.Error(err))
 		}
 
-		err = c.cm.Set(ctx, redis.GetKey("users", id), string(data), time.Minute*10)
+		cacheCtx, cacheSpan := tracing.Start(ctx, "cache set", trace.WithSpanKind(trace.SpanKindClient))
+		err = c.cm.Set(cacheCtx, redis.GetKey("users", id), string(data), time.Minute*10)
+		tracing.End(cacheSpan, err)
 		if err != nil {
 			c.logger.Error("Failed to set cache", zap.Error(err))
 		}
 
-		// decrypt email from the database
-		decryptedEmail, err := c.em.Decrypt(user.Email)
-		if err != nil {
-			c.logger.Error("Failed to decrypt user email", zap.Error(err))
-			user.Email = "" // do not expose ineternal data format of email
-			return user, err
-		}
-		user.Email = decryptedEmail
-
-		return user, nil
+		// the cache holds the encrypted user, like the database
+		err = c.decryptUser(&user)
+		return user, err
 	}
 
 	// cache hit
+	metrics.CacheRequests.WithLabelValues("get_user_by_id", metrics.CacheHit).Inc()
 	err = json.Unmarshal([]byte(data), &user)
 	if err != nil {
 		c.logger.Error("Failed to unmarshal user data", zap.Error(err))
@@ -76,15 +95,247 @@ func (c *ConsumerUsecase) GetUserById(ctx context.Context, id int64) (user model
 		return user, err
 	}
 
-	// decrypt the user email
-	decryptedEmail, err := c.em.Decrypt(user.Email)
+	err = c.decryptUser(&user)
+	return user, err
+}
+
+// GetUserByEmail looks the user up by the blind index of the email, it returns models.ErrUserNotFound when there is none.
+func (c *ConsumerUsecase) GetUserByEmail(ctx context.Context, email string) (user models.User, err error) {
+	ctx, span := tracing.Start(ctx, "ConsumerUsecase.GetUserByEmail")
+	defer func() { tracing.End(span, err) }()
+
+	emailIndex := c.emailIndex(email)
+	if emailIndex == nil {
+		return user, ErrBlindIndexNotConfigured
+	}
+
+	user, err = c.db.GetUserByEmailIndex(ctx, *emailIndex)
 	if err != nil {
-		c.logger.Error("Failed to decrypt user email", zap.Error(err))
-		user.Email = "" // do not expose inetrnal data format of email
+		if !errors.Is(err, models.ErrUserNotFound) {
+			c.logger.Error("Failed to get user data", zap.Error(err))
+		}
 		return user, err
 	}
 
-	user.Email = decryptedEmail
+	err = c.decryptUser(&user)
+	return user, err
+}
+
+// CreateUser stores a user which does not come from the queue, its fields are encrypted the same way. It returns the
+// created user in clear, or models.ErrUserExists when a user has the same email, which is only detected with a blind
+// index.
+func (c *ConsumerUsecase) CreateUser(ctx context.Context, user models.User) (created models.User, err error) {
+	ctx, span := tracing.Start(ctx, "ConsumerUsecase.CreateUser")
+	defer func() { tracing.End(span, err) }()
+
+	// the other fields are set by the database
+	created = models.User{Email: user.Email, FirstName: user.FirstName, LastName: user.LastName, ParentUserId: user.ParentUserId}
+
+	stored := created
+	err = c.encryptUser(ctx, &stored)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	stored, err = c.db.CreateUser(ctx, stored)
+	if err != nil {
+		if !errors.Is(err, models.ErrUserExists) {
+			c.logger.Error("Failed to create user", zap.Error(err))
+		}
+		return models.User{}, err
+	}
+
+	created.Id, created.CreatedAt = stored.Id, stored.CreatedAt
+	span.SetAttributes(attribute.Int64("user.id", created.Id))
+
+	return created, nil
+}
+
+// ReplaceUser replaces the email, names and parent of the user, a nil parent removes it. It returns the updated user
+// in clear, models.ErrUserNotFound, models.ErrUserDeleted when the user is deleted or models.ErrUserExists when
+// another user has the same email.
+func (c *ConsumerUsecase) ReplaceUser(ctx context.Context, id int64, user models.User) (updated models.User, err error) {
+	ctx, span := tracing.Start(ctx, "ConsumerUsecase.ReplaceUser", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	return c.updateUser(ctx, id, func(current *models.User) {
+		current.Email, current.FirstName, current.LastName = user.Email, user.FirstName, user.LastName
+		current.ParentUserId = user.ParentUserId
+	})
+}
+
+// PatchUser changes the fields set in the patch and leaves the others as they are, it fails like ReplaceUser.
+func (c *ConsumerUsecase) PatchUser(ctx context.Context, id int64, patch models.UserPatch) (updated models.User, err error) {
+	ctx, span := tracing.Start(ctx, "ConsumerUsecase.PatchUser", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	return c.updateUser(ctx, id, func(current *models.User) {
+		if patch.Email != nil {
+			current.Email = *patch.Email
+		}
+		if patch.FirstName != nil {
+			current.FirstName = *patch.FirstName
+		}
+		if patch.LastName != nil {
+			current.LastName = *patch.LastName
+		}
+		if patch.ParentUserId != nil {
+			current.ParentUserId = patch.ParentUserId
+		}
+	})
+}
+
+// updateUser applies the change to the user in clear, then stores it encrypted and invalidates its cache entry.
+func (c *ConsumerUsecase) updateUser(ctx context.Context, id int64, change func(user *models.User)) (user models.User, err error) {
+	user, err = c.db.GetUserById(ctx, id)
+	if err != nil {
+		if !errors.Is(err, models.ErrUserNotFound) {
+			c.logger.Error("Failed to get user data", zap.Error(err))
+		}
+		return models.User{}, err
+	}
+
+	if user.DeletedAt != nil {
+		return models.User{}, models.ErrUserDeleted
+	}
+
+	// the digest covers every encrypted field, the unchanged ones are needed in clear too
+	err = c.decryptUser(&user)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	change(&user)
+
+	stored := user
+	err = c.encryptUser(ctx, &stored)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	err = c.db.UpdateUser(ctx, stored)
+	if err != nil {
+		if !isUserStateError(err) {
+			c.logger.Error("Failed to update user", zap.Int64("user_id", id), zap.Error(err))
+		}
+		return models.User{}, err
+	}
+
+	c.invalidateUser(ctx, id)
 
 	return user, nil
 }
+
+// DeleteUser soft deletes the user, it is then hidden from the reads unless they ask for the deleted users, and from
+// the lookups by email, and its email can be used by another user. Consuming its source row again keeps it deleted, only
+// RestoreUser restores it. It returns models.ErrUserNotFound or models.ErrUserDeleted when the user is already deleted.
+func (c *ConsumerUsecase) DeleteUser(ctx context.Context, id int64) (err error) {
+	ctx, span := tracing.Start(ctx, "ConsumerUsecase.DeleteUser", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	err = c.db.DeleteUser(ctx, id)
+	if err != nil {
+		if !isUserStateError(err) {
+			c.logger.Error("Failed to delete user", zap.Int64("user_id", id), zap.Error(err))
+		}
+		return err
+	}
+
+	c.invalidateUser(ctx, id)
+
+	return nil
+}
+
+// RestoreUser restores a soft deleted user and returns it. It returns models.ErrUserNotFound or
+// models.ErrUserNotDeleted when the user is not deleted.
+func (c *ConsumerUsecase) RestoreUser(ctx context.Context, id int64) (user models.User, err error) {
+	ctx, span := tracing.Start(ctx, "ConsumerUsecase.RestoreUser", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	err = c.db.RestoreUser(ctx, id)
+	if err != nil {
+		if !isUserStateError(err) {
+			c.logger.Error("Failed to restore user", zap.Int64("user_id", id), zap.Error(err))
+		}
+		return models.User{}, err
+	}
+
+	c.invalidateUser(ctx, id)
+
+	return c.GetUserById(ctx, id)
+}
+
+// invalidateUser removes the cached user, the next read gets it from the database. A failure is only logged, the
+// entry then expires with its ttl.
+func (c *ConsumerUsecase) invalidateUser(ctx context.Context, id int64) {
+	err := c.cm.Delete(ctx, redis.GetKey("users", id))
+	if err != nil {
+		c.logger.Error("Failed to invalidate cache", zap.Int64("user_id", id), zap.Error(err))
+	}
+}
+
+// isUserStateError reports whether the error is expected from a change of the user, rather than a failure.
+func isUserStateError(err error) bool {
+	return errors.Is(err, models.ErrUserNotFound) || errors.Is(err, models.ErrUserExists) ||
+		errors.Is(err, models.ErrUserDeleted) || errors.Is(err, models.ErrUserNotDeleted)
+}
+
+// decryptUser decrypts the fields of the encryption policy in place. A field which can not be decrypted is emptied,
+// the internal data format is never exposed.
+func (c *ConsumerUsecase) decryptUser(user *models.User) error {
+	err := encryption.DecryptFields(c.em, user)
+	if err != nil {
+		metrics.EncryptionErrors.WithLabelValues("decrypt").Inc()
+		c.logger.Error("Failed to decrypt user", zap.Int64("user_id", user.Id), zap.Error(err))
+	}
+
+	return err
+}
+
+// emailIndex returns the blind index of the normalized email, nil without blind index.
+func (c *ConsumerUsecase) emailIndex(email string) *string {
+	if c.bi == nil {
+		return nil
+	}
+
+	index := c.bi.Index(strings.ToLower(strings.TrimSpace(email)))
+	return &index
+}
+
+// piiDigest returns the digest of the plaintext of the encrypted fields of the user, nil without blind index.
+func (c *ConsumerUsecase) piiDigest(user models.User) *string {
+	if c.bi == nil {
+		return nil
+	}
+
+	// prefixed so the digest of a user never equals the index of an email
+	digest := c.bi.Index("user\x00" + strings.Join(encryption.FieldValues(user), "\x00"))
+	return &digest
+}
+
+// indexEmailFilters replaces the filters on the email by filters on its blind index.
+func (c *ConsumerUsecase) indexEmailFilters(filters []utils.Filter) ([]utils.Filter, error) {
+	indexed := make([]utils.Filter, 0, len(filters))
+	for _, filter := range filters {
+		if filter.Field != "email" {
+			indexed = append(indexed, filter)
+			continue
+		}
+
+		email, ok := filter.Value.(string)
+		if !ok || filter.Operator != utils.FilterOperatorEq {
+			return nil, errors.New("emails can only be filtered by equality")
+		}
+
+		emailIndex := c.emailIndex(email)
+		if emailIndex == nil {
+			return nil, ErrBlindIndexNotConfigured
+		}
+
+		filter.Field = "email_index"
+		filter.Value = *emailIndex
+		indexed = append(indexed, filter)
+	}
+
+	return indexed, nil
+}
@@ -2,92 +2,339 @@ package usecase_test
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/consumer/usecase"
 	mock_database "github.com/viswals/consumer/usecase/repository/database/mock"
+	"github.com/viswals/core/infrastructure/encryption"
+	"github.com/viswals/core/infrastructure/postgres"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
 	"github.com/viswals/core/models"
+	"github.com/viswals/core/pkg/utils"
 )
 
 // TODO: Write test cases for GetAllUsers, GetUserById and other crud APIs.
 
-func TestCreateUser(t *testing.T) {
+func TestGetUserByEmail(t *testing.T) {
 	ctrl := gomock.NewController(t)
-	mockConsumerRepo := mock_database.NewMockIConsumerRepository(ctrl)
 	defer ctrl.Finish()
 
-	ctx := context.Background()
+	em, err := encryption.New([]byte("abcdefghabcdefghabcdefghabcdefgh"))
+	require.NoError(t, err)
+	bi, err := encryption.NewBlindIndex([]byte("blind-index-key-blind-index-key!"))
+	require.NoError(t, err)
+
+	encrypted, err := em.Encrypt("john@example.com")
+	require.NoError(t, err)
+
+	mockRepo := mock_database.NewMockIConsumerRepository(ctrl)
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+
+	tests := []struct {
+		name          string
+		email         string
+		options       []usecase.Option
+		mockFunc      func()
+		expectedEmail string
+		expectedError error
+	}{
+		{
+			name:    "normalized email",
+			email:   " John@Example.com",
+			options: []usecase.Option{usecase.WithBlindIndex(bi)},
+			mockFunc: func() {
+				mockRepo.EXPECT().GetUserByEmailIndex(gomock.Any(), bi.Index("john@example.com")).
+					Return(models.User{Id: 1, Email: encrypted}, nil)
+			},
+			expectedEmail: "john@example.com",
+		},
+		{
+			name:    "not found",
+			email:   "jane@example.com",
+			options: []usecase.Option{usecase.WithBlindIndex(bi)},
+			mockFunc: func() {
+				mockRepo.EXPECT().GetUserByEmailIndex(gomock.Any(), bi.Index("jane@example.com")).
+					Return(models.User{}, models.ErrUserNotFound)
+			},
+			expectedError: models.ErrUserNotFound,
+		},
+		{
+			name:          "without blind index",
+			email:         "john@example.com",
+			mockFunc:      func() {},
+			expectedError: usecase.ErrBlindIndexNotConfigured,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockFunc()
+
+			options := append([]usecase.Option{usecase.WithLogger(mockLogger), usecase.WithRepository(mockRepo)}, tt.options...)
+			uc := usecase.New(postgres.Postgres{}, nil, em, options...)
+
+			user, err := uc.GetUserByEmail(context.Background(), tt.email)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedEmail, user.Email)
+		})
+	}
+}
+
+func TestGetAllUsers_EmailFilter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	em, err := encryption.New([]byte("abcdefghabcdefghabcdefghabcdefgh"))
+	require.NoError(t, err)
+	bi, err := encryption.NewBlindIndex([]byte("blind-index-key-blind-index-key!"))
+	require.NoError(t, err)
+
+	mockRepo := mock_database.NewMockIConsumerRepository(ctrl)
+	mockRepo.EXPECT().GetAllUsers(gomock.Any(), gomock.Any(), []utils.Filter{
+		{Field: "email_index", Operator: utils.FilterOperatorEq, Value: bi.Index("john@example.com")},
+	}).Return(nil, 0, nil)
+
+	uc := usecase.New(postgres.Postgres{}, nil, em, usecase.WithLogger(mock_interfaces.NewMockILogger(ctrl)),
+		usecase.WithRepository(mockRepo), usecase.WithBlindIndex(bi))
+
+	_, _, err = uc.GetAllUsers(context.Background(), utils.PaginationParams{Limit: 10}, []utils.Filter{
+		{Field: "email", Operator: utils.FilterOperatorEq, Value: "john@example.com"},
+	})
+	require.NoError(t, err)
+
+	_, _, err = uc.GetAllUsers(context.Background(), utils.PaginationParams{Limit: 10}, []utils.Filter{
+		{Field: "email", Operator: utils.FilterOperatorLike, Value: "john"},
+	})
+	assert.Error(t, err)
+}
+
+func TestGetUserById_EncryptedFields(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	em, err := encryption.New([]byte("abcdefghabcdefghabcdefghabcdefgh"))
+	require.NoError(t, err)
+
+	encrypted := models.User{Id: 1, Email: "john@example.com", FirstName: "John", LastName: "Doe"}
+	require.NoError(t, encryption.EncryptFields(em, &encrypted))
+	cached, err := json.Marshal(encrypted)
+	require.NoError(t, err)
+
+	mockRepo := mock_database.NewMockIConsumerRepository(ctrl)
+	mockCache := mock_interfaces.NewMockICacheService(ctrl)
 
 	tests := []struct {
 		name     string
-		user     models.User
-		wantID   string
-		wantErr  bool
 		mockFunc func()
 	}{
 		{
-			name: "success",
-			user: models.User{
-				Email:     "test@example.com",
-				FirstName: "Test",
-				LastName:  "User",
+			name: "cache miss",
+			mockFunc: func() {
+				mockCache.EXPECT().Get(gomock.Any(), "users:1").Return("", errors.New("cache miss"))
+				mockRepo.EXPECT().GetUserById(gomock.Any(), int64(1)).Return(encrypted, nil)
+				// the cache holds the encrypted user
+				mockCache.EXPECT().Set(gomock.Any(), "users:1", string(cached), gomock.Any()).Return(nil)
+			},
+		},
+		{
+			name: "cache hit",
+			mockFunc: func() {
+				mockCache.EXPECT().Get(gomock.Any(), "users:1").Return(string(cached), nil)
+			},
+		},
+	}
+
+	uc := usecase.New(postgres.Postgres{}, nil, em, usecase.WithLogger(mock_interfaces.NewMockILogger(ctrl)),
+		usecase.WithRepository(mockRepo), usecase.WithCacheManager(mockCache))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockFunc()
+
+			user, err := uc.GetUserById(context.Background(), 1)
+			require.NoError(t, err)
+			assert.Equal(t, models.User{Id: 1, Email: "john@example.com", FirstName: "John", LastName: "Doe"}, user)
+		})
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	em, err := encryption.New([]byte("abcdefghabcdefghabcdefghabcdefgh"))
+	require.NoError(t, err)
+	bi, err := encryption.NewBlindIndex([]byte("blind-index-key-blind-index-key!"))
+	require.NoError(t, err)
+
+	mockRepo := mock_database.NewMockIConsumerRepository(ctrl)
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	tests := []struct {
+		name          string
+		mockFunc      func()
+		expectedError error
+	}{
+		{
+			name: "created encrypted",
+			mockFunc: func() {
+				mockRepo.EXPECT().CreateUser(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, user models.User) (models.User, error) {
+					assert.True(t, encryption.IsCiphertext(user.Email))
+					assert.True(t, encryption.IsCiphertext(user.FirstName))
+					assert.Equal(t, bi.Index("jane@example.com"), *user.EmailIndex)
+					assert.NotNil(t, user.PiiDigest)
+
+					user.Id = 7
+					return user, nil
+				})
 			},
-			wantID:  "123",
-			wantErr: false,
+		},
+		{
+			name: "existing email",
 			mockFunc: func() {
-				mockConsumerRepo.EXPECT().
-					CreateUser(ctx, gomock.Any()).
-					Return("123", nil)
+				mockRepo.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(models.User{}, models.ErrUserExists)
 			},
+			expectedError: models.ErrUserExists,
 		},
+	}
+
+	uc := usecase.New(postgres.Postgres{}, nil, em, usecase.WithLogger(mockLogger),
+		usecase.WithRepository(mockRepo), usecase.WithBlindIndex(bi))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockFunc()
+
+			user, err := uc.CreateUser(context.Background(), models.User{Email: "Jane@example.com ", FirstName: "Jane", LastName: "Doe", SourceId: new(int64)})
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, models.User{Id: 7, Email: "Jane@example.com ", FirstName: "Jane", LastName: "Doe"}, user)
+		})
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	em, err := encryption.New([]byte("abcdefghabcdefghabcdefghabcdefgh"))
+	require.NoError(t, err)
+
+	// the names were stored before they were encrypted
+	stored := models.User{Id: 1, Email: "john@example.com", FirstName: "John", LastName: "Doe"}
+	require.NoError(t, encryption.EncryptFields(em, &stored))
+	stored.FirstName, stored.LastName = "John", "Doe"
+
+	deleted := stored
+	deleted.DeletedAt = new(time.Time)
+
+	mockRepo := mock_database.NewMockIConsumerRepository(ctrl)
+	mockCache := mock_interfaces.NewMockICacheService(ctrl)
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	firstName := "Johnny"
+
+	tests := []struct {
+		name          string
+		mockFunc      func()
+		expectedError error
+	}{
 		{
-			name: "duplicate Email",
-			user: models.User{
-				Email:     "existing@example.com",
-				FirstName: "Test",
-				LastName:  "User",
+			name: "updated and invalidated",
+			mockFunc: func() {
+				mockRepo.EXPECT().GetUserById(gomock.Any(), int64(1)).Return(stored, nil)
+				mockRepo.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, user models.User) error {
+					decrypted := user
+					require.NoError(t, encryption.DecryptFields(em, &decrypted))
+					assert.Equal(t, "john@example.com", decrypted.Email)
+					assert.Equal(t, "Johnny", decrypted.FirstName)
+					assert.True(t, encryption.IsCiphertext(user.LastName))
+					return nil
+				})
+				mockCache.EXPECT().Delete(gomock.Any(), "users:1").Return(nil)
 			},
-			wantID:  "",
-			wantErr: true,
+		},
+		{
+			name: "unknown user",
 			mockFunc: func() {
-				mockConsumerRepo.EXPECT().
-					CreateUser(ctx, gomock.Any()).
-					Return("", fmt.Errorf("duplicate email id"))
+				mockRepo.EXPECT().GetUserById(gomock.Any(), int64(1)).Return(models.User{}, models.ErrUserNotFound)
 			},
+			expectedError: models.ErrUserNotFound,
 		},
 		{
-			name: "invalid Email",
-			user: models.User{
-				Email:     "invalid-email",
-				FirstName: "Test",
-				LastName:  "User",
+			name: "deleted user",
+			mockFunc: func() {
+				mockRepo.EXPECT().GetUserById(gomock.Any(), int64(1)).Return(deleted, nil)
 			},
-			wantID:  "",
-			wantErr: true,
+			expectedError: models.ErrUserDeleted,
+		},
+		{
+			name: "deleted concurrently",
 			mockFunc: func() {
-				mockConsumerRepo.EXPECT().
-					CreateUser(ctx, gomock.Any()).
-					Return("", fmt.Errorf("invalid email format"))
+				mockRepo.EXPECT().GetUserById(gomock.Any(), int64(1)).Return(stored, nil)
+				mockRepo.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(models.ErrUserDeleted)
 			},
+			expectedError: models.ErrUserDeleted,
 		},
 	}
 
+	uc := usecase.New(postgres.Postgres{}, nil, em, usecase.WithLogger(mockLogger),
+		usecase.WithRepository(mockRepo), usecase.WithCacheManager(mockCache))
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-
-			// call mockFunc to setup mocks and call createUser function for testing
 			tt.mockFunc()
-			id, err := mockConsumerRepo.CreateUser(ctx, tt.user)
 
-			if tt.wantErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
+			user, err := uc.PatchUser(context.Background(), 1, models.UserPatch{FirstName: &firstName})
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
 			}
 
-			assert.Equal(t, tt.wantID, id)
+			require.NoError(t, err)
+			assert.Equal(t, models.User{Id: 1, Email: "john@example.com", FirstName: "Johnny", LastName: "Doe"}, user)
 		})
 	}
 }
+
+func TestDeleteAndRestoreUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mock_database.NewMockIConsumerRepository(ctrl)
+	mockCache := mock_interfaces.NewMockICacheService(ctrl)
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	uc := usecase.New(postgres.Postgres{}, nil, nil, usecase.WithLogger(mockLogger),
+		usecase.WithRepository(mockRepo), usecase.WithCacheManager(mockCache))
+
+	mockRepo.EXPECT().DeleteUser(gomock.Any(), int64(1)).Return(nil)
+	mockCache.EXPECT().Delete(gomock.Any(), "users:1").Return(nil)
+	require.NoError(t, uc.DeleteUser(context.Background(), 1))
+
+	// nothing to invalidate when the user was already deleted
+	mockRepo.EXPECT().DeleteUser(gomock.Any(), int64(1)).Return(models.ErrUserDeleted)
+	assert.ErrorIs(t, uc.DeleteUser(context.Background(), 1), models.ErrUserDeleted)
+
+	mockRepo.EXPECT().RestoreUser(gomock.Any(), int64(2)).Return(models.ErrUserNotDeleted)
+	_, err := uc.RestoreUser(context.Background(), 2)
+	assert.ErrorIs(t, err, models.ErrUserNotDeleted)
+}
@@ -0,0 +1,35 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// minBlindIndexKeySize is the minimum size of a blind index key, the size of the HMAC-SHA256 output.
+const minBlindIndexKeySize = 32
+
+// BlindIndex implements IBlindIndex with HMAC-SHA256. The index of a value is deterministic, so encrypted values can
+// be looked up and kept unique, and it can not be computed or reversed without the key.
+type BlindIndex struct {
+	key []byte
+}
+
+// NewBlindIndex creates a blind index, the key must be at least 32 bytes and differ from the encryption keys.
+// Changing the key changes every index, they all have to be computed again.
+func NewBlindIndex(key []byte) (*BlindIndex, error) {
+	if len(key) < minBlindIndexKeySize {
+		return nil, fmt.Errorf("%w: blind index key must be at least %d bytes, got %d", ErrInvalidKey, minBlindIndexKeySize, len(key))
+	}
+
+	return &BlindIndex{key: key}, nil
+}
+
+// Index returns the hex encoded HMAC of the value, callers normalize the value first.
+func (b *BlindIndex) Index(value string) string {
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -4,92 +4,240 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
-// New creates a new encryption instance, which can be used to encrypt or decrypt UTF-8 encoded messages.
-func New(key []byte) (*Encryption, error) {
+// Algorithms messages are encrypted with.
+const (
+	AlgorithmAESGCM            = "aes-gcm"
+	AlgorithmXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+// Versions prefixing the ciphertexts, each identifies the algorithm of the ciphertext.
+const (
+	versionAESGCM            = "v1"
+	versionXChaCha20Poly1305 = "v2"
+	// versionEnvelope ciphertexts are encrypted with AES-256-GCM by a data key wrapped by a KeyProvider, see Envelope
+	versionEnvelope = "v3"
+)
+
+// separator splits the version, the key id and the payload of a ciphertext. It is not part of the base64 alphabet,
+// so the unprefixed legacy ciphertexts are told apart.
+const separator = ":"
+
+var (
+	ErrInvalidKey          = errors.New("invalid encryption key")
+	ErrMalformedCiphertext = errors.New("malformed ciphertext")
+	ErrUnknownVersion      = errors.New("unknown ciphertext version")
+	ErrUnknownKey          = errors.New("ciphertext encrypted with an unknown key")
+	ErrNoLegacyKey         = errors.New("legacy ciphertext but no legacy key in the keyring")
+	ErrAuthentication      = errors.New("ciphertext authentication failed")
+)
+
+// Option configures the encryption.
+type Option func(*Encryption)
+
+// WithAlgorithm selects the algorithm new messages are encrypted with, AlgorithmAESGCM by default.
+// Messages encrypted with the other algorithm are still decrypted, provided the key suits it.
+func WithAlgorithm(algorithm string) Option {
+	return func(e *Encryption) {
+		e.algorithm = algorithm
+	}
+}
 
+// WithHasher sets the hasher of Hash, which hashes with DefaultHashParams by default.
+func WithHasher(hasher *Hasher) Option {
+	return func(e *Encryption) {
+		e.Hasher = hasher
+	}
+}
+
+// WithKeyID sets the id of the key written in the ciphertexts, it defaults to a fingerprint of the key.
+func WithKeyID(keyID string) Option {
+	return func(e *Encryption) {
+		e.keyID = keyID
+	}
+}
+
+// New creates a new encryption instance, which can be used to encrypt or decrypt UTF-8 encoded messages.
+// AES-GCM takes a 16, 24 or 32 bytes key and XChaCha20-Poly1305 a 32 bytes key, any other key is rejected.
+func New(key []byte, options ...Option) (*Encryption, error) {
 	if len(key) == 0 {
-		return nil, errors.New("encryption key can not be empty")
+		return nil, fmt.Errorf("%w: key can not be empty", ErrInvalidKey)
+	}
+
+	e := &Encryption{algorithm: AlgorithmAESGCM, aeads: make(map[string]cipher.AEAD)}
+	for _, option := range options {
+		option(e)
 	}
 
-	if len(key) == 16 || len(key) == 32 || len(key) == 64 {
-		return &Encryption{key: key}, nil
+	if e.keyID == "" {
+		e.keyID = Fingerprint(key)
 	}
 
-	return nil, errors.New("invalid encryption key length. Use 16, 32, or 64 bytes")
+	if e.Hasher == nil {
+		e.Hasher = defaultHasher()
+	}
+
+	if strings.Contains(e.keyID, separator) {
+		return nil, fmt.Errorf("key id %q can not contain %q", e.keyID, separator)
+	}
+
+	// AES keys also decrypt the legacy AES-CFB ciphertexts
+	if block, err := aes.NewCipher(key); err == nil {
+		e.legacy = block
+
+		e.aeads[versionAESGCM], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(key) == chacha20poly1305.KeySize {
+		aead, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, err
+		}
+
+		e.aeads[versionXChaCha20Poly1305] = aead
+	}
+
+	switch e.algorithm {
+	case AlgorithmAESGCM:
+		e.version = versionAESGCM
+	case AlgorithmXChaCha20Poly1305:
+		e.version = versionXChaCha20Poly1305
+	default:
+		return nil, fmt.Errorf("unknown encryption algorithm %q, expected %s or %s", e.algorithm, AlgorithmAESGCM, AlgorithmXChaCha20Poly1305)
+	}
+
+	if _, ok := e.aeads[e.version]; !ok {
+		return nil, fmt.Errorf("%w: %s takes a key of %s bytes, got %d", ErrInvalidKey, e.algorithm, keySizes(e.algorithm), len(key))
+	}
+
+	return e, nil
 }
 
-// Encryption implements EncryptionManager for AES encryption.
+// Fingerprint identifies a key without revealing it, it is the default id of the key in the ciphertexts.
+func Fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Encryption implements IEncryptionService with an AEAD, the ciphertexts are authenticated and carry the version
+// of the algorithm and the id of the key they were encrypted with: <version>:<key id>:<base64 nonce and sealed data>.
 type Encryption struct {
-	key []byte
+	*Hasher
+
+	keyID     string
+	algorithm string
+	version   string
+	aeads     map[string]cipher.AEAD
+	legacy    cipher.Block
 }
 
-func (e *Encryption) Encrypt(data string) (string, error) {
-	block, err := aes.NewCipher(e.key)
-	if err != nil {
-		return "", err
-	}
+// KeyID returns the id of the key written in the ciphertexts.
+func (e *Encryption) KeyID() string {
+	return e.keyID
+}
 
-	plainText := []byte(data)
-	cipherText := make([]byte, aes.BlockSize+len(plainText))
-	iv := cipherText[:aes.BlockSize]
+func (e *Encryption) Encrypt(data string) (string, error) {
+	aead := e.aeads[e.version]
+	prefix := e.prefix()
 
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", err
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(cipherText[aes.BlockSize:], plainText)
+	// the prefix is authenticated, so the version or the key id of a ciphertext can not be swapped
+	sealed := aead.Seal(nonce, nonce, []byte(data), []byte(prefix))
 
 	// Encode cipherText to Base64 to ensure it is text-safe
-	return base64.StdEncoding.EncodeToString(cipherText), nil
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
 }
 
+// Decrypt decrypts the versioned ciphertexts, and the legacy AES-CFB ones written before the ciphertexts were versioned.
 func (e *Encryption) Decrypt(data string) (string, error) {
-	// Decode Base64-encoded cipherText
-	cipherText, err := base64.StdEncoding.DecodeString(data)
+	version, rest, versioned := strings.Cut(data, separator)
+	if !versioned {
+		return e.decryptLegacy(data)
+	}
+
+	keyID, payload, ok := strings.Cut(rest, separator)
+	if !ok {
+		return "", ErrMalformedCiphertext
+	}
+
+	aead, ok := e.aeads[version]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownVersion, version)
+	}
+
+	if keyID != e.keyID {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(payload)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %w", ErrMalformedCiphertext, err)
+	}
+
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", fmt.Errorf("%w: ciphertext too short", ErrMalformedCiphertext)
 	}
 
-	block, err := aes.NewCipher(e.key)
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plainText, err := aead.Open(nil, nonce, sealed, []byte(version+separator+keyID+separator))
 	if err != nil {
-		return "", err
+		return "", ErrAuthentication
+	}
+
+	return string(plainText), nil
+}
+
+// prefix starts the ciphertexts encrypted by the instance.
+func (e *Encryption) prefix() string {
+	return e.version + separator + e.keyID + separator
+}
+
+// decryptLegacy decrypts the unauthenticated AES-CFB ciphertexts, a tampered one decrypts to garbage without error.
+func (e *Encryption) decryptLegacy(data string) (string, error) {
+	if e.legacy == nil {
+		return "", fmt.Errorf("%w: legacy ciphertexts need an AES key", ErrUnknownVersion)
+	}
+
+	// Decode Base64-encoded cipherText
+	cipherText, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrMalformedCiphertext, err)
 	}
 
 	if len(cipherText) < aes.BlockSize {
-		return "", errors.New("ciphertext too short")
+		return "", fmt.Errorf("%w: ciphertext too short", ErrMalformedCiphertext)
 	}
 
 	iv := cipherText[:aes.BlockSize]
 	cipherText = cipherText[aes.BlockSize:]
 
-	stream := cipher.NewCFBDecrypter(block, iv)
+	stream := cipher.NewCFBDecrypter(e.legacy, iv)
 	stream.XORKeyStream(cipherText, cipherText)
 
 	return string(cipherText), nil
 }
 
-func (e *Encryption) Hash(data string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(data), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-
-	return string(hash), nil
-}
-
-func (e *Encryption) CompareHash(data, hash string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(data))
-	if err != nil {
-		return false, err
+func keySizes(algorithm string) string {
+	if algorithm == AlgorithmXChaCha20Poly1305 {
+		return "32"
 	}
 
-	return true, nil
+	return "16, 24 or 32"
 }
@@ -1,8 +1,15 @@
 package encryption_test
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/viswals/core/infrastructure/encryption"
 )
 
@@ -52,3 +59,134 @@ func TestEncryption(t *testing.T) {
 		})
 	}
 }
+
+func TestEncryption_Algorithms(t *testing.T) {
+	key := []byte("abcdefghabcdefghabcdefghabcdefgh")
+
+	tests := []struct {
+		name           string
+		algorithm      string
+		expectedPrefix string
+	}{
+		{name: "aes-gcm", algorithm: encryption.AlgorithmAESGCM, expectedPrefix: "v1:"},
+		{name: "xchacha20-poly1305", algorithm: encryption.AlgorithmXChaCha20Poly1305, expectedPrefix: "v2:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			em, err := encryption.New(key, encryption.WithAlgorithm(tt.algorithm), encryption.WithKeyID("k1"))
+			require.NoError(t, err)
+
+			encrypted, err := em.Encrypt("ketan.rathod@bacancy.com")
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(encrypted, tt.expectedPrefix+"k1:"), encrypted)
+
+			decrypted, err := em.Decrypt(encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, "ketan.rathod@bacancy.com", decrypted)
+
+			// the version is enough to decrypt with an instance encrypting with the other algorithm
+			other, err := encryption.New(key, encryption.WithKeyID("k1"))
+			require.NoError(t, err)
+			decrypted, err = other.Decrypt(encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, "ketan.rathod@bacancy.com", decrypted)
+		})
+	}
+}
+
+func TestEncryption_Decrypt(t *testing.T) {
+	key := []byte("abcdefghabcdefghabcdefghabcdefgh")
+
+	em, err := encryption.New(key)
+	require.NoError(t, err)
+
+	encrypted, err := em.Encrypt("ketan.rathod@bacancy.com")
+	require.NoError(t, err)
+
+	prefix := encrypted[:strings.LastIndex(encrypted, ":")+1]
+	sealed, err := base64.StdEncoding.DecodeString(encrypted[len(prefix):])
+	require.NoError(t, err)
+	sealed[len(sealed)-1] ^= 1
+
+	tests := []struct {
+		name          string
+		data          string
+		expected      string
+		expectedError error
+	}{
+		{name: "legacy aes-cfb", data: encryptLegacy(t, key, "ketan.rathod@bacancy.com"), expected: "ketan.rathod@bacancy.com"},
+		{name: "tampered", data: prefix + base64.StdEncoding.EncodeToString(sealed), expectedError: encryption.ErrAuthentication},
+		{name: "swapped version", data: "v2" + strings.TrimPrefix(encrypted, "v1"), expectedError: encryption.ErrAuthentication},
+		{name: "unknown key", data: "v1:other" + encrypted[strings.Index(encrypted[3:], ":")+3:], expectedError: encryption.ErrUnknownKey},
+		{name: "unknown version", data: "v9" + strings.TrimPrefix(encrypted, "v1"), expectedError: encryption.ErrUnknownVersion},
+		{name: "truncated", data: prefix + "AAAA", expectedError: encryption.ErrMalformedCiphertext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypted, err := em.Decrypt(tt.data)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, decrypted)
+		})
+	}
+}
+
+func TestNew_InvalidKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       []byte
+		algorithm string
+	}{
+		{name: "empty", key: nil, algorithm: encryption.AlgorithmAESGCM},
+		{name: "64 bytes aes-gcm", key: make([]byte, 64), algorithm: encryption.AlgorithmAESGCM},
+		{name: "16 bytes xchacha20-poly1305", key: make([]byte, 16), algorithm: encryption.AlgorithmXChaCha20Poly1305},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := encryption.New(tt.key, encryption.WithAlgorithm(tt.algorithm))
+			assert.ErrorIs(t, err, encryption.ErrInvalidKey)
+		})
+	}
+
+	_, err := encryption.New(make([]byte, 32), encryption.WithAlgorithm("des"))
+	assert.Error(t, err)
+}
+
+// encryptLegacy encrypts the data as the AES-CFB implementation did before the ciphertexts were versioned.
+func encryptLegacy(t *testing.T, key []byte, data string) string {
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+
+	cipherText := make([]byte, aes.BlockSize+len(data))
+	iv := cipherText[:aes.BlockSize]
+	_, err = rand.Read(iv)
+	require.NoError(t, err)
+
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(cipherText[aes.BlockSize:], []byte(data))
+
+	return base64.StdEncoding.EncodeToString(cipherText)
+}
+
+func TestBlindIndex(t *testing.T) {
+	_, err := encryption.NewBlindIndex([]byte("short"))
+	assert.ErrorIs(t, err, encryption.ErrInvalidKey)
+
+	bi, err := encryption.NewBlindIndex([]byte("blind-index-key-blind-index-key!"))
+	require.NoError(t, err)
+
+	other, err := encryption.NewBlindIndex([]byte("another-blind-index-key-another!"))
+	require.NoError(t, err)
+
+	index := bi.Index("ketan.rathod@bacancy.com")
+	assert.Len(t, index, 64)
+	assert.Equal(t, index, bi.Index("ketan.rathod@bacancy.com"), "the index is deterministic")
+	assert.NotEqual(t, index, bi.Index("ketan@bacancy.com"))
+	assert.NotEqual(t, index, other.Index("ketan.rathod@bacancy.com"), "the index depends on the key")
+}
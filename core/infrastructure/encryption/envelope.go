@@ -0,0 +1,284 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dataKeySize is the size of the AES-256-GCM data keys
+	dataKeySize            = 32
+	defaultDataKeyUses     = 1000
+	defaultProviderTimeout = 10 * time.Second
+	// maxUnwrappedKeys bounds the data keys kept unwrapped, so reading a page of users does not call the provider for
+	// every field
+	maxUnwrappedKeys = 1024
+)
+
+// KeyProvider holds the master key of the envelope encryption and wraps the data keys with it, so the master key never
+// sits in the process. The wrapped keys are opaque, they name the master key they were wrapped with so the provider
+// keeps unwrapping them after it rotated its master key.
+type KeyProvider interface {
+	WrapKey(ctx context.Context, dataKey []byte) (wrapped []byte, err error)
+	UnwrapKey(ctx context.Context, wrapped []byte) (dataKey []byte, err error)
+}
+
+// EnvelopeOption configures the envelope encryption.
+type EnvelopeOption func(*Envelope)
+
+// WithFallback decrypts the values which are not envelope ciphertexts with the fallback, such as the keyring the
+// values were encrypted with before envelope encryption. Without it they fail to decrypt.
+func WithFallback(fallback Cipher) EnvelopeOption {
+	return func(e *Envelope) {
+		e.fallback = fallback
+	}
+}
+
+// WithDataKeyUses sets how many values a data key encrypts before a new one is generated, 1 encrypts every value with
+// its own data key. The data keys are wrapped by the provider, the fewer uses the more calls to it.
+func WithDataKeyUses(uses int) EnvelopeOption {
+	return func(e *Envelope) {
+		e.dataKeyUses = uses
+	}
+}
+
+// WithEnvelopeHasher sets the hasher of Hash, which hashes with DefaultHashParams by default.
+func WithEnvelopeHasher(hasher *Hasher) EnvelopeOption {
+	return func(e *Envelope) {
+		e.Hasher = hasher
+	}
+}
+
+// WithProviderTimeout bounds the calls to the key provider, 10 seconds by default.
+func WithProviderTimeout(timeout time.Duration) EnvelopeOption {
+	return func(e *Envelope) {
+		e.providerTimeout = timeout
+	}
+}
+
+// Envelope implements IEncryptionService with envelope encryption: values are encrypted with AES-256-GCM by data keys
+// generated in process, and the data keys are wrapped by the master key of a KeyProvider. The wrapped data key is
+// part of the ciphertext: v3:<base64url wrapped data key>:<base64 nonce and sealed data>.
+type Envelope struct {
+	*Hasher
+
+	provider        KeyProvider
+	fallback        Cipher
+	dataKeyUses     int
+	providerTimeout time.Duration
+
+	mu        sync.Mutex
+	dataKey   *dataKey
+	unwrapped map[string]cipher.AEAD
+}
+
+// dataKey is the data key values are currently encrypted with.
+type dataKey struct {
+	aead   cipher.AEAD
+	prefix string
+	uses   int
+}
+
+// NewEnvelope creates the envelope encryption of the provider.
+func NewEnvelope(provider KeyProvider, options ...EnvelopeOption) *Envelope {
+	e := &Envelope{provider: provider, unwrapped: make(map[string]cipher.AEAD)}
+	for _, option := range options {
+		option(e)
+	}
+
+	if e.dataKeyUses <= 0 {
+		e.dataKeyUses = defaultDataKeyUses
+	}
+
+	if e.providerTimeout <= 0 {
+		e.providerTimeout = defaultProviderTimeout
+	}
+
+	if e.Hasher == nil {
+		e.Hasher = defaultHasher()
+	}
+
+	return e
+}
+
+func (e *Envelope) Encrypt(data string) (string, error) {
+	key, err := e.nextDataKey()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, key.aead.NonceSize(), key.aead.NonceSize()+len(data)+key.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	// the prefix is authenticated, so the data key of a ciphertext can not be swapped
+	sealed := key.aead.Seal(nonce, nonce, []byte(data), []byte(key.prefix))
+
+	return key.prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts the envelope ciphertexts, and the other ones with the fallback.
+func (e *Envelope) Decrypt(data string) (string, error) {
+	version, rest, _ := strings.Cut(data, separator)
+	if version != versionEnvelope {
+		if e.fallback == nil {
+			return "", fmt.Errorf("%w: not an envelope ciphertext and there is no fallback", ErrUnknownVersion)
+		}
+
+		return e.fallback.Decrypt(data)
+	}
+
+	wrapped, payload, ok := strings.Cut(rest, separator)
+	if !ok {
+		return "", ErrMalformedCiphertext
+	}
+
+	aead, err := e.unwrap(wrapped)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrMalformedCiphertext, err)
+	}
+
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", fmt.Errorf("%w: ciphertext too short", ErrMalformedCiphertext)
+	}
+
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plainText, err := aead.Open(nil, nonce, sealed, []byte(versionEnvelope+separator+wrapped+separator))
+	if err != nil {
+		return "", ErrAuthentication
+	}
+
+	return string(plainText), nil
+}
+
+// Reencrypt moves the values of the fallback into envelope ciphertexts, it reports false and returns the data unchanged
+// when it is already one, once it checked it still decrypts. The master key is rotated by the provider, which keeps
+// unwrapping the data keys wrapped with its former keys.
+func (e *Envelope) Reencrypt(data string) (string, bool, error) {
+	if strings.HasPrefix(data, versionEnvelope+separator) {
+		// a data key the provider no longer unwraps is reported, rather than the value being taken as up to date
+		_, err := e.Decrypt(data)
+		if err != nil {
+			return "", false, err
+		}
+
+		return data, false, nil
+	}
+
+	plainText, err := e.Decrypt(data)
+	if err != nil {
+		return "", false, err
+	}
+
+	reencrypted, err := e.Encrypt(plainText)
+	if err != nil {
+		return "", false, err
+	}
+
+	return reencrypted, true, nil
+}
+
+// nextDataKey returns the data key to encrypt the next value with, a new one is generated and wrapped once the
+// current one has been used dataKeyUses times.
+func (e *Envelope) nextDataKey() (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.dataKey == nil || e.dataKey.uses >= e.dataKeyUses {
+		key := make([]byte, dataKeySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), e.providerTimeout)
+		defer cancel()
+
+		wrapped, err := e.provider.WrapKey(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap data key: %w", err)
+		}
+
+		aead, err := newDataKeyAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded := base64.RawURLEncoding.EncodeToString(wrapped)
+		e.dataKey = &dataKey{aead: aead, prefix: versionEnvelope + separator + encoded + separator}
+		e.cacheUnwrapped(encoded, aead)
+	}
+
+	e.dataKey.uses++
+
+	return e.dataKey, nil
+}
+
+// unwrap returns the data key of the encoded wrapped key, it is unwrapped by the provider on first use.
+func (e *Envelope) unwrap(encoded string) (cipher.AEAD, error) {
+	e.mu.Lock()
+	aead, ok := e.unwrapped[encoded]
+	e.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedCiphertext, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.providerTimeout)
+	defer cancel()
+
+	key, err := e.provider.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	aead, err = newDataKeyAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.cacheUnwrapped(encoded, aead)
+	e.mu.Unlock()
+
+	return aead, nil
+}
+
+// cacheUnwrapped keeps the data key unwrapped, the cache is emptied once full. The lock must be held.
+func (e *Envelope) cacheUnwrapped(encoded string, aead cipher.AEAD) {
+	if len(e.unwrapped) >= maxUnwrappedKeys {
+		clear(e.unwrapped)
+	}
+
+	e.unwrapped[encoded] = aead
+}
+
+func newDataKeyAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("%w: data key of %d bytes, expected %d", ErrInvalidKey, len(key), dataKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
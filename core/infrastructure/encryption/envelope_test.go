@@ -0,0 +1,159 @@
+package encryption_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/core/infrastructure/encryption"
+)
+
+// countingProvider counts the data keys wrapped and unwrapped by the provider.
+type countingProvider struct {
+	encryption.KeyProvider
+	wrapped   int
+	unwrapped int
+}
+
+func (p *countingProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	p.wrapped++
+	return p.KeyProvider.WrapKey(ctx, dataKey)
+}
+
+func (p *countingProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	p.unwrapped++
+	return p.KeyProvider.UnwrapKey(ctx, wrapped)
+}
+
+func newStaticProvider(t *testing.T) *countingProvider {
+	provider, err := encryption.NewStaticKeyProvider([]byte("masterkeymasterkeymasterkeymaste"))
+	require.NoError(t, err)
+
+	return &countingProvider{KeyProvider: provider}
+}
+
+// dataKeyOf returns the wrapped data key of an envelope ciphertext.
+func dataKeyOf(ciphertext string) string {
+	return strings.Split(ciphertext, ":")[1]
+}
+
+func TestEnvelope(t *testing.T) {
+	provider := newStaticProvider(t)
+	e := encryption.NewEnvelope(provider, encryption.WithDataKeyUses(2))
+
+	var ciphertexts []string
+	for _, email := range []string{"john@example.com", "jane@example.com", "jim@example.com"} {
+		ciphertext, err := e.Encrypt(email)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(ciphertext, "v3:"), ciphertext)
+		assert.True(t, encryption.IsCiphertext(ciphertext), ciphertext)
+
+		ciphertexts = append(ciphertexts, ciphertext)
+	}
+
+	// a data key encrypts two values, then a new one is wrapped
+	assert.Equal(t, 2, provider.wrapped)
+	assert.Equal(t, dataKeyOf(ciphertexts[0]), dataKeyOf(ciphertexts[1]))
+	assert.NotEqual(t, dataKeyOf(ciphertexts[1]), dataKeyOf(ciphertexts[2]))
+
+	// another process unwraps every data key once
+	reader := encryption.NewEnvelope(provider)
+	for i, email := range []string{"john@example.com", "jane@example.com", "jim@example.com"} {
+		plainText, err := reader.Decrypt(ciphertexts[i])
+		require.NoError(t, err)
+		assert.Equal(t, email, plainText)
+	}
+	assert.Equal(t, 2, provider.unwrapped)
+
+	// the wrapped data key is authenticated along with the value
+	swapped := "v3:" + dataKeyOf(ciphertexts[2]) + ":" + strings.Split(ciphertexts[0], ":")[2]
+	_, err := reader.Decrypt(swapped)
+	assert.ErrorIs(t, err, encryption.ErrAuthentication)
+
+	_, err = reader.Decrypt("v3:" + dataKeyOf(ciphertexts[0]))
+	assert.ErrorIs(t, err, encryption.ErrMalformedCiphertext)
+}
+
+func TestEnvelope_PerRecordDataKeys(t *testing.T) {
+	provider := newStaticProvider(t)
+	e := encryption.NewEnvelope(provider, encryption.WithDataKeyUses(1))
+
+	first, err := e.Encrypt("john@example.com")
+	require.NoError(t, err)
+	second, err := e.Encrypt("john@example.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, provider.wrapped)
+	assert.NotEqual(t, dataKeyOf(first), dataKeyOf(second))
+}
+
+func TestEnvelope_Fallback(t *testing.T) {
+	keyring, err := encryption.NewKeyring([]encryption.Key{{ID: "2025", Secret: []byte("abcdefghabcdefghabcdefghabcdefgh")}}, "")
+	require.NoError(t, err)
+
+	before, err := keyring.Encrypt("john@example.com")
+	require.NoError(t, err)
+
+	_, err = encryption.NewEnvelope(newStaticProvider(t)).Decrypt(before)
+	assert.ErrorIs(t, err, encryption.ErrUnknownVersion)
+
+	e := encryption.NewEnvelope(newStaticProvider(t), encryption.WithFallback(keyring))
+
+	plainText, err := e.Decrypt(before)
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", plainText)
+
+	// the re-encryption job moves the values of the keyring into the envelope
+	reencrypted, changed, err := e.Reencrypt(before)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, strings.HasPrefix(reencrypted, "v3:"), reencrypted)
+
+	again, changed, err := e.Reencrypt(reencrypted)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, reencrypted, again)
+
+	plainText, err = e.Decrypt(reencrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", plainText)
+
+	// an envelope ciphertext whose data key the provider does not unwrap is reported, not taken as up to date
+	other, err := encryption.NewStaticKeyProvider([]byte("othermasterkeyothermasterkeyothe"))
+	require.NoError(t, err)
+	_, _, err = encryption.NewEnvelope(other).Reencrypt(reencrypted)
+	assert.Error(t, err)
+}
+
+func TestKeyProviders(t *testing.T) {
+	t.Setenv("TEST_MASTER_KEY", "masterkeymasterkeymasterkeymaste")
+	envProvider, err := encryption.NewEnvKeyProvider("TEST_MASTER_KEY")
+	require.NoError(t, err)
+	_, set := os.LookupEnv("TEST_MASTER_KEY")
+	assert.False(t, set, "the master key is removed from the environment")
+
+	path := filepath.Join(t.TempDir(), "master.key")
+	require.NoError(t, os.WriteFile(path, []byte("masterkeymasterkeymasterkeymaste\n"), 0600))
+	fileProvider, err := encryption.NewFileKeyProvider(path)
+	require.NoError(t, err)
+
+	// both providers hold the same master key
+	wrapped, err := envProvider.WrapKey(context.Background(), []byte("datakeydatakeydatakeydatakeydata"))
+	require.NoError(t, err)
+	dataKey, err := fileProvider.UnwrapKey(context.Background(), wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("datakeydatakeydatakeydatakeydata"), dataKey)
+
+	_, err = fileProvider.UnwrapKey(context.Background(), []byte("bm90IGEgY2lwaGVydGV4dA=="))
+	assert.ErrorIs(t, err, encryption.ErrMalformedCiphertext)
+
+	_, err = encryption.NewEnvKeyProvider("TEST_MASTER_KEY")
+	assert.ErrorIs(t, err, encryption.ErrInvalidKey)
+
+	_, err = encryption.NewStaticKeyProvider([]byte("short"))
+	assert.ErrorIs(t, err, encryption.ErrInvalidKey)
+}
@@ -0,0 +1,174 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// policyTag marks the string fields encrypted at rest, `pii:"encrypted"`. With the allow-plaintext option,
+// `pii:"encrypted,allow-plaintext"`, the field may still hold the plaintext written before it was encrypted.
+const policyTag = "pii"
+
+// ciphertextPattern matches the versioned ciphertexts, see Encryption.
+var ciphertextPattern = regexp.MustCompile(`^v[0-9]+:[^:]+:[A-Za-z0-9+/]+=*$`)
+
+// Cipher encrypts and decrypts the fields of a policy, it is implemented by Encryption and Keyring.
+type Cipher interface {
+	Encrypt(data string) (string, error)
+	Decrypt(data string) (string, error)
+}
+
+// Rotator moves ciphertexts to the active key, it is implemented by Keyring.
+type Rotator interface {
+	Reencrypt(data string) (string, bool, error)
+}
+
+// Field is a field of a struct encrypted at rest.
+type Field struct {
+	// Name of the field and Column it is stored in, from the db tag
+	Name   string
+	Column string
+	// AllowPlaintext fields may hold the plaintext written before the field was encrypted, it is returned as is
+	AllowPlaintext bool
+
+	index []int
+}
+
+var policies sync.Map // reflect.Type -> []Field
+
+// IsCiphertext reports whether the data is a versioned ciphertext, as opposed to plaintext or a legacy ciphertext.
+func IsCiphertext(data string) bool {
+	return ciphertextPattern.MatchString(data)
+}
+
+// Fields returns the fields of the struct, or pointer to struct, encrypted at rest. It panics when a tagged field
+// is not a string, the policy is a programming error.
+func Fields(v any) []Field {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if fields, ok := policies.Load(t); ok {
+		return fields.([]Field)
+	}
+
+	var fields []Field
+	for _, structField := range reflect.VisibleFields(t) {
+		tag, ok := structField.Tag.Lookup(policyTag)
+		if !ok {
+			continue
+		}
+
+		options := strings.Split(tag, ",")
+		if options[0] != "encrypted" {
+			continue
+		}
+
+		if structField.Type.Kind() != reflect.String {
+			panic(fmt.Sprintf("encrypted field %s.%s must be a string", t.Name(), structField.Name))
+		}
+
+		column, _, _ := strings.Cut(structField.Tag.Get("db"), ",")
+		if column == "" {
+			column = strings.ToLower(structField.Name)
+		}
+
+		field := Field{Name: structField.Name, Column: column, index: structField.Index}
+		for _, option := range options[1:] {
+			if option == "allow-plaintext" {
+				field.AllowPlaintext = true
+			}
+		}
+
+		fields = append(fields, field)
+	}
+
+	policies.Store(t, fields)
+
+	return fields
+}
+
+// FieldValues returns the values of the fields of the policy, in the order of the struct.
+func FieldValues(v any) []string {
+	value := reflect.Indirect(reflect.ValueOf(v))
+
+	fields := Fields(v)
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = value.FieldByIndex(field.index).String()
+	}
+
+	return values
+}
+
+// EncryptFields encrypts the fields of the policy in place, v is a pointer to a struct.
+func EncryptFields(cipher Cipher, v any) error {
+	value := reflect.ValueOf(v).Elem()
+	for _, field := range Fields(v) {
+		fieldValue := value.FieldByIndex(field.index)
+
+		encrypted, err := cipher.Encrypt(fieldValue.String())
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", field.Name, err)
+		}
+
+		fieldValue.SetString(encrypted)
+	}
+
+	return nil
+}
+
+// DecryptFields decrypts the fields of the policy in place, v is a pointer to a struct. A field which can not be
+// decrypted is emptied, so its ciphertext is never exposed, and the errors of all such fields are returned.
+func DecryptFields(cipher Cipher, v any) error {
+	var errs []error
+
+	value := reflect.ValueOf(v).Elem()
+	for _, field := range Fields(v) {
+		fieldValue := value.FieldByIndex(field.index)
+		if field.AllowPlaintext && !IsCiphertext(fieldValue.String()) {
+			continue
+		}
+
+		decrypted, err := cipher.Decrypt(fieldValue.String())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to decrypt %s: %w", field.Name, err))
+			decrypted = ""
+		}
+
+		fieldValue.SetString(decrypted)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ReencryptFields moves the fields of the policy to the active key of the rotator in place, and encrypts the
+// plaintext left in the fields allowing it. It reports whether any field changed.
+func ReencryptFields(cipher Cipher, rotator Rotator, v any) (changed bool, err error) {
+	value := reflect.ValueOf(v).Elem()
+	for _, field := range Fields(v) {
+		fieldValue := value.FieldByIndex(field.index)
+
+		var reencrypted string
+		var fieldChanged bool
+		if field.AllowPlaintext && !IsCiphertext(fieldValue.String()) {
+			reencrypted, err = cipher.Encrypt(fieldValue.String())
+			fieldChanged = true
+		} else {
+			reencrypted, fieldChanged, err = rotator.Reencrypt(fieldValue.String())
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to re-encrypt %s: %w", field.Name, err)
+		}
+
+		fieldValue.SetString(reencrypted)
+		changed = changed || fieldChanged
+	}
+
+	return changed, nil
+}
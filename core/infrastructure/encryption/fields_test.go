@@ -0,0 +1,100 @@
+package encryption_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/core/infrastructure/encryption"
+)
+
+type account struct {
+	Id       int64
+	Email    string `db:"email" pii:"encrypted"`
+	Name     string `db:"full_name" pii:"encrypted,allow-plaintext"`
+	Country  string `db:"country"`
+	Nickname string `pii:"-"`
+}
+
+func TestFields(t *testing.T) {
+	fields := encryption.Fields(&account{})
+	require.Len(t, fields, 2)
+
+	assert.Equal(t, "Email", fields[0].Name)
+	assert.Equal(t, "email", fields[0].Column)
+	assert.False(t, fields[0].AllowPlaintext)
+	assert.Equal(t, "Name", fields[1].Name)
+	assert.Equal(t, "full_name", fields[1].Column)
+	assert.True(t, fields[1].AllowPlaintext)
+
+	assert.Equal(t, []string{"john@example.com", "John Doe"}, encryption.FieldValues(account{Email: "john@example.com", Name: "John Doe"}))
+
+	assert.Panics(t, func() {
+		encryption.Fields(struct {
+			Age int `pii:"encrypted"`
+		}{})
+	})
+}
+
+func TestEncryptFields(t *testing.T) {
+	em, err := encryption.New([]byte("abcdefghabcdefghabcdefghabcdefgh"), encryption.WithKeyID("2025"))
+	require.NoError(t, err)
+
+	a := account{Id: 1, Email: "john@example.com", Name: "John Doe", Country: "IN", Nickname: "JD"}
+	require.NoError(t, encryption.EncryptFields(em, &a))
+
+	assert.True(t, encryption.IsCiphertext(a.Email))
+	assert.True(t, encryption.IsCiphertext(a.Name))
+	assert.Equal(t, "IN", a.Country)
+	assert.Equal(t, "JD", a.Nickname)
+
+	require.NoError(t, encryption.DecryptFields(em, &a))
+	assert.Equal(t, account{Id: 1, Email: "john@example.com", Name: "John Doe", Country: "IN", Nickname: "JD"}, a)
+
+	// names written in clear before they were encrypted are returned as is
+	a = account{Name: "Jane Doe"}
+	a.Email, err = em.Encrypt("jane@example.com")
+	require.NoError(t, err)
+	require.NoError(t, encryption.DecryptFields(em, &a))
+	assert.Equal(t, account{Email: "jane@example.com", Name: "Jane Doe"}, a)
+
+	// a field which can not be decrypted is emptied, the others are decrypted
+	name, err := em.Encrypt("Jim Beam")
+	require.NoError(t, err)
+	a = account{Email: "v1:2023:AAAA", Name: name}
+	err = encryption.DecryptFields(em, &a)
+	assert.ErrorIs(t, err, encryption.ErrUnknownKey)
+	assert.Equal(t, account{Name: "Jim Beam"}, a)
+}
+
+func TestReencryptFields(t *testing.T) {
+	oldKey := encryption.Key{ID: "2024", Secret: []byte("abcdefghabcdefghabcdefghabcdefgh")}
+	newKey := encryption.Key{ID: "2025", Secret: []byte("hgfedcbahgfedcbahgfedcbahgfedcba")}
+
+	before, err := encryption.NewKeyring([]encryption.Key{oldKey}, "")
+	require.NoError(t, err)
+	after, err := encryption.NewKeyring([]encryption.Key{oldKey, newKey}, "2025")
+	require.NoError(t, err)
+
+	email, err := before.Encrypt("john@example.com")
+	require.NoError(t, err)
+
+	a := account{Email: email, Name: "John Doe"}
+	changed, err := encryption.ReencryptFields(after, after, &a)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	for _, value := range encryption.FieldValues(a) {
+		assert.True(t, strings.HasPrefix(value, "v1:2025:"), value)
+	}
+
+	reencrypted := a
+	changed, err = encryption.ReencryptFields(after, after, &a)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, reencrypted, a)
+
+	require.NoError(t, encryption.DecryptFields(after, &a))
+	assert.Equal(t, account{Email: "john@example.com", Name: "John Doe"}, a)
+}
@@ -0,0 +1,371 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Algorithms data is hashed with.
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+	HashScrypt   = "scrypt"
+)
+
+// bcryptMaxInput is the longest input bcrypt hashes, the rest would be ignored.
+const bcryptMaxInput = 72
+
+// maximum parameters of the hashes, whether configured or read from a stored hash. Comparing a forged hash takes at
+// most 1 GiB of memory with argon2id, 256 MiB with scrypt, and a few seconds of cpu, where the recommended parameters
+// take 19 MiB or 128 MiB and tens of milliseconds.
+const (
+	maxArgon2Memory      = 1 << 20 // KiB
+	maxArgon2Iterations  = 8
+	maxArgon2Parallelism = 16
+	maxScryptMemory      = 256 << 20 // bytes, 128 * r * N
+	maxScryptR           = 32
+	maxScryptP           = 4
+	maxBcryptCost        = 16
+	maxHashLength        = 1024
+)
+
+var (
+	ErrUnknownHash       = errors.New("unknown hash algorithm")
+	ErrMalformedHash     = errors.New("malformed hash")
+	ErrHashInputTooLong  = errors.New("bcrypt hashes at most 72 bytes")
+	ErrInvalidHashParams = errors.New("invalid hash parameters")
+)
+
+// HashParams configures the algorithm new hashes are computed with, and its parameters.
+type HashParams struct {
+	Algorithm string
+
+	// Argon2Memory is in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+
+	BcryptCost int
+
+	// ScryptLogN is the base 2 logarithm of the cost N
+	ScryptLogN uint8
+	ScryptR    int
+	ScryptP    int
+
+	// SaltLength and KeyLength of argon2id and scrypt, in bytes
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// DefaultHashParams returns argon2id with the parameters recommended by OWASP, and recommended parameters for the
+// other algorithms.
+func DefaultHashParams() HashParams {
+	return HashParams{
+		Algorithm:         HashArgon2id,
+		Argon2Memory:      19 * 1024,
+		Argon2Iterations:  2,
+		Argon2Parallelism: 1,
+		BcryptCost:        12,
+		ScryptLogN:        17,
+		ScryptR:           8,
+		ScryptP:           1,
+		SaltLength:        16,
+		KeyLength:         32,
+	}
+}
+
+// Hasher hashes data, such as passwords, into PHC strings: $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+// and $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>, with unpadded base64. bcrypt hashes keep their usual $2a$ format.
+// Hashes of every algorithm are compared, so stored hashes are migrated as they are checked, see NeedsRehash.
+type Hasher struct {
+	params HashParams
+}
+
+// NewHasher creates a hasher computing new hashes with the params.
+func NewHasher(params HashParams) (*Hasher, error) {
+	err := params.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Hasher{params: params}, nil
+}
+
+// defaultHasher hashes with DefaultHashParams.
+func defaultHasher() *Hasher {
+	return &Hasher{params: DefaultHashParams()}
+}
+
+func (h *Hasher) Hash(data string) (string, error) {
+	switch h.params.Algorithm {
+	case HashBcrypt:
+		if len(data) > bcryptMaxInput {
+			return "", ErrHashInputTooLong
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(data), h.params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(hash), nil
+	case HashArgon2id, HashScrypt:
+		salt := make([]byte, h.params.SaltLength)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return "", err
+		}
+
+		params := h.params
+		params.SaltLength = uint32(len(salt))
+
+		key, err := params.derive(data, salt)
+		if err != nil {
+			return "", err
+		}
+
+		return params.encode(salt, key), nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownHash, h.params.Algorithm)
+	}
+}
+
+// CompareHash reports whether the hash is the hash of the data, whichever algorithm and parameters it was computed
+// with. It fails when the hash is malformed, a mismatch is not an error.
+func (h *Hasher) CompareHash(data, hash string) (bool, error) {
+	if isBcrypt(hash) {
+		// bcrypt would compare the first 72 bytes only
+		if len(data) > bcryptMaxInput {
+			return false, ErrHashInputTooLong
+		}
+
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+		}
+		if cost > maxBcryptCost {
+			return false, fmt.Errorf("%w: bcrypt cost exceeds %d", ErrMalformedHash, maxBcryptCost)
+		}
+
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(data))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+		}
+
+		return true, nil
+	}
+
+	params, salt, key, err := decodeHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	derived, err := params.derive(data, salt)
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare(derived, key) == 1, nil
+}
+
+// NeedsRehash reports whether the hash was computed with another algorithm or other parameters than the hasher's,
+// the data should then be hashed again once it has been compared, such as on the next login.
+func (h *Hasher) NeedsRehash(hash string) (bool, error) {
+	if isBcrypt(hash) {
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+		}
+
+		return h.params.Algorithm != HashBcrypt || cost != h.params.BcryptCost, nil
+	}
+
+	params, _, key, err := decodeHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	if params.Algorithm != h.params.Algorithm || uint32(len(key)) != h.params.KeyLength {
+		return true, nil
+	}
+
+	switch params.Algorithm {
+	case HashArgon2id:
+		return params.Argon2Memory != h.params.Argon2Memory || params.Argon2Iterations != h.params.Argon2Iterations ||
+			params.Argon2Parallelism != h.params.Argon2Parallelism, nil
+	default:
+		return params.ScryptLogN != h.params.ScryptLogN || params.ScryptR != h.params.ScryptR || params.ScryptP != h.params.ScryptP, nil
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// derive derives the key of the data with argon2id or scrypt.
+func (p HashParams) derive(data string, salt []byte) ([]byte, error) {
+	if p.Algorithm == HashArgon2id {
+		return argon2.IDKey([]byte(data), salt, p.Argon2Iterations, p.Argon2Memory, p.Argon2Parallelism, p.KeyLength), nil
+	}
+
+	return scrypt.Key([]byte(data), salt, 1<<p.ScryptLogN, p.ScryptR, p.ScryptP, int(p.KeyLength))
+}
+
+// encode formats the salt and key as a PHC string.
+func (p HashParams) encode(salt []byte, key []byte) string {
+	var params string
+	if p.Algorithm == HashArgon2id {
+		params = fmt.Sprintf("v=%d$m=%d,t=%d,p=%d", argon2.Version, p.Argon2Memory, p.Argon2Iterations, p.Argon2Parallelism)
+	} else {
+		params = fmt.Sprintf("ln=%d,r=%d,p=%d", p.ScryptLogN, p.ScryptR, p.ScryptP)
+	}
+
+	return "$" + p.Algorithm + "$" + params + "$" + base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(key)
+}
+
+// decodeHash parses an argon2id or scrypt PHC string.
+func decodeHash(hash string) (params HashParams, salt []byte, key []byte, err error) {
+	if len(hash) > maxHashLength {
+		return params, nil, nil, fmt.Errorf("%w: hash too long", ErrMalformedHash)
+	}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) < 2 || parts[0] != "" {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	params.Algorithm = parts[1]
+	switch params.Algorithm {
+	case HashArgon2id:
+		if len(parts) != 6 {
+			return params, nil, nil, ErrMalformedHash
+		}
+
+		if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+			return params, nil, nil, fmt.Errorf("%w: unsupported argon2 version %q", ErrMalformedHash, parts[2])
+		}
+
+		values, err := parseHashParams(parts[3], "m", "t", "p")
+		if err != nil {
+			return params, nil, nil, err
+		}
+
+		if values[2] > maxArgon2Parallelism {
+			return params, nil, nil, fmt.Errorf("%w: argon2 parallelism exceeds %d", ErrMalformedHash, maxArgon2Parallelism)
+		}
+
+		params.Argon2Memory, params.Argon2Iterations, params.Argon2Parallelism = uint32(values[0]), uint32(values[1]), uint8(values[2])
+		parts = parts[4:]
+	case HashScrypt:
+		if len(parts) != 5 {
+			return params, nil, nil, ErrMalformedHash
+		}
+
+		values, err := parseHashParams(parts[2], "ln", "r", "p")
+		if err != nil {
+			return params, nil, nil, err
+		}
+
+		// the shift of N would overflow
+		if values[0] >= 32 {
+			return params, nil, nil, fmt.Errorf("%w: scrypt log N exceeds 31", ErrMalformedHash)
+		}
+
+		params.ScryptLogN, params.ScryptR, params.ScryptP = uint8(values[0]), int(values[1]), int(values[2])
+		parts = parts[3:]
+	default:
+		return params, nil, nil, fmt.Errorf("%w %q", ErrUnknownHash, params.Algorithm)
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+
+	params.SaltLength, params.KeyLength = uint32(len(salt)), uint32(len(key))
+
+	err = params.validate()
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+
+	return params, salt, key, nil
+}
+
+// parseHashParams parses the comma separated <name>=<value> parameters of a PHC string, in the order of the names.
+func parseHashParams(s string, names ...string) ([]uint64, error) {
+	pairs := strings.Split(s, ",")
+	if len(pairs) != len(names) {
+		return nil, fmt.Errorf("%w: expected parameters %s", ErrMalformedHash, strings.Join(names, ","))
+	}
+
+	values := make([]uint64, len(names))
+	for i, pair := range pairs {
+		name, value, _ := strings.Cut(pair, "=")
+		if name != names[i] {
+			return nil, fmt.Errorf("%w: expected parameters %s", ErrMalformedHash, strings.Join(names, ","))
+		}
+
+		var err error
+		values[i], err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: parameter %s: %w", ErrMalformedHash, name, err)
+		}
+	}
+
+	return values, nil
+}
+
+func (p HashParams) validate() error {
+	switch p.Algorithm {
+	case HashArgon2id:
+		if p.Argon2Memory < 8*uint32(p.Argon2Parallelism) || p.Argon2Memory > maxArgon2Memory {
+			return fmt.Errorf("%w: argon2id memory must be between 8 KiB per lane and %d KiB", ErrInvalidHashParams, maxArgon2Memory)
+		}
+
+		if p.Argon2Iterations < 1 || p.Argon2Iterations > maxArgon2Iterations || p.Argon2Parallelism < 1 || p.Argon2Parallelism > maxArgon2Parallelism {
+			return fmt.Errorf("%w: argon2id iterations must be between 1 and %d, and parallelism between 1 and %d", ErrInvalidHashParams,
+				maxArgon2Iterations, maxArgon2Parallelism)
+		}
+	case HashScrypt:
+		if p.ScryptLogN < 1 || p.ScryptLogN >= 32 || p.ScryptR < 1 || p.ScryptR > maxScryptR || p.ScryptP < 1 || p.ScryptP > maxScryptP {
+			return fmt.Errorf("%w: scrypt log N must be between 1 and 31, r between 1 and %d and p between 1 and %d", ErrInvalidHashParams,
+				maxScryptR, maxScryptP)
+		}
+
+		if 128*uint64(p.ScryptR)<<p.ScryptLogN > maxScryptMemory {
+			return fmt.Errorf("%w: scrypt memory of 128 * r * N bytes exceeds %d MiB", ErrInvalidHashParams, maxScryptMemory>>20)
+		}
+	case HashBcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > maxBcryptCost {
+			return fmt.Errorf("%w: bcrypt cost must be between %d and %d", ErrInvalidHashParams, bcrypt.MinCost, maxBcryptCost)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w %q, expected %s, %s or %s", ErrUnknownHash, p.Algorithm, HashArgon2id, HashBcrypt, HashScrypt)
+	}
+
+	if p.SaltLength < 8 || p.KeyLength < 16 || p.KeyLength > 1024 {
+		return fmt.Errorf("%w: salt of at least 8 bytes and key of 16 to 1024 bytes expected", ErrInvalidHashParams)
+	}
+
+	return nil
+}
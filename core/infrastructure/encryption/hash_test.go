@@ -0,0 +1,147 @@
+package encryption_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/core/infrastructure/encryption"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastHashParams are cheap parameters, so the tests do not spend their time hashing.
+func fastHashParams(algorithm string) encryption.HashParams {
+	params := encryption.DefaultHashParams()
+	params.Algorithm = algorithm
+	params.Argon2Memory = 64
+	params.Argon2Iterations = 1
+	params.BcryptCost = bcrypt.MinCost
+	params.ScryptLogN = 4
+
+	return params
+}
+
+func TestHasher(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{algorithm: encryption.HashArgon2id, prefix: "$argon2id$v=19$m=64,t=1,p=1$"},
+		{algorithm: encryption.HashScrypt, prefix: "$scrypt$ln=4,r=8,p=1$"},
+		{algorithm: encryption.HashBcrypt, prefix: "$2a$04$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			hasher, err := encryption.NewHasher(fastHashParams(tt.algorithm))
+			require.NoError(t, err)
+
+			hash, err := hasher.Hash("correct horse battery staple")
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(hash, tt.prefix), hash)
+
+			ok, err := hasher.CompareHash("correct horse battery staple", hash)
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = hasher.CompareHash("correct horse battery", hash)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			needsRehash, err := hasher.NeedsRehash(hash)
+			require.NoError(t, err)
+			assert.False(t, needsRehash)
+		})
+	}
+}
+
+func TestHasher_Migration(t *testing.T) {
+	bcryptHasher, err := encryption.NewHasher(fastHashParams(encryption.HashBcrypt))
+	require.NoError(t, err)
+	argon2Hasher, err := encryption.NewHasher(fastHashParams(encryption.HashArgon2id))
+	require.NoError(t, err)
+
+	stronger := fastHashParams(encryption.HashArgon2id)
+	stronger.Argon2Iterations = 2
+	strongerHasher, err := encryption.NewHasher(stronger)
+	require.NoError(t, err)
+
+	bcryptHash, err := bcryptHasher.Hash("secret")
+	require.NoError(t, err)
+	argon2Hash, err := argon2Hasher.Hash("secret")
+	require.NoError(t, err)
+
+	// hashes of another algorithm are still compared, and need to be hashed again
+	ok, err := argon2Hasher.CompareHash("secret", bcryptHash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	needsRehash, err := argon2Hasher.NeedsRehash(bcryptHash)
+	require.NoError(t, err)
+	assert.True(t, needsRehash)
+
+	// as do hashes of weaker parameters
+	ok, err = strongerHasher.CompareHash("secret", argon2Hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	needsRehash, err = strongerHasher.NeedsRehash(argon2Hash)
+	require.NoError(t, err)
+	assert.True(t, needsRehash)
+}
+
+func TestHasher_Invalid(t *testing.T) {
+	hasher, err := encryption.NewHasher(fastHashParams(encryption.HashBcrypt))
+	require.NoError(t, err)
+
+	// bcrypt would ignore anything after 72 bytes
+	_, err = hasher.Hash(strings.Repeat("a", 73))
+	assert.ErrorIs(t, err, encryption.ErrHashInputTooLong)
+
+	hash, err := hasher.Hash(strings.Repeat("a", 72))
+	require.NoError(t, err)
+	_, err = hasher.CompareHash(strings.Repeat("a", 73), hash)
+	assert.ErrorIs(t, err, encryption.ErrHashInputTooLong)
+
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$md5$abc$def",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=99999999,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$scrypt$ln=40,r=8,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		// forged parameters which would exhaust the memory or the cpu
+		"$argon2id$v=19$m=2097152,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=65536,t=64,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$argon2id$v=19$m=65536,t=1,p=255$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$scrypt$ln=21,r=8,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$scrypt$ln=14,r=64,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$scrypt$ln=14,r=8,p=64$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5",
+		"$scrypt$ln=4,r=8,p=1$not base64$a2V5a2V5a2V5a2V5a2V5a2V5",
+	} {
+		_, err = hasher.CompareHash("secret", hash)
+		assert.Error(t, err, hash)
+
+		_, err = hasher.NeedsRehash(hash)
+		assert.Error(t, err, hash)
+	}
+
+	// a forged bcrypt cost is refused before hashing
+	_, err = hasher.CompareHash("secret", "$2a$31$"+strings.Repeat("a", 53))
+	assert.ErrorIs(t, err, encryption.ErrMalformedHash)
+
+	for _, params := range []encryption.HashParams{
+		{Algorithm: "md5"},
+		{Algorithm: encryption.HashBcrypt, BcryptCost: 1},
+		{Algorithm: encryption.HashArgon2id},
+		{Algorithm: encryption.HashScrypt, ScryptLogN: 17, ScryptR: 8, ScryptP: 1},
+		{Algorithm: encryption.HashScrypt, ScryptLogN: 20, ScryptR: 8, ScryptP: 1, SaltLength: 16, KeyLength: 32},
+		{Algorithm: encryption.HashArgon2id, Argon2Memory: 2 << 20, Argon2Iterations: 1, Argon2Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		{Algorithm: encryption.HashBcrypt, BcryptCost: 20},
+	} {
+		_, err = encryption.NewHasher(params)
+		assert.Error(t, err, params.Algorithm)
+	}
+}
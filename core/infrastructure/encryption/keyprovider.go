@@ -0,0 +1,128 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Types of KeyProviderConfig.
+const (
+	KeyProviderEnv      = "env"
+	KeyProviderFile     = "file"
+	KeyProviderKeystore = "keystore"
+	KeyProviderVault    = "vault"
+)
+
+// KeyProviderConfig selects and configures the key provider of the envelope encryption.
+type KeyProviderConfig struct {
+	// Type of the provider: env, file, keystore or vault
+	Type string
+	// MasterKeyVariable is the environment variable the env provider reads the master key from
+	MasterKeyVariable string
+	// MasterKeyFile is the file the file provider reads the master key from
+	MasterKeyFile string
+	// KeystorePath is the file of the keystore, see CreateKeystore
+	KeystorePath string
+	// Vault is the transit secrets engine of the vault provider
+	VaultAddress string
+	VaultToken   string
+	VaultMount   string
+	VaultKey     string
+}
+
+// NewKeyProvider creates the key provider of the config.
+func NewKeyProvider(config *KeyProviderConfig) (KeyProvider, error) {
+	switch config.Type {
+	case KeyProviderEnv:
+		return NewEnvKeyProvider(config.MasterKeyVariable)
+	case KeyProviderFile:
+		return NewFileKeyProvider(config.MasterKeyFile)
+	case KeyProviderKeystore:
+		return OpenKeystore(config.KeystorePath)
+	case KeyProviderVault:
+		return NewVaultTransit(config.VaultAddress, config.VaultToken, config.VaultKey, WithVaultMount(config.VaultMount))
+	default:
+		return nil, fmt.Errorf("unknown key provider %q, expected %s, %s, %s or %s", config.Type, KeyProviderEnv, KeyProviderFile,
+			KeyProviderKeystore, KeyProviderVault)
+	}
+}
+
+// StaticKeyProvider wraps the data keys with a single AES-256-GCM master key.
+type StaticKeyProvider struct {
+	master *Encryption
+}
+
+// NewStaticKeyProvider creates a provider wrapping the data keys with the master key, of 32 bytes.
+func NewStaticKeyProvider(masterKey []byte) (*StaticKeyProvider, error) {
+	if len(masterKey) != dataKeySize {
+		return nil, fmt.Errorf("%w: master key of %d bytes, expected %d", ErrInvalidKey, len(masterKey), dataKeySize)
+	}
+
+	master, err := New(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StaticKeyProvider{master: master}, nil
+}
+
+// NewEnvKeyProvider reads the master key from the environment variable, which is then removed from the environment
+// of the process so it is not inherited nor read again.
+func NewEnvKeyProvider(variable string) (*StaticKeyProvider, error) {
+	masterKey, ok := os.LookupEnv(variable)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not set", ErrInvalidKey, variable)
+	}
+
+	err := os.Unsetenv(variable)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStaticKeyProvider([]byte(masterKey))
+}
+
+// NewFileKeyProvider reads the master key from a file, such as a mounted secret. A trailing newline is ignored.
+func NewFileKeyProvider(path string) (*StaticKeyProvider, error) {
+	masterKey, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+
+	return NewStaticKeyProvider([]byte(strings.TrimRight(string(masterKey), "\r\n")))
+}
+
+func (p *StaticKeyProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return wrapKey(p.master, dataKey)
+}
+
+func (p *StaticKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return unwrapKey(p.master, wrapped)
+}
+
+// wrapKey wraps the data key with a local master key into a versioned ciphertext.
+func wrapKey(master Cipher, dataKey []byte) ([]byte, error) {
+	wrapped, err := master.Encrypt(string(dataKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(wrapped), nil
+}
+
+// unwrapKey unwraps a data key wrapped by wrapKey.
+func unwrapKey(master Cipher, wrapped []byte) ([]byte, error) {
+	// anything else would be decrypted as a legacy ciphertext, which is not authenticated
+	if !IsCiphertext(string(wrapped)) {
+		return nil, fmt.Errorf("%w: wrapped key is not a versioned ciphertext", ErrMalformedCiphertext)
+	}
+
+	dataKey, err := master.Decrypt(string(wrapped))
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(dataKey), nil
+}
@@ -0,0 +1,172 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Key is an encryption key of a keyring.
+type Key struct {
+	// ID is written in the ciphertexts encrypted with the key, it defaults to a fingerprint of the secret.
+	ID     string
+	Secret []byte
+	// Legacy marks the key the unversioned AES-CFB ciphertexts were encrypted with. It defaults to the key of a keyring
+	// holding a single key, with several keys the legacy ciphertexts fail to decrypt unless a key is marked.
+	Legacy bool
+}
+
+// ParseKeys parses comma separated <key id>:<secret> pairs, such as the ENCRYPTION_KEYS environment variable.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for i, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, secret, ok := strings.Cut(pair, separator)
+		if !ok || id == "" || secret == "" {
+			// the pair is not quoted, it may be a secret
+			return nil, fmt.Errorf("invalid encryption key %d, expected <key id>:<secret>", i+1)
+		}
+
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+
+	return keys, nil
+}
+
+// Keyring implements IEncryptionService with several keys addressed by id. It encrypts with the active key and
+// decrypts with the key named by the ciphertext, so keys are rotated without rewriting the existing data first.
+type Keyring struct {
+	active *Encryption
+	keys   map[string]*Encryption
+	legacy *Encryption
+}
+
+// NewKeyring creates a keyring encrypting with the key of activeKeyID, which may be empty when there is a single key.
+// The options, such as WithAlgorithm, apply to every key.
+func NewKeyring(keys []Key, activeKeyID string, options ...Option) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: keyring has no key", ErrInvalidKey)
+	}
+
+	if activeKeyID == "" && len(keys) > 1 {
+		return nil, errors.New("active key id is required when the keyring has several keys")
+	}
+
+	k := &Keyring{keys: make(map[string]*Encryption, len(keys))}
+	for _, key := range keys {
+		e, err := New(key.Secret, append(options[:len(options):len(options)], WithKeyID(key.ID))...)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.ID, err)
+		}
+
+		if _, ok := k.keys[e.KeyID()]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", e.KeyID())
+		}
+		k.keys[e.KeyID()] = e
+
+		if key.Legacy {
+			if k.legacy != nil {
+				return nil, errors.New("only one key can decrypt the legacy ciphertexts")
+			}
+
+			k.legacy = e
+		}
+	}
+
+	k.active = k.keys[activeKeyID]
+	if activeKeyID == "" {
+		// the single key of the keyring
+		for _, e := range k.keys {
+			k.active = e
+		}
+	}
+
+	if k.active == nil {
+		return nil, fmt.Errorf("active key %q is not in the keyring", activeKeyID)
+	}
+
+	// AES-CFB has no authentication, a legacy ciphertext decrypted with another key would turn into garbage
+	if k.legacy == nil && len(k.keys) == 1 {
+		k.legacy = k.active
+	}
+
+	return k, nil
+}
+
+// KeyID returns the id of the active key.
+func (k *Keyring) KeyID() string {
+	return k.active.KeyID()
+}
+
+func (k *Keyring) Encrypt(data string) (string, error) {
+	return k.active.Encrypt(data)
+}
+
+func (k *Keyring) Decrypt(data string) (string, error) {
+	e, err := k.keyOf(data)
+	if err != nil {
+		return "", err
+	}
+
+	return e.Decrypt(data)
+}
+
+// Reencrypt encrypts the data again with the active key and algorithm, it reports false and returns the data
+// unchanged when it is already encrypted with them.
+func (k *Keyring) Reencrypt(data string) (string, bool, error) {
+	if strings.HasPrefix(data, k.active.prefix()) {
+		return data, false, nil
+	}
+
+	plainText, err := k.Decrypt(data)
+	if err != nil {
+		return "", false, err
+	}
+
+	reencrypted, err := k.active.Encrypt(plainText)
+	if err != nil {
+		return "", false, err
+	}
+
+	return reencrypted, true, nil
+}
+
+func (k *Keyring) Hash(data string) (string, error) {
+	return k.active.Hash(data)
+}
+
+func (k *Keyring) CompareHash(data, hash string) (bool, error) {
+	return k.active.CompareHash(data, hash)
+}
+
+func (k *Keyring) NeedsRehash(hash string) (bool, error) {
+	return k.active.NeedsRehash(hash)
+}
+
+// keyOf returns the key the data was encrypted with.
+func (k *Keyring) keyOf(data string) (*Encryption, error) {
+	_, rest, versioned := strings.Cut(data, separator)
+	if !versioned {
+		if k.legacy == nil {
+			return nil, ErrNoLegacyKey
+		}
+
+		return k.legacy, nil
+	}
+
+	keyID, _, ok := strings.Cut(rest, separator)
+	if !ok {
+		return nil, ErrMalformedCiphertext
+	}
+
+	e, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+
+	return e, nil
+}
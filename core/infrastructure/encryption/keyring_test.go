@@ -0,0 +1,108 @@
+package encryption_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/core/infrastructure/encryption"
+)
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey := encryption.Key{ID: "2024", Secret: []byte("abcdefghabcdefghabcdefghabcdefgh"), Legacy: true}
+	newKey := encryption.Key{ID: "2025", Secret: []byte("hgfedcbahgfedcbahgfedcbahgfedcba")}
+
+	before, err := encryption.NewKeyring([]encryption.Key{oldKey}, "")
+	require.NoError(t, err)
+
+	old, err := before.Encrypt("ketan.rathod@bacancy.com")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(old, "v1:2024:"), old)
+
+	after, err := encryption.NewKeyring([]encryption.Key{oldKey, newKey}, "2025")
+	require.NoError(t, err)
+	assert.Equal(t, "2025", after.KeyID())
+
+	tests := []struct {
+		name            string
+		data            string
+		expectedChanged bool
+	}{
+		{name: "former key", data: old, expectedChanged: true},
+		{name: "legacy aes-cfb", data: encryptLegacy(t, oldKey.Secret, "ketan.rathod@bacancy.com"), expectedChanged: true},
+		{name: "active key", data: mustEncrypt(t, after, "ketan.rathod@bacancy.com"), expectedChanged: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypted, err := after.Decrypt(tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, "ketan.rathod@bacancy.com", decrypted)
+
+			reencrypted, changed, err := after.Reencrypt(tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedChanged, changed)
+			assert.True(t, strings.HasPrefix(reencrypted, "v1:2025:"), reencrypted)
+
+			decrypted, err = after.Decrypt(reencrypted)
+			require.NoError(t, err)
+			assert.Equal(t, "ketan.rathod@bacancy.com", decrypted)
+		})
+	}
+
+	// once the former key is removed, its ciphertexts are no longer decrypted
+	retired, err := encryption.NewKeyring([]encryption.Key{newKey}, "")
+	require.NoError(t, err)
+	_, err = retired.Decrypt(old)
+	assert.ErrorIs(t, err, encryption.ErrUnknownKey)
+
+	// with several keys, the legacy ciphertexts are not decrypted with a key which was not marked
+	unmarked, err := encryption.NewKeyring([]encryption.Key{{ID: oldKey.ID, Secret: oldKey.Secret}, newKey}, "2025")
+	require.NoError(t, err)
+	_, err = unmarked.Decrypt(encryptLegacy(t, oldKey.Secret, "ketan.rathod@bacancy.com"))
+	assert.ErrorIs(t, err, encryption.ErrNoLegacyKey)
+}
+
+func TestNewKeyring_Invalid(t *testing.T) {
+	key := encryption.Key{ID: "a", Secret: []byte("abcdefghabcdefghabcdefghabcdefgh")}
+	other := encryption.Key{ID: "b", Secret: []byte("hgfedcbahgfedcbahgfedcbahgfedcba")}
+
+	tests := []struct {
+		name   string
+		keys   []encryption.Key
+		active string
+	}{
+		{name: "no key"},
+		{name: "several keys without an active one", keys: []encryption.Key{key, other}},
+		{name: "unknown active key", keys: []encryption.Key{key, other}, active: "c"},
+		{name: "duplicate key id", keys: []encryption.Key{key, key}, active: "a"},
+		{name: "invalid key", keys: []encryption.Key{{ID: "a", Secret: []byte("short")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := encryption.NewKeyring(tt.keys, tt.active)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := encryption.ParseKeys("2024:abcdefghabcdefgh, 2025:hgfedcbahgfedcba")
+	require.NoError(t, err)
+	assert.Equal(t, []encryption.Key{
+		{ID: "2024", Secret: []byte("abcdefghabcdefgh")},
+		{ID: "2025", Secret: []byte("hgfedcbahgfedcba")},
+	}, keys)
+
+	_, err = encryption.ParseKeys("abcdefghabcdefgh")
+	assert.Error(t, err)
+}
+
+func mustEncrypt(t *testing.T, em *encryption.Keyring, data string) string {
+	encrypted, err := em.Encrypt(data)
+	require.NoError(t, err)
+
+	return encrypted
+}
@@ -0,0 +1,186 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKeystoreNotFound = errors.New("keystore does not exist")
+	ErrKeystoreExists   = errors.New("keystore already exists")
+)
+
+// keystoreFile is the content of the keystore file.
+type keystoreFile struct {
+	ActiveKeyID string        `json:"active_key_id"`
+	Keys        []keystoreKey `json:"keys"`
+}
+
+type keystoreKey struct {
+	ID        string    `json:"id"`
+	Secret    []byte    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Keystore is a local on-disk keystore of master keys, it wraps the data keys with the active one and unwraps them
+// with the one they were wrapped with. The file is only readable by its owner, the keys never sit in the environment.
+type Keystore struct {
+	path string
+
+	mu      sync.RWMutex
+	file    keystoreFile
+	keyring *Keyring
+}
+
+// CreateKeystore creates the keystore of the file with a generated master key, it refuses to overwrite an existing one.
+func CreateKeystore(path string) (*Keystore, error) {
+	if path == "" {
+		return nil, errors.New("keystore path is required")
+	}
+
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil, fmt.Errorf("%w at %s", ErrKeystoreExists, path)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	k := &Keystore{path: path}
+	_, err = k.Rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// OpenKeystore opens the keystore of the file, created by CreateKeystore. A missing keystore is not created, the values
+// encrypted with the master keys of a keystore which went missing would no longer decrypt.
+// A keystore accessible by other users is refused.
+func OpenKeystore(path string) (*Keystore, error) {
+	if path == "" {
+		return nil, errors.New("keystore path is required")
+	}
+
+	k := &Keystore{path: path}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w at %s", ErrKeystoreNotFound, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("keystore %s is accessible by other users, expected mode 0600", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &k.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %w", err)
+	}
+
+	k.keyring, err = k.file.keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// KeyID returns the id of the active master key.
+func (k *Keystore) KeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.file.ActiveKeyID
+}
+
+// Rotate generates a new master key and makes it active, the former keys keep unwrapping the data keys they wrapped.
+// It returns the id of the new key.
+func (k *Keystore) Rotate() (string, error) {
+	secret := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	file := keystoreFile{
+		ActiveKeyID: Fingerprint(secret),
+		Keys:        append(k.file.Keys[:len(k.file.Keys):len(k.file.Keys)], keystoreKey{ID: Fingerprint(secret), Secret: secret, CreatedAt: time.Now()}),
+	}
+
+	keyring, err := file.keyring()
+	if err != nil {
+		return "", err
+	}
+
+	err = file.save(k.path)
+	if err != nil {
+		return "", err
+	}
+
+	k.file, k.keyring = file, keyring
+
+	return file.ActiveKeyID, nil
+}
+
+func (k *Keystore) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return wrapKey(k.keyring, dataKey)
+}
+
+func (k *Keystore) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return unwrapKey(k.keyring, wrapped)
+}
+
+func (f keystoreFile) keyring() (*Keyring, error) {
+	keys := make([]Key, len(f.Keys))
+	for i, key := range f.Keys {
+		keys[i] = Key{ID: key.ID, Secret: key.Secret}
+	}
+
+	keyring, err := NewKeyring(keys, f.ActiveKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keystore: %w", err)
+	}
+
+	return keyring, nil
+}
+
+// save writes the keystore atomically and only readable by its owner.
+func (f keystoreFile) save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write keystore: %w", err)
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,58 @@
+package encryption_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/core/infrastructure/encryption"
+)
+
+func TestKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	dataKey := []byte("datakeydatakeydatakeydatakeydata")
+
+	// a missing keystore is not created on open
+	_, err := encryption.OpenKeystore(path)
+	assert.ErrorIs(t, err, encryption.ErrKeystoreNotFound)
+	assert.NoFileExists(t, path)
+
+	// created explicitly, only readable by its owner
+	keystore, err := encryption.CreateKeystore(path)
+	require.NoError(t, err)
+
+	_, err = encryption.CreateKeystore(path)
+	assert.ErrorIs(t, err, encryption.ErrKeystoreExists)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	wrapped, err := keystore.WrapKey(context.Background(), dataKey)
+	require.NoError(t, err)
+
+	firstKeyID := keystore.KeyID()
+	rotatedKeyID, err := keystore.Rotate()
+	require.NoError(t, err)
+	assert.NotEqual(t, firstKeyID, rotatedKeyID)
+
+	// reopened, the keystore wraps with the rotated key and still unwraps with the former one
+	reopened, err := encryption.OpenKeystore(path)
+	require.NoError(t, err)
+	assert.Equal(t, rotatedKeyID, reopened.KeyID())
+
+	unwrapped, err := reopened.UnwrapKey(context.Background(), wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	rewrapped, err := reopened.WrapKey(context.Background(), dataKey)
+	require.NoError(t, err)
+	assert.Contains(t, string(rewrapped), ":"+rotatedKeyID+":")
+
+	require.NoError(t, os.Chmod(path, 0644))
+	_, err = encryption.OpenKeystore(path)
+	assert.ErrorContains(t, err, "accessible by other users")
+}
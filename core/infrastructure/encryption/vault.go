@@ -0,0 +1,155 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultVaultMount   = "transit"
+	defaultVaultTimeout = 10 * time.Second
+)
+
+var (
+	ErrVaultRequest = errors.New("vault transit request failed")
+)
+
+// VaultOption configures the vault transit provider.
+type VaultOption func(*VaultTransit)
+
+// WithVaultMount sets the path the transit secrets engine is mounted at, transit by default.
+func WithVaultMount(mount string) VaultOption {
+	return func(v *VaultTransit) {
+		v.mount = mount
+	}
+}
+
+// WithVaultHTTPClient sets the client of the requests to vault, with a timeout of 10 seconds by default.
+func WithVaultHTTPClient(client *http.Client) VaultOption {
+	return func(v *VaultTransit) {
+		v.client = client
+	}
+}
+
+// VaultTransit wraps the data keys with a key of the HashiCorp Vault transit secrets engine, the master key never
+// leaves vault. Any server implementing the encrypt and decrypt endpoints of the transit API can be used.
+type VaultTransit struct {
+	address string
+	token   string
+	mount   string
+	key     string
+	client  *http.Client
+}
+
+// NewVaultTransit creates the provider of the transit key named key, on the vault server at address.
+func NewVaultTransit(address, token, key string, options ...VaultOption) (*VaultTransit, error) {
+	if address == "" || key == "" {
+		return nil, errors.New("vault address and transit key are required")
+	}
+
+	if token == "" {
+		return nil, errors.New("vault token is required")
+	}
+
+	v := &VaultTransit{address: strings.TrimRight(address, "/"), token: token, key: key}
+	for _, option := range options {
+		option(v)
+	}
+
+	if v.mount == "" {
+		v.mount = defaultVaultMount
+	}
+
+	if v.client == nil {
+		v.client = &http.Client{Timeout: defaultVaultTimeout}
+	}
+
+	return v, nil
+}
+
+func (v *VaultTransit) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var response struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+
+	err := v.do(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Data.Ciphertext == "" {
+		return nil, fmt.Errorf("%w: encrypt returned no ciphertext", ErrVaultRequest)
+	}
+
+	return []byte(response.Data.Ciphertext), nil
+}
+
+func (v *VaultTransit) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var response struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+
+	err := v.do(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := base64.StdEncoding.DecodeString(response.Data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decrypt returned an invalid plaintext", ErrVaultRequest)
+	}
+
+	return dataKey, nil
+}
+
+// do sends the request to the operation of the transit key and decodes the response.
+func (v *VaultTransit) do(ctx context.Context, operation string, request any, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	endpoint := v.address + "/v1/" + v.mount + "/" + operation + "/" + url.PathEscape(v.key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrVaultRequest, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&vaultErr)
+
+		return fmt.Errorf("%w: %s returned status %d: %s", ErrVaultRequest, operation, resp.StatusCode, strings.Join(vaultErr.Errors, ", "))
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decode %s response: %w", ErrVaultRequest, operation, err)
+	}
+
+	return nil
+}
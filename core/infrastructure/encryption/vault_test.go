@@ -0,0 +1,89 @@
+package encryption_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/core/infrastructure/encryption"
+)
+
+// newTransitStub serves the encrypt and decrypt endpoints of the vault transit API for the users key, mounted at
+// secret-transit.
+func newTransitStub(t *testing.T) *httptest.Server {
+	master, err := encryption.New([]byte("masterkeymasterkeymasterkeymaste"), encryption.WithKeyID("users"))
+	require.NoError(t, err)
+
+	respond := func(w http.ResponseWriter, status int, body any) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/secret-transit/{operation}/users", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root-token" {
+			respond(w, http.StatusForbidden, map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+
+		var request map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		switch r.PathValue("operation") {
+		case "encrypt":
+			plainText, err := base64.StdEncoding.DecodeString(request["plaintext"])
+			require.NoError(t, err)
+			ciphertext, err := master.Encrypt(string(plainText))
+			require.NoError(t, err)
+
+			respond(w, http.StatusOK, map[string]any{"data": map[string]string{"ciphertext": "vault:" + ciphertext}})
+		case "decrypt":
+			plainText, err := master.Decrypt(strings.TrimPrefix(request["ciphertext"], "vault:"))
+			if err != nil {
+				respond(w, http.StatusBadRequest, map[string][]string{"errors": {"cipher: message authentication failed"}})
+				return
+			}
+
+			respond(w, http.StatusOK, map[string]any{"data": map[string]string{"plaintext": base64.StdEncoding.EncodeToString([]byte(plainText))}})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestVaultTransit(t *testing.T) {
+	server := newTransitStub(t)
+
+	vault, err := encryption.NewVaultTransit(server.URL+"/", "root-token", "users", encryption.WithVaultMount("secret-transit"))
+	require.NoError(t, err)
+
+	e := encryption.NewEnvelope(vault)
+	ciphertext, err := e.Encrypt("john@example.com")
+	require.NoError(t, err)
+
+	plainText, err := encryption.NewEnvelope(vault).Decrypt(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", plainText)
+
+	_, err = vault.UnwrapKey(context.Background(), []byte("vault:v1:users:AAAA"))
+	assert.ErrorIs(t, err, encryption.ErrVaultRequest)
+	assert.ErrorContains(t, err, "message authentication failed")
+
+	denied, err := encryption.NewVaultTransit(server.URL, "wrong-token", "users", encryption.WithVaultMount("secret-transit"))
+	require.NoError(t, err)
+
+	_, err = encryption.NewEnvelope(denied).Encrypt("john@example.com")
+	assert.ErrorIs(t, err, encryption.ErrVaultRequest)
+	assert.ErrorContains(t, err, "status 403")
+}
@@ -0,0 +1,38 @@
+package postgres
+
+import (
+	"errors"
+	"strings"
+)
+
+// integrityConstraintViolationClass is the SQLSTATE class of unique, foreign key, not null and check violations.
+const integrityConstraintViolationClass = "23"
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation.
+const uniqueViolation = "23505"
+
+// sqlStateError is implemented by the errors of both lib/pq and pgx drivers.
+type sqlStateError interface {
+	SQLState() string
+}
+
+// IsConstraintViolation reports whether the error was caused by an integrity constraint violation,
+// such errors will fail the same way no matter how many times the query is retried.
+func IsConstraintViolation(err error) bool {
+	var stateErr sqlStateError
+	if !errors.As(err, &stateErr) {
+		return false
+	}
+
+	return strings.HasPrefix(stateErr.SQLState(), integrityConstraintViolationClass)
+}
+
+// IsUniqueViolation reports whether the error was caused by a row conflicting with an existing one on a unique constraint.
+func IsUniqueViolation(err error) bool {
+	var stateErr sqlStateError
+	if !errors.As(err, &stateErr) {
+		return false
+	}
+
+	return stateErr.SQLState() == uniqueViolation
+}
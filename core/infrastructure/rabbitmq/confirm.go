@@ -0,0 +1,223 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrConfirmsDisabled = errors.New("publisher confirms are not enabled")
+	ErrNacked           = errors.New("message was not acknowledged by the broker")
+	ErrReturned         = errors.New("message was returned by the broker as unroutable")
+)
+
+// WithConfirms puts the channel in confirm mode, which is required by PublishWithConfirm.
+func WithConfirms() Option {
+	return func(rm *RabbitMQ) {
+		rm.confirms = true
+		rm.pendingReturns = make(map[string]chan struct{})
+	}
+}
+
+// PublishWithConfirm publishes a message and waits until the broker confirms it.
+// It returns ErrNacked if the broker could not take responsibility for the message and
+// ErrReturned if a mandatory message could not be routed to any queue.
+func (rmq *RabbitMQ) PublishWithConfirm(ctx context.Context, options ...PublishOption) error {
+	if !rmq.confirms {
+		return ErrConfirmsDisabled
+	}
+
+	opts := newPublishOptions(options...)
+
+	// returned messages are matched to their publishing by message id
+	if opts.MessageId == "" {
+		messageId, err := newMessageId()
+		if err != nil {
+			return err
+		}
+
+		opts.MessageId = messageId
+	}
+
+	returned := rmq.registerReturn(opts.MessageId)
+	defer rmq.unregisterReturn(opts.MessageId)
+
+	ch, listener, err := rmq.waitForConfirmChannel(ctx)
+	if err != nil {
+		return err
+	}
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"", // exchange
+		opts.RoutingKey,
+		opts.Mandatory,
+		opts.Immediate,
+		opts.publishing(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// pending confirmations are nacked when the channel is closed
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !acked {
+		return ErrNacked
+	}
+
+	// the deferred confirmation is resolved before the listener sees the confirm, wait for it so the return of the
+	// message, sent by the broker before its basic.ack, has been handled
+	err = listener.wait(ctx, confirmation.DeliveryTag)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-returned:
+		return ErrReturned
+	default:
+	}
+
+	return nil
+}
+
+// confirmListener tracks the confirms of a channel received by listenConfirms.
+type confirmListener struct {
+	mu sync.Mutex
+	// confirmed is the delivery tag of the last confirm received, they are received in order
+	confirmed uint64
+	// progress is closed and replaced when confirmed grows or the channel is closed
+	progress chan struct{}
+	closed   bool
+}
+
+func newConfirmListener() *confirmListener {
+	return &confirmListener{progress: make(chan struct{})}
+}
+
+// wait waits until the confirm of the delivery tag has been received.
+func (l *confirmListener) wait(ctx context.Context, deliveryTag uint64) error {
+	for {
+		l.mu.Lock()
+		confirmed, closed, progress := l.confirmed, l.closed, l.progress
+		l.mu.Unlock()
+
+		if confirmed >= deliveryTag {
+			return nil
+		}
+
+		if closed {
+			return amqp.ErrClosed
+		}
+
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *confirmListener) update(confirmed uint64, closed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.confirmed = max(l.confirmed, confirmed)
+	l.closed = l.closed || closed
+	close(l.progress)
+	l.progress = make(chan struct{})
+}
+
+// listenConfirms marks the returned messages and records the confirms of a channel until it is closed. Both are
+// handled by this single goroutine and the returns channel is unbuffered: the client only dispatches the basic.ack of
+// a message once its basic.return has been received here, so it is marked before its confirm is recorded.
+func (rmq *RabbitMQ) listenConfirms(listener *confirmListener, returns <-chan amqp.Return, confirms <-chan amqp.Confirmation) {
+	defer listener.update(0, true)
+
+	for returns != nil || confirms != nil {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+
+			rmq.markReturned(ret)
+
+		case confirmation, ok := <-confirms:
+			if !ok {
+				confirms = nil
+				continue
+			}
+
+			listener.update(confirmation.DeliveryTag, false)
+		}
+	}
+}
+
+func (rmq *RabbitMQ) markReturned(ret amqp.Return) {
+	rmq.returnsMu.Lock()
+	if returned, ok := rmq.pendingReturns[ret.MessageId]; ok {
+		close(returned)
+		delete(rmq.pendingReturns, ret.MessageId)
+	}
+	rmq.returnsMu.Unlock()
+
+	if rmq.logger != nil {
+		rmq.logger.Warn("message returned by broker", zap.String("routingKey", ret.RoutingKey), zap.String("reason", ret.ReplyText))
+	}
+}
+
+// waitForConfirmChannel waits for the channel like waitForChannel, along with the listener of its confirms.
+func (rmq *RabbitMQ) waitForConfirmChannel(ctx context.Context) (*amqp.Channel, *confirmListener, error) {
+	for {
+		ch, err := rmq.waitForChannel(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rmq.mu.RLock()
+		current, listener := rmq.channel, rmq.confirmListener
+		rmq.mu.RUnlock()
+
+		// reconnected in between, wait for the new channel
+		if current == ch {
+			return ch, listener, nil
+		}
+	}
+}
+
+func (rmq *RabbitMQ) registerReturn(messageId string) <-chan struct{} {
+	returned := make(chan struct{})
+
+	rmq.returnsMu.Lock()
+	rmq.pendingReturns[messageId] = returned
+	rmq.returnsMu.Unlock()
+
+	return returned
+}
+
+func (rmq *RabbitMQ) unregisterReturn(messageId string) {
+	rmq.returnsMu.Lock()
+	delete(rmq.pendingReturns, messageId)
+	rmq.returnsMu.Unlock()
+}
+
+func newMessageId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
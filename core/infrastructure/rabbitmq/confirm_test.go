@@ -0,0 +1,45 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenConfirms(t *testing.T) {
+	rmq := &RabbitMQ{}
+	WithConfirms()(rmq)
+
+	returns := make(chan amqp.Return)
+	confirms := make(chan amqp.Confirmation, 1)
+	listener := newConfirmListener()
+	go rmq.listenConfirms(listener, returns, confirms)
+
+	returned := rmq.registerReturn("unroutable")
+	delivered := rmq.registerReturn("routed")
+
+	// the order the client dispatches them in, the return of a message before its confirm
+	returns <- amqp.Return{MessageId: "unroutable"}
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+
+	require.NoError(t, listener.wait(context.Background(), 2))
+	assert.True(t, isClosed(returned))
+	assert.False(t, isClosed(delivered))
+
+	close(returns)
+	close(confirms)
+	assert.ErrorIs(t, listener.wait(context.Background(), 3), amqp.ErrClosed)
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,5 +1,10 @@
 package rabbitmq
 
+import (
+	"context"
+	"time"
+)
+
 type QueueOptions struct {
 	Durable    bool
 	AutoDelete bool
@@ -40,12 +45,42 @@ func WithArguments(arguments map[string]interface{}) QueueOption {
 	}
 }
 
+// WithMessageTTL sets the time a message can stay in the queue before it expires.
+// Expired messages are dead-lettered if the queue has a dead letter exchange, otherwise dropped.
+func WithMessageTTL(ttl time.Duration) QueueOption {
+	return func(o *QueueOptions) {
+		o.setArgument("x-message-ttl", ttl.Milliseconds())
+	}
+}
+
+// WithDeadLetterExchange routes rejected and expired messages to the given exchange using the given routing key.
+// Use an empty exchange to route them directly to the queue named by the routing key.
+func WithDeadLetterExchange(exchange, routingKey string) QueueOption {
+	return func(o *QueueOptions) {
+		o.setArgument("x-dead-letter-exchange", exchange)
+		o.setArgument("x-dead-letter-routing-key", routingKey)
+	}
+}
+
+func (o *QueueOptions) setArgument(key string, value interface{}) {
+	if o.Arguments == nil {
+		o.Arguments = make(map[string]interface{})
+	}
+
+	o.Arguments[key] = value
+}
+
 type PublishOptions struct {
 	ContentType string
 	Body        []byte
 	Mandatory   bool
 	Immediate   bool
 	RoutingKey  string
+	Headers     map[string]interface{}
+	Persistent  bool
+	MessageId   string
+	// TraceContext is propagated in the headers, see WithTraceContext
+	TraceContext context.Context
 }
 
 type PublishOption func(*PublishOptions)
@@ -74,6 +109,26 @@ func WithRoutingKey(routingKey string) PublishOption {
 	}
 }
 
+func WithHeaders(headers map[string]interface{}) PublishOption {
+	return func(o *PublishOptions) {
+		o.Headers = headers
+	}
+}
+
+// WithPersistent marks the message as persistent, so it survives a broker restart when published to a durable queue.
+func WithPersistent(persistent bool) PublishOption {
+	return func(o *PublishOptions) {
+		o.Persistent = persistent
+	}
+}
+
+// WithMessageId sets the message id, it is generated by PublishWithConfirm if not provided.
+func WithMessageId(messageId string) PublishOption {
+	return func(o *PublishOptions) {
+		o.MessageId = messageId
+	}
+}
+
 // ConsumeOptions holds the options for consuming messages
 type ConsumeOptions struct {
 	AutoAck        bool
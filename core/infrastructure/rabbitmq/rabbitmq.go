@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -16,17 +17,60 @@ const (
 	defaultExclusive  = false
 	defaultNoWait     = false
 	defaultQueueName  = "default-queue"
+
+	defaultReconnectDelay    = time.Second
+	defaultMaxReconnectDelay = 30 * time.Second
+)
+
+var (
+	ErrNotConnected = errors.New("rabbitmq is not connected")
+	ErrClosed       = errors.New("rabbitmq connection is closed")
 )
 
 type ILogger interface {
 	Debug(message string, args ...zap.Field)
+	Warn(message string, args ...zap.Field)
 }
 
 // RabbitMQ struct contains the connection, channel.
+// Both are replaced transparently when the connection to the broker is lost and re-established.
 type RabbitMQ struct {
-	Connection *amqp.Connection
-	Channel    *amqp.Channel
-	logger     ILogger
+	url    string
+	logger ILogger
+
+	reconnectDelay    time.Duration
+	maxReconnectDelay time.Duration
+
+	mu         sync.RWMutex
+	connection *amqp.Connection
+	channel    *amqp.Channel
+	connected  bool
+	closed     bool
+	// ready is closed once a connection is established, and replaced when it is lost.
+	ready chan struct{}
+
+	// topology and QoS applied again on every new channel
+	queues []queueDeclaration
+	qos    *qosSettings
+
+	stateListeners []chan StateEvent
+
+	// publisher confirms, see WithConfirms
+	confirms        bool
+	confirmListener *confirmListener
+	returnsMu       sync.Mutex
+	pendingReturns  map[string]chan struct{}
+}
+
+type queueDeclaration struct {
+	name    string
+	options []QueueOption
+}
+
+type qosSettings struct {
+	prefetchCount int
+	prefetchSize  int
+	global        bool
 }
 
 type Option func(*RabbitMQ)
@@ -37,19 +81,33 @@ func WithLogger(logger ILogger) Option {
 	}
 }
 
+// WithReconnectBackoff sets the initial and maximum wait between reconnection attempts.
+// The wait is doubled after every failed attempt.
+func WithReconnectBackoff(delay, maxDelay time.Duration) Option {
+	return func(rm *RabbitMQ) {
+		rm.reconnectDelay = delay
+		rm.maxReconnectDelay = maxDelay
+	}
+}
+
 // New initializes a RabbitMQ connection with retry logic.
+// Once connected, the connection is watched and re-established in the background whenever it is lost.
 func New(amqpURL string, maxRetries int, retryInterval time.Duration, options ...Option) (*RabbitMQ, error) {
-	var conn *amqp.Connection
 	var err error
 
-	rmq := &RabbitMQ{}
+	rmq := &RabbitMQ{
+		url:               amqpURL,
+		reconnectDelay:    defaultReconnectDelay,
+		maxReconnectDelay: defaultMaxReconnectDelay,
+		ready:             make(chan struct{}),
+	}
 
 	for _, option := range options {
 		option(rmq)
 	}
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		conn, err = amqp.Dial(amqpURL)
+		err = rmq.connect()
 		if err == nil {
 			break
 		}
@@ -65,25 +123,30 @@ func New(amqpURL string, maxRetries int, retryInterval time.Duration, options ..
 		return nil, errors.New("failed to connect to RabbitMQ after max retries")
 	}
 
-	// open a channel
-	ch, err := conn.Channel()
-	if err != nil {
-		conn.Close() // ensure we close the connection if channel creation fails
-		return nil, err
-	}
-
-	rmq.Channel = ch
-	rmq.Connection = conn
-
 	return rmq, nil
 }
 
 // QueueDeclare provides a wrapper around rabbitmq's QueueDeclare method using optional parameters.
+// The declaration is remembered and repeated after every reconnection.
 func (rmq *RabbitMQ) QueueDeclare(name string, options ...QueueOption) (amqp.Queue, error) {
 	if name == "" {
 		return amqp.Queue{}, fmt.Errorf("queue name cannot be empty")
 	}
 
+	rmq.mu.Lock()
+	rmq.rememberQueue(queueDeclaration{name: name, options: options})
+	ch := rmq.channel
+	connected := rmq.connected
+	rmq.mu.Unlock()
+
+	if !connected {
+		return amqp.Queue{}, ErrNotConnected
+	}
+
+	return declareQueue(ch, name, options...)
+}
+
+func declareQueue(ch *amqp.Channel, name string, options ...QueueOption) (amqp.Queue, error) {
 	// set default options
 	var opts QueueOptions = QueueOptions{
 		Durable:    defaultDurable,
@@ -99,7 +162,7 @@ func (rmq *RabbitMQ) QueueDeclare(name string, options ...QueueOption) (amqp.Que
 	}
 
 	// declare the queue with the given options and arguments
-	q, err := rmq.Channel.QueueDeclare(
+	q, err := ch.QueueDeclare(
 		name,
 		opts.Durable,
 		opts.AutoDelete,
@@ -112,8 +175,29 @@ func (rmq *RabbitMQ) QueueDeclare(name string, options ...QueueOption) (amqp.Que
 }
 
 // PublishWithContext provides a wrapper around rabbitmq's PublishWithContext method using optional parameters.
+// While the connection is being re-established, it waits for the reconnection or for the context to be done.
 func (rmq *RabbitMQ) PublishWithContext(ctx context.Context, options ...PublishOption) error {
+	opts := newPublishOptions(options...)
+
+	ch, err := rmq.waitForChannel(ctx)
+	if err != nil {
+		return err
+	}
 
+	// publish the message to the queue with the given options and arguments
+	err = ch.PublishWithContext(
+		ctx,
+		"", // exchange
+		opts.RoutingKey,
+		opts.Mandatory,
+		opts.Immediate,
+		opts.publishing(),
+	)
+
+	return err
+}
+
+func newPublishOptions(options ...PublishOption) PublishOptions {
 	// set default options
 	var opts PublishOptions = PublishOptions{
 		ContentType: "application/json",
@@ -127,22 +211,32 @@ func (rmq *RabbitMQ) PublishWithContext(ctx context.Context, options ...PublishO
 		option(&opts)
 	}
 
-	// publish the message to the queue with the given options and arguments
-	err := rmq.Channel.PublishWithContext(
-		ctx,
-		"", // exchange
-		opts.RoutingKey,
-		opts.Mandatory,
-		opts.Immediate,
-		amqp.Publishing{
-			ContentType: opts.ContentType,
-			Body:        opts.Body,
-		})
+	return opts
+}
 
-	return err
+func (opts PublishOptions) publishing() amqp.Publishing {
+	deliveryMode := amqp.Transient
+	if opts.Persistent {
+		deliveryMode = amqp.Persistent
+	}
+
+	headers := amqp.Table(opts.Headers)
+	if opts.TraceContext != nil {
+		headers = injectTraceContext(opts.TraceContext, headers)
+	}
+
+	return amqp.Publishing{
+		ContentType:  opts.ContentType,
+		Headers:      headers,
+		DeliveryMode: deliveryMode,
+		MessageId:    opts.MessageId,
+		Body:         opts.Body,
+	}
 }
 
 // ConsumeWithContext provides a wrapper around rabbitmq's ConsumeWithContext method using optional parameters.
+// The returned channel survives reconnections, the consumer is registered again on every new channel.
+// It is closed once the context is done or the connection is closed.
 func (rmq *RabbitMQ) ConsumeWithContext(ctx context.Context, queue string, options ...ConsumeOption) (<-chan amqp.Delivery, error) {
 	if queue == "" {
 		return nil, fmt.Errorf("queue name cannot be empty")
@@ -162,8 +256,26 @@ func (rmq *RabbitMQ) ConsumeWithContext(ctx context.Context, queue string, optio
 		option(&opts)
 	}
 
+	// register the first consumer synchronously, so configuration errors are reported to the caller
+	deliveries, err := rmq.consume(ctx, queue, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan amqp.Delivery)
+	go rmq.forwardDeliveries(ctx, queue, opts, deliveries, out)
+
+	return out, nil
+}
+
+func (rmq *RabbitMQ) consume(ctx context.Context, queue string, opts ConsumeOptions) (<-chan amqp.Delivery, error) {
+	ch, err := rmq.waitForChannel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// consume messages from the queue with the given options
-	deliveries, err := rmq.Channel.ConsumeWithContext(
+	return ch.ConsumeWithContext(
 		ctx,
 		queue,
 		"",                  // Consumer tag (empty means autogenerated)
@@ -173,18 +285,143 @@ func (rmq *RabbitMQ) ConsumeWithContext(ctx context.Context, queue string, optio
 		opts.NoWait,         // NoWait
 		opts.QueueArguments, // QueueArguments
 	)
+}
 
-	if err != nil {
-		return nil, err
-	}
+// forwardDeliveries copies deliveries to out and resumes consuming after every reconnection.
+func (rmq *RabbitMQ) forwardDeliveries(ctx context.Context, queue string, opts ConsumeOptions, deliveries <-chan amqp.Delivery, out chan<- amqp.Delivery) {
+	defer close(out)
+
+	for {
+		for delivery := range deliveries {
+			select {
+			case out <- delivery:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// deliveries are closed when the channel is closed, wait for a new one and consume again
+		var err error
+		for {
+			deliveries, err = rmq.consume(ctx, queue, opts)
+			if err == nil {
+				break
+			}
+
+			if errors.Is(err, ErrClosed) || ctx.Err() != nil {
+				return
+			}
+
+			// the new channel may have been closed again before consuming started
+			if rmq.logger != nil {
+				rmq.logger.Warn("failed to resume consumer", zap.String("queue", queue), zap.Error(err))
+			}
+
+			select {
+			case <-time.After(rmq.reconnectDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
 
-	return deliveries, nil
+		if rmq.logger != nil {
+			rmq.logger.Debug("consumer resumed", zap.String("queue", queue))
+		}
+	}
 }
 
+// Qos sets the prefetch settings of the channel, they are applied again after every reconnection.
 func (rmq *RabbitMQ) Qos(prefetchCount, prefetchSize int, global bool) error {
-	return rmq.Channel.Qos(prefetchCount, prefetchSize, global)
+	rmq.mu.Lock()
+	rmq.qos = &qosSettings{prefetchCount: prefetchCount, prefetchSize: prefetchSize, global: global}
+	ch := rmq.channel
+	connected := rmq.connected
+	rmq.mu.Unlock()
+
+	if !connected {
+		return ErrNotConnected
+	}
+
+	return ch.Qos(prefetchCount, prefetchSize, global)
+}
+
+// QueueInspect returns the state of an existing queue, such as the number of messages ready to be delivered.
+// The queue is declared passively on a channel of its own, as inspecting a queue which does not exist closes the
+// channel, which must not be the one consuming or publishing.
+func (rmq *RabbitMQ) QueueInspect(name string) (amqp.Queue, error) {
+	rmq.mu.RLock()
+	conn, connected := rmq.connection, rmq.connected
+	rmq.mu.RUnlock()
+
+	if !connected {
+		return amqp.Queue{}, ErrNotConnected
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return amqp.Queue{}, fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	return ch.QueueDeclarePassive(name, false, false, false, false, nil)
 }
 
+// Close closes the connection and stops reconnecting.
 func (rmq *RabbitMQ) Close() error {
-	return rmq.Connection.Close()
+	rmq.mu.Lock()
+	if rmq.closed {
+		rmq.mu.Unlock()
+		return nil
+	}
+
+	rmq.closed = true
+	conn, connected := rmq.connection, rmq.connected
+
+	// wake up callers waiting for a reconnection, the lost connection is already closed
+	if !connected {
+		close(rmq.ready)
+	}
+	rmq.mu.Unlock()
+
+	rmq.emitState(StateEvent{State: StateClosed})
+
+	if !connected {
+		return nil
+	}
+
+	return conn.Close()
+}
+
+// waitForChannel returns the current channel, waiting for a reconnection if the connection is lost.
+func (rmq *RabbitMQ) waitForChannel(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		rmq.mu.RLock()
+		ch, connected, closed, ready := rmq.channel, rmq.connected, rmq.closed, rmq.ready
+		rmq.mu.RUnlock()
+
+		if closed {
+			return nil, ErrClosed
+		}
+
+		if connected {
+			return ch, nil
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (rmq *RabbitMQ) rememberQueue(declaration queueDeclaration) {
+	for i, queue := range rmq.queues {
+		if queue.name == declaration.name {
+			rmq.queues[i] = declaration
+			return
+		}
+	}
+
+	rmq.queues = append(rmq.queues, declaration)
 }
@@ -0,0 +1,255 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// ConnectionState describes the state of the connection to the broker.
+type ConnectionState int
+
+const (
+	StateConnected ConnectionState = iota
+	StateDisconnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+
+	return "unknown"
+}
+
+// StateEvent is sent to the registered listeners every time the connection state changes.
+type StateEvent struct {
+	State ConnectionState
+	// Err is the reason of the disconnection or of the failed reconnection attempt.
+	Err error
+	// Attempt is the reconnection attempt number, starting from 1.
+	Attempt int
+}
+
+// NotifyState registers a listener for connection state changes and returns it.
+// Events are dropped if the listener is not ready to receive them, so use a buffered channel.
+func (rmq *RabbitMQ) NotifyState(receiver chan StateEvent) chan StateEvent {
+	rmq.mu.Lock()
+	defer rmq.mu.Unlock()
+
+	rmq.stateListeners = append(rmq.stateListeners, receiver)
+
+	return receiver
+}
+
+// IsConnected reports whether a channel to the broker is currently open.
+func (rmq *RabbitMQ) IsConnected() bool {
+	rmq.mu.RLock()
+	defer rmq.mu.RUnlock()
+
+	return rmq.connected
+}
+
+// Connection returns the current connection to the broker, nil while it has never connected. It is replaced on
+// every reconnection, so it should not be kept.
+func (rmq *RabbitMQ) Connection() *amqp.Connection {
+	rmq.mu.RLock()
+	defer rmq.mu.RUnlock()
+
+	return rmq.connection
+}
+
+// Channel returns the current channel to the broker, nil while it has never connected. It is replaced on every
+// reconnection, so it should not be kept, and the queues and QoS declared on it directly are not declared again.
+func (rmq *RabbitMQ) Channel() *amqp.Channel {
+	rmq.mu.RLock()
+	defer rmq.mu.RUnlock()
+
+	return rmq.channel
+}
+
+// CheckHealth reports whether the connection to the broker is open. It fails while reconnecting.
+func (rmq *RabbitMQ) CheckHealth(ctx context.Context) error {
+	rmq.mu.RLock()
+	defer rmq.mu.RUnlock()
+
+	if rmq.closed {
+		return ErrClosed
+	}
+
+	if !rmq.connected {
+		return ErrNotConnected
+	}
+
+	return nil
+}
+
+// CheckReadiness reports whether messages can be consumed and published, which requires an open channel.
+func (rmq *RabbitMQ) CheckReadiness(ctx context.Context) error {
+	err := rmq.CheckHealth(ctx)
+	if err != nil {
+		return err
+	}
+
+	rmq.mu.RLock()
+	ch := rmq.channel
+	rmq.mu.RUnlock()
+
+	if ch == nil || ch.IsClosed() {
+		return ErrNotConnected
+	}
+
+	return nil
+}
+
+func (rmq *RabbitMQ) emitState(event StateEvent) {
+	rmq.mu.RLock()
+	defer rmq.mu.RUnlock()
+
+	for _, listener := range rmq.stateListeners {
+		select {
+		case listener <- event:
+		default:
+		}
+	}
+}
+
+// connect dials the broker, opens a channel, applies the remembered topology and QoS and starts watching them.
+func (rmq *RabbitMQ) connect() error {
+	conn, err := amqp.Dial(rmq.url)
+	if err != nil {
+		return err
+	}
+
+	// open a channel
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close() // ensure we close the connection if channel creation fails
+		return err
+	}
+
+	rmq.mu.Lock()
+	defer rmq.mu.Unlock()
+
+	if rmq.closed {
+		conn.Close()
+		return ErrClosed
+	}
+
+	for _, queue := range rmq.queues {
+		if _, err := declareQueue(ch, queue.name, queue.options...); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to redeclare queue %s: %w", queue.name, err)
+		}
+	}
+
+	if rmq.confirms {
+		if err := ch.Confirm(false); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to enable publisher confirms: %w", err)
+		}
+
+		// unbuffered, see listenConfirms
+		rmq.confirmListener = newConfirmListener()
+		go rmq.listenConfirms(rmq.confirmListener, ch.NotifyReturn(make(chan amqp.Return)), ch.NotifyPublish(make(chan amqp.Confirmation, 64)))
+	}
+
+	if rmq.qos != nil {
+		if err := ch.Qos(rmq.qos.prefetchCount, rmq.qos.prefetchSize, rmq.qos.global); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to set QoS: %w", err)
+		}
+	}
+
+	connClose := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClose := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	rmq.connection = conn
+	rmq.channel = ch
+	rmq.connected = true
+	close(rmq.ready)
+
+	go rmq.watch(conn, connClose, chClose)
+
+	return nil
+}
+
+// watch waits for the connection or the channel to be closed and reconnects unless Close was called.
+func (rmq *RabbitMQ) watch(conn *amqp.Connection, connClose, chClose chan *amqp.Error) {
+	var amqpErr *amqp.Error
+	select {
+	case amqpErr = <-connClose:
+	case amqpErr = <-chClose:
+	}
+
+	rmq.mu.Lock()
+	if rmq.closed {
+		rmq.mu.Unlock()
+		return
+	}
+
+	rmq.connected = false
+	rmq.ready = make(chan struct{})
+	rmq.mu.Unlock()
+
+	// a closed channel leaves the connection open, drop it to start over with a fresh one
+	conn.Close()
+
+	event := StateEvent{State: StateDisconnected}
+	if amqpErr != nil {
+		event.Err = amqpErr
+	}
+	rmq.emitState(event)
+
+	if rmq.logger != nil {
+		rmq.logger.Warn("connection to RabbitMQ lost, reconnecting", zap.Error(event.Err))
+	}
+
+	rmq.reconnect()
+}
+
+// reconnect tries to connect with exponential backoff until it succeeds or Close is called.
+func (rmq *RabbitMQ) reconnect() {
+	delay := rmq.reconnectDelay
+
+	for attempt := 1; ; attempt++ {
+		err := rmq.connect()
+		if err == nil {
+			rmq.emitState(StateEvent{State: StateConnected, Attempt: attempt})
+
+			if rmq.logger != nil {
+				rmq.logger.Debug("reconnected to RabbitMQ", zap.Int("attempt", attempt))
+			}
+			return
+		}
+
+		if err == ErrClosed {
+			return
+		}
+
+		rmq.emitState(StateEvent{State: StateReconnecting, Err: err, Attempt: attempt})
+
+		if rmq.logger != nil {
+			rmq.logger.Warn("failed to reconnect to RabbitMQ", zap.Int("attempt", attempt), zap.Duration("retryIn", delay), zap.Error(err))
+		}
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > rmq.maxReconnectDelay {
+			delay = rmq.maxReconnectDelay
+		}
+	}
+}
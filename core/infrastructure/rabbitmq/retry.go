@@ -0,0 +1,124 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// HeaderAttempts holds the number of times a message has been processed unsuccessfully.
+	HeaderAttempts = "x-attempts"
+	// HeaderFailureReason holds the error of the last failed processing attempt.
+	HeaderFailureReason = "x-failure-reason"
+
+	defaultMaxAttempts = 3
+	defaultRetryDelay  = 5 * time.Second
+	defaultMaxDelay    = 5 * time.Minute
+)
+
+// ErrInvalidRetryPolicy is returned when a retry policy allows no processing attempt.
+var ErrInvalidRetryPolicy = errors.New("retry policy must allow at least one attempt")
+
+// RetryPolicy defines how many times a failed message is processed and how long it waits between attempts.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of processing attempts, including the first delivery.
+	MaxAttempts int
+	// Delay is the wait before the first redelivery, it is doubled for every following attempt.
+	Delay time.Duration
+	// MaxDelay caps the wait between two attempts.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used when nothing is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: defaultMaxAttempts,
+		Delay:       defaultRetryDelay,
+		MaxDelay:    defaultMaxDelay,
+	}
+}
+
+// RetryDelay returns the wait before the given retry attempt, starting from 1.
+func (p RetryPolicy) RetryDelay(attempt int) time.Duration {
+	delay := p.Delay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	return delay
+}
+
+// RetryQueueName returns the name of the queue holding messages of the given queue waiting for their retry attempt.
+func RetryQueueName(queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, attempt)
+}
+
+// DeadLetterQueueName returns the name of the queue holding messages of the given queue which can not be processed.
+func DeadLetterQueueName(queue string) string {
+	return fmt.Sprintf("%s.dlq", queue)
+}
+
+// QueueDeclarer declares queues, it is implemented by RabbitMQ.
+type QueueDeclarer interface {
+	QueueDeclare(name string, options ...QueueOption) (amqp.Queue, error)
+}
+
+// DeclareRetryTopology declares the dead letter queue and one retry queue per retry attempt for the given queue.
+// Retry queues hold messages for the attempt's delay and then dead-letter them back to the given queue
+// through the default exchange, so no consumer is required on them.
+func (rmq *RabbitMQ) DeclareRetryTopology(queue string, policy RetryPolicy, options ...QueueOption) error {
+	return DeclareRetryQueues(rmq, queue, policy, options...)
+}
+
+// DeclareRetryQueues declares the retry topology of the given queue with the declarer, see DeclareRetryTopology.
+// It returns ErrInvalidRetryPolicy when the policy allows no attempt, failed messages would be republished to retry
+// queues which do not exist.
+func DeclareRetryQueues(declarer QueueDeclarer, queue string, policy RetryPolicy, options ...QueueOption) error {
+	if policy.MaxAttempts < 1 {
+		return fmt.Errorf("%w, got %d", ErrInvalidRetryPolicy, policy.MaxAttempts)
+	}
+
+	_, err := declarer.QueueDeclare(DeadLetterQueueName(queue), options...)
+	if err != nil {
+		return fmt.Errorf("failed to declare dead letter queue: %w", err)
+	}
+
+	for attempt := 1; attempt < policy.MaxAttempts; attempt++ {
+		retryOptions := append([]QueueOption{}, options...)
+		retryOptions = append(retryOptions,
+			WithMessageTTL(policy.RetryDelay(attempt)),
+			WithDeadLetterExchange("", queue),
+		)
+
+		_, err = declarer.QueueDeclare(RetryQueueName(queue, attempt), retryOptions...)
+		if err != nil {
+			return fmt.Errorf("failed to declare retry queue for attempt %d: %w", attempt, err)
+		}
+	}
+
+	return nil
+}
+
+// GetAttempts returns the number of failed attempts recorded in the message headers.
+func GetAttempts(headers amqp.Table) int {
+	switch attempts := headers[HeaderAttempts].(type) {
+	case int:
+		return attempts
+	case int8:
+		return int(attempts)
+	case int16:
+		return int(attempts)
+	case int32:
+		return int(attempts)
+	case int64:
+		return int(attempts)
+	}
+
+	return 0
+}
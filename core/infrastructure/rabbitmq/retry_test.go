@@ -0,0 +1,60 @@
+package rabbitmq_test
+
+import (
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals/core/infrastructure/rabbitmq"
+)
+
+func TestRetryPolicyRetryDelay(t *testing.T) {
+	policy := rabbitmq.RetryPolicy{
+		MaxAttempts: 5,
+		Delay:       time.Second,
+		MaxDelay:    5 * time.Second,
+	}
+
+	tests := []struct {
+		name     string
+		attempt  int
+		expected time.Duration
+	}{
+		{name: "first retry", attempt: 1, expected: time.Second},
+		{name: "second retry", attempt: 2, expected: 2 * time.Second},
+		{name: "third retry", attempt: 3, expected: 4 * time.Second},
+		{name: "capped by max delay", attempt: 4, expected: 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, policy.RetryDelay(test.attempt))
+		})
+	}
+}
+
+func TestGetAttempts(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  amqp.Table
+		expected int
+	}{
+		{name: "no headers", headers: nil, expected: 0},
+		{name: "missing header", headers: amqp.Table{"other": "value"}, expected: 0},
+		{name: "int32 header", headers: amqp.Table{rabbitmq.HeaderAttempts: int32(2)}, expected: 2},
+		{name: "int64 header", headers: amqp.Table{rabbitmq.HeaderAttempts: int64(3)}, expected: 3},
+		{name: "invalid header", headers: amqp.Table{rabbitmq.HeaderAttempts: "3"}, expected: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, rabbitmq.GetAttempts(test.headers))
+		})
+	}
+}
+
+func TestQueueNames(t *testing.T) {
+	assert.Equal(t, "users.retry.2", rabbitmq.RetryQueueName("users", 2))
+	assert.Equal(t, "users.dlq", rabbitmq.DeadLetterQueueName("users"))
+}
@@ -0,0 +1,54 @@
+package rabbitmq
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+)
+
+// HeaderCarrier adapts the headers of a message to the trace context propagators.
+type HeaderCarrier amqp.Table
+
+func (c HeaderCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c HeaderCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// WithTraceContext propagates the trace context of ctx in the headers of the message, using the global propagator.
+// It is added to the headers set by WithHeaders, whatever the order of the options.
+func WithTraceContext(ctx context.Context) PublishOption {
+	return func(o *PublishOptions) {
+		o.TraceContext = ctx
+	}
+}
+
+// ExtractTraceContext returns a copy of ctx carrying the trace context propagated in the headers of a message.
+func ExtractTraceContext(ctx context.Context, headers amqp.Table) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, HeaderCarrier(headers))
+}
+
+// injectTraceContext returns a copy of the headers with the trace context of ctx.
+func injectTraceContext(ctx context.Context, headers amqp.Table) amqp.Table {
+	injected := make(amqp.Table, len(headers)+2)
+	for key, value := range headers {
+		injected[key] = value
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, HeaderCarrier(injected))
+
+	return injected
+}
@@ -0,0 +1,35 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "publish")
+	defer span.End()
+
+	headers := amqp.Table{HeaderAttempts: int32(2)}
+	publishing := newPublishOptions(WithTraceContext(ctx), WithHeaders(headers)).publishing()
+
+	// the trace context is added to the headers without modifying the caller's map
+	assert.Contains(t, publishing.Headers, "traceparent")
+	assert.Equal(t, int32(2), publishing.Headers[HeaderAttempts])
+	assert.NotContains(t, headers, "traceparent")
+
+	extracted := trace.SpanContextFromContext(ExtractTraceContext(context.Background(), publishing.Headers))
+	require.True(t, extracted.IsValid())
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), extracted.SpanID())
+	assert.True(t, extracted.IsRemote())
+}
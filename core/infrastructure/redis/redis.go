@@ -71,6 +71,14 @@ func (r *RedisCache) Close() error {
 	return r.client.Close()
 }
 
+func (r *RedisCache) CheckHealth(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *RedisCache) CheckReadiness(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
 type NoOpCache struct{}
 
 // NewNoOpCache initializes a cache with no operations. It can be used when caching doesn't needed.
@@ -97,3 +105,13 @@ func (n *NoOpCache) Exists(ctx context.Context, key string) (bool, error) {
 func (n *NoOpCache) Close() error {
 	return nil
 }
+
+// CheckHealth always fails, the no operation cache stands in for a cache which is not available.
+func (n *NoOpCache) CheckHealth(ctx context.Context) error {
+	return ErrCacheNotInitialized
+}
+
+// CheckReadiness always fails, the no operation cache stands in for a cache which is not available.
+func (n *NoOpCache) CheckReadiness(ctx context.Context) error {
+	return ErrCacheNotInitialized
+}
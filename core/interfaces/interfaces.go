@@ -25,6 +25,15 @@ type ICacheService interface {
 	Set(ctx context.Context, key string, value string, ttl time.Duration) error
 	Delete(ctx context.Context, key string) error
 	Exists(ctx context.Context, key string) (bool, error)
+	Close() error
+}
+
+// IHealthChecker interface defines the methods used by the health and readiness probes of an infrastructure dependency.
+type IHealthChecker interface {
+	// CheckHealth reports whether the dependency is reachable.
+	CheckHealth(ctx context.Context) error
+	// CheckReadiness reports whether the dependency can serve requests.
+	CheckReadiness(ctx context.Context) error
 }
 
 // IEncryptionService interface defines methods for encryption and hashing.
@@ -32,13 +41,29 @@ type IEncryptionService interface {
 	Encrypt(data string) (string, error)
 	Decrypt(data string) (string, error)
 	Hash(data string) (string, error)
+	// CompareHash reports whether the hash is the hash of the data, whichever algorithm it was computed with
 	CompareHash(data, hash string) (bool, error)
+	// NeedsRehash reports whether the hash was computed with other algorithm or parameters than new hashes are
+	NeedsRehash(hash string) (bool, error)
+}
+
+// IKeyRotator interface defines the method used to move ciphertexts to the active encryption key.
+type IKeyRotator interface {
+	// Reencrypt returns the data encrypted with the active key, and false when it already was.
+	Reencrypt(data string) (string, bool, error)
+}
+
+// IBlindIndex interface defines the method computing the searchable index of an encrypted value.
+type IBlindIndex interface {
+	Index(value string) string
 }
 
 // IQueryService interface defines the methods related to rabbitmq or any queue level implementations.
 type IQueueService interface {
 	ConsumeWithContext(ctx context.Context, queue string, options ...rabbitmq.ConsumeOption) (<-chan amqp.Delivery, error)
 	PublishWithContext(ctx context.Context, options ...rabbitmq.PublishOption) error
+	PublishWithConfirm(ctx context.Context, options ...rabbitmq.PublishOption) error
 	QueueDeclare(name string, options ...rabbitmq.QueueOption) (amqp.Queue, error)
+	QueueInspect(name string) (amqp.Queue, error)
 	Qos(prefetchCount, prefetchSize int, global bool) error
 }
@@ -146,6 +146,20 @@ func (m *MockICacheService) EXPECT() *MockICacheServiceMockRecorder {
 	return m.recorder
 }
 
+// Close mocks base method.
+func (m *MockICacheService) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockICacheServiceMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockICacheService)(nil).Close))
+}
+
 // Delete mocks base method.
 func (m *MockICacheService) Delete(ctx context.Context, key string) error {
 	m.ctrl.T.Helper()
@@ -204,6 +218,57 @@ func (mr *MockICacheServiceMockRecorder) Set(ctx, key, value, ttl interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockICacheService)(nil).Set), ctx, key, value, ttl)
 }
 
+// MockIHealthChecker is a mock of IHealthChecker interface.
+type MockIHealthChecker struct {
+	ctrl     *gomock.Controller
+	recorder *MockIHealthCheckerMockRecorder
+}
+
+// MockIHealthCheckerMockRecorder is the mock recorder for MockIHealthChecker.
+type MockIHealthCheckerMockRecorder struct {
+	mock *MockIHealthChecker
+}
+
+// NewMockIHealthChecker creates a new mock instance.
+func NewMockIHealthChecker(ctrl *gomock.Controller) *MockIHealthChecker {
+	mock := &MockIHealthChecker{ctrl: ctrl}
+	mock.recorder = &MockIHealthCheckerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIHealthChecker) EXPECT() *MockIHealthCheckerMockRecorder {
+	return m.recorder
+}
+
+// CheckHealth mocks base method.
+func (m *MockIHealthChecker) CheckHealth(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckHealth", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckHealth indicates an expected call of CheckHealth.
+func (mr *MockIHealthCheckerMockRecorder) CheckHealth(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckHealth", reflect.TypeOf((*MockIHealthChecker)(nil).CheckHealth), ctx)
+}
+
+// CheckReadiness mocks base method.
+func (m *MockIHealthChecker) CheckReadiness(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckReadiness", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckReadiness indicates an expected call of CheckReadiness.
+func (mr *MockIHealthCheckerMockRecorder) CheckReadiness(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckReadiness", reflect.TypeOf((*MockIHealthChecker)(nil).CheckReadiness), ctx)
+}
+
 // MockIEncryptionService is a mock of IEncryptionService interface.
 type MockIEncryptionService struct {
 	ctrl     *gomock.Controller
@@ -287,6 +352,97 @@ func (mr *MockIEncryptionServiceMockRecorder) Hash(data interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Hash", reflect.TypeOf((*MockIEncryptionService)(nil).Hash), data)
 }
 
+// NeedsRehash mocks base method.
+func (m *MockIEncryptionService) NeedsRehash(hash string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NeedsRehash", hash)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NeedsRehash indicates an expected call of NeedsRehash.
+func (mr *MockIEncryptionServiceMockRecorder) NeedsRehash(hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NeedsRehash", reflect.TypeOf((*MockIEncryptionService)(nil).NeedsRehash), hash)
+}
+
+// MockIKeyRotator is a mock of IKeyRotator interface.
+type MockIKeyRotator struct {
+	ctrl     *gomock.Controller
+	recorder *MockIKeyRotatorMockRecorder
+}
+
+// MockIKeyRotatorMockRecorder is the mock recorder for MockIKeyRotator.
+type MockIKeyRotatorMockRecorder struct {
+	mock *MockIKeyRotator
+}
+
+// NewMockIKeyRotator creates a new mock instance.
+func NewMockIKeyRotator(ctrl *gomock.Controller) *MockIKeyRotator {
+	mock := &MockIKeyRotator{ctrl: ctrl}
+	mock.recorder = &MockIKeyRotatorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIKeyRotator) EXPECT() *MockIKeyRotatorMockRecorder {
+	return m.recorder
+}
+
+// Reencrypt mocks base method.
+func (m *MockIKeyRotator) Reencrypt(data string) (string, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reencrypt", data)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Reencrypt indicates an expected call of Reencrypt.
+func (mr *MockIKeyRotatorMockRecorder) Reencrypt(data interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reencrypt", reflect.TypeOf((*MockIKeyRotator)(nil).Reencrypt), data)
+}
+
+// MockIBlindIndex is a mock of IBlindIndex interface.
+type MockIBlindIndex struct {
+	ctrl     *gomock.Controller
+	recorder *MockIBlindIndexMockRecorder
+}
+
+// MockIBlindIndexMockRecorder is the mock recorder for MockIBlindIndex.
+type MockIBlindIndexMockRecorder struct {
+	mock *MockIBlindIndex
+}
+
+// NewMockIBlindIndex creates a new mock instance.
+func NewMockIBlindIndex(ctrl *gomock.Controller) *MockIBlindIndex {
+	mock := &MockIBlindIndex{ctrl: ctrl}
+	mock.recorder = &MockIBlindIndexMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIBlindIndex) EXPECT() *MockIBlindIndexMockRecorder {
+	return m.recorder
+}
+
+// Index mocks base method.
+func (m *MockIBlindIndex) Index(value string) string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Index", value)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// Index indicates an expected call of Index.
+func (mr *MockIBlindIndexMockRecorder) Index(value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Index", reflect.TypeOf((*MockIBlindIndex)(nil).Index), value)
+}
+
 // MockIQueueService is a mock of IQueueService interface.
 type MockIQueueService struct {
 	ctrl     *gomock.Controller
@@ -330,6 +486,25 @@ func (mr *MockIQueueServiceMockRecorder) ConsumeWithContext(ctx, queue interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeWithContext", reflect.TypeOf((*MockIQueueService)(nil).ConsumeWithContext), varargs...)
 }
 
+// PublishWithConfirm mocks base method.
+func (m *MockIQueueService) PublishWithConfirm(ctx context.Context, options ...rabbitmq.PublishOption) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx}
+	for _, a := range options {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "PublishWithConfirm", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PublishWithConfirm indicates an expected call of PublishWithConfirm.
+func (mr *MockIQueueServiceMockRecorder) PublishWithConfirm(ctx interface{}, options ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx}, options...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublishWithConfirm", reflect.TypeOf((*MockIQueueService)(nil).PublishWithConfirm), varargs...)
+}
+
 // PublishWithContext mocks base method.
 func (m *MockIQueueService) PublishWithContext(ctx context.Context, options ...rabbitmq.PublishOption) error {
 	m.ctrl.T.Helper()
@@ -382,3 +557,18 @@ func (mr *MockIQueueServiceMockRecorder) QueueDeclare(name interface{}, options
 	varargs := append([]interface{}{name}, options...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueueDeclare", reflect.TypeOf((*MockIQueueService)(nil).QueueDeclare), varargs...)
 }
+
+// QueueInspect mocks base method.
+func (m *MockIQueueService) QueueInspect(name string) (amqp091.Queue, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueueInspect", name)
+	ret0, _ := ret[0].(amqp091.Queue)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueueInspect indicates an expected call of QueueInspect.
+func (mr *MockIQueueServiceMockRecorder) QueueInspect(name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueueInspect", reflect.TypeOf((*MockIQueueService)(nil).QueueInspect), name)
+}
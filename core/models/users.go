@@ -1,15 +1,38 @@
 package models
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrUserExists     = errors.New("user already exists")
+	ErrUserDeleted    = errors.New("user is deleted")
+	ErrUserNotDeleted = errors.New("user is not deleted")
+)
 
 // User represents a users table in the postgresql database.
+// The fields tagged pii:"encrypted" are encrypted at rest, see encryption.Fields. The names were stored in clear
+// before, allow-plaintext lets them be read until the re-encryption job has encrypted them.
 type User struct {
 	Id           int64      `json:"id" db:"id"`
-	Email        string     `json:"email" db:"email"`
-	FirstName    string     `json:"firstname" db:"firstname"`
-	LastName     string     `json:"lastname" db:"lastname"`
+	SourceId     *int64     `json:"source_id,omitempty" db:"source_id"`
+	Email        string     `json:"email" db:"email" pii:"encrypted"`
+	EmailIndex   *string    `json:"-" db:"email_index"`
+	FirstName    string     `json:"firstname" db:"firstname" pii:"encrypted,allow-plaintext"`
+	LastName     string     `json:"lastname" db:"lastname" pii:"encrypted,allow-plaintext"`
+	PiiDigest    *string    `json:"-" db:"pii_digest"`
 	ParentUserId *int64     `json:"parent_user_id,omitempty" db:"parent_user_id"`
 	CreatedAt    *time.Time `json:"created_at,omitempty" db:"created_at"`
 	DeletedAt    *time.Time `json:"updated_at,omitempty" db:"deleted_at"`
 	MergedAt     *time.Time `json:"merged_at,omitempty" db:"merged_at"`
 }
+
+// UserPatch holds the fields of a user changed by a partial update, the nil ones are left as they are.
+type UserPatch struct {
+	Email        *string
+	FirstName    *string
+	LastName     *string
+	ParentUserId *int64
+}
@@ -1,11 +1,31 @@
 package logger
 
 import (
+	"fmt"
+
 	"github.com/uptrace/opentelemetry-go-extra/otelzap"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// Config describes the logger of a service, it is usually loaded from the environment.
+type Config struct {
+	// Level is the minimum level logged: debug, info, warn or error. It can be changed at runtime through the returned zap.AtomicLevel.
+	Level string
+	// Encoding is json or console.
+	Encoding string
+	// Sampling drops repeated log entries above 100 per second, logging every 100th of them.
+	Sampling bool
+	// OutputPaths are the files or URLs logs are written to, stdout and stderr included.
+	OutputPaths []string
+	// ServiceName and ServiceVersion are added to every log entry, when set.
+	ServiceName    string
+	ServiceVersion string
+	// RedactKeys are the keys whose values are never logged, DefaultRedactKeys when empty.
+	// Email addresses are masked whatever their key.
+	RedactKeys []string
+}
+
 var defaultZapConfig = zap.Config{
 	Level:       zap.NewAtomicLevelAt(zap.DebugLevel),
 	Development: false,
@@ -31,7 +51,7 @@ var defaultZapConfig = zap.Config{
 
 // NewDefaultLogger returns you a new otelzap logger with core specific default settings
 func NewDefaultLogger() (*otelzap.Logger, error) {
-	zapLogger, err := defaultZapConfig.Build()
+	zapLogger, err := defaultZapConfig.Build(redactor(nil))
 	if err != nil {
 		return nil, err
 	}
@@ -41,17 +61,64 @@ func NewDefaultLogger() (*otelzap.Logger, error) {
 	return logger, nil
 }
 
+// NewFromConfig creates a new otelzap logger from the config, with the default settings for everything it leaves empty.
+// The returned level changes the minimum level of the logger at runtime, it also serves it over HTTP.
+func NewFromConfig(cfg Config) (*otelzap.Logger, zap.AtomicLevel, error) {
+	zapConfig := defaultZapConfig
+	zapConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+
+	if cfg.Level != "" {
+		level, err := zapcore.ParseLevel(cfg.Level)
+		if err != nil {
+			return nil, zapConfig.Level, fmt.Errorf("invalid logger level: %w", err)
+		}
+
+		zapConfig.Level.SetLevel(level)
+	}
+
+	switch cfg.Encoding {
+	case "":
+	case "json", "console":
+		zapConfig.Encoding = cfg.Encoding
+	default:
+		return nil, zapConfig.Level, fmt.Errorf("invalid logger encoding %q, expected json or console", cfg.Encoding)
+	}
+
+	if !cfg.Sampling {
+		zapConfig.Sampling = nil
+	}
+
+	if len(cfg.OutputPaths) > 0 {
+		zapConfig.OutputPaths = cfg.OutputPaths
+	}
+
+	var fields []zap.Field
+	if cfg.ServiceName != "" {
+		fields = append(fields, zap.String("service", cfg.ServiceName))
+	}
+	if cfg.ServiceVersion != "" {
+		fields = append(fields, zap.String("version", cfg.ServiceVersion))
+	}
+
+	zapLogger, err := zapConfig.Build(zap.Fields(fields...), redactor(cfg.RedactKeys))
+	if err != nil {
+		return nil, zapConfig.Level, err
+	}
+
+	return otelzap.New(zapLogger, otelzap.WithMinLevel(zap.DebugLevel)), zapConfig.Level, nil
+}
+
 // New creates a new otelzap logger with optional custom zap configuration.
-// If no configuration is provided, it uses the default configuration.
+// If no configuration is provided, it uses the default configuration. The default keys are redacted either way.
 func New(config *zap.Config) (*otelzap.Logger, error) {
 	var zapLogger *zap.Logger
 	var err error
 
 	// Use custom configuration if provided, otherwise use default configuration
 	if config != nil {
-		zapLogger, err = config.Build()
+		zapLogger, err = config.Build(redactor(nil))
 	} else {
-		zapLogger, err = defaultZapConfig.Build()
+		zapLogger, err = defaultZapConfig.Build(redactor(nil))
 	}
 
 	if err != nil {
@@ -0,0 +1,55 @@
+package logger_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/core/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func TestNewFromConfig(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "service.log")
+
+	log, level, err := logger.NewFromConfig(logger.Config{
+		Level:          "info",
+		OutputPaths:    []string{output},
+		ServiceName:    "consumer",
+		ServiceVersion: "1.2.3",
+	})
+	require.NoError(t, err)
+
+	log.Debug("not logged")
+	log.Info("logged")
+
+	// the level is changed without building a new logger
+	level.SetLevel(zap.DebugLevel)
+	log.Debug("logged once enabled")
+	require.NoError(t, log.Sync())
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "logged", entry["message"])
+	assert.Equal(t, "consumer", entry["service"])
+	assert.Equal(t, "1.2.3", entry["version"])
+	assert.Contains(t, lines[1], "logged once enabled")
+}
+
+func TestNewFromConfig_Invalid(t *testing.T) {
+	_, _, err := logger.NewFromConfig(logger.Config{Level: "verbose"})
+	assert.Error(t, err)
+
+	_, _, err = logger.NewFromConfig(logger.Config{Encoding: "xml"})
+	assert.Error(t, err)
+}
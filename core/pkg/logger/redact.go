@@ -0,0 +1,206 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces the value of the fields with a redacted key.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactKeys are the keys redacted when the config does not list any.
+var DefaultRedactKeys = []string{"email", "password", "secret", "token", "authorization", "encryption_key"}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// safeString is the value of the fields built by the helpers below, it is already free of PII and never redacted.
+type safeString string
+
+func (s safeString) String() string {
+	return string(s)
+}
+
+// MaskEmail keeps the first character of the local part and the domain of the email, j***@example.com.
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return Redacted
+	}
+
+	first, _ := utf8.DecodeRuneInString(local)
+
+	return string(first) + "***@" + domain
+}
+
+// MaskedEmail logs the email masked by MaskEmail.
+func MaskedEmail(key, email string) zap.Field {
+	return zap.Stringer(key, safeString(MaskEmail(email)))
+}
+
+// HashedId logs a truncated SHA-256 of the value, it correlates the entries of an identifier without revealing it.
+func HashedId(key, value string) zap.Field {
+	sum := sha256.Sum256([]byte(value))
+
+	return zap.Stringer(key, safeString(hex.EncodeToString(sum[:8])))
+}
+
+// redactingCore scrubs the entries before they reach the wrapped core: the values of the redacted keys are replaced
+// and the email addresses found in messages, strings, errors and reflected values are masked.
+type redactingCore struct {
+	zapcore.Core
+	keys map[string]struct{}
+}
+
+// NewRedactingCore wraps the core so no value of the keys, compared case insensitively, and no email address is written.
+func NewRedactingCore(core zapcore.Core, keys []string) zapcore.Core {
+	redactedKeys := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		redactedKeys[strings.ToLower(strings.TrimSpace(key))] = struct{}{}
+	}
+
+	return &redactingCore{Core: core, keys: redactedKeys}
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.redactFields(fields)), keys: c.keys}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	// the wrapped core decides whether the entry is logged, it may be sampled
+	if c.Core.Check(entry, nil) == nil {
+		return checked
+	}
+
+	return checked.AddCore(entry, c)
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = maskEmails(entry.Message)
+
+	return c.Core.Write(entry, c.redactFields(fields))
+}
+
+func (c *redactingCore) redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = c.redactField(field)
+	}
+
+	return redacted
+}
+
+func (c *redactingCore) redactField(field zapcore.Field) zapcore.Field {
+	if _, ok := field.Interface.(safeString); ok {
+		return field
+	}
+
+	if c.redacted(field.Key) {
+		return zap.String(field.Key, Redacted)
+	}
+
+	switch field.Type {
+	case zapcore.StringType:
+		field.String = maskEmails(field.String)
+	case zapcore.ByteStringType:
+		if value, ok := field.Interface.([]byte); ok && emailPattern.Match(value) {
+			return zap.ByteString(field.Key, emailPattern.ReplaceAllFunc(value, func(email []byte) []byte {
+				return []byte(MaskEmail(string(email)))
+			}))
+		}
+	case zapcore.ErrorType:
+		if err, ok := field.Interface.(error); ok && emailPattern.MatchString(err.Error()) {
+			return zap.String(field.Key, maskEmails(err.Error()))
+		}
+	case zapcore.StringerType:
+		if stringer, ok := field.Interface.(fmt.Stringer); ok && emailPattern.MatchString(stringer.String()) {
+			return zap.String(field.Key, maskEmails(stringer.String()))
+		}
+	case zapcore.ReflectType:
+		if value, changed := c.redactReflected(field.Interface); changed {
+			return zap.Any(field.Key, value)
+		}
+	}
+
+	return field
+}
+
+// redactReflected scrubs a value logged with zap.Any through its JSON form, which is how it is encoded anyway.
+func (c *redactingCore) redactReflected(value any) (any, bool) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return value, false
+	}
+
+	return c.redactValue(decoded)
+}
+
+func (c *redactingCore) redactValue(value any) (any, bool) {
+	changed := false
+
+	switch v := value.(type) {
+	case string:
+		masked := maskEmails(v)
+		return masked, masked != v
+	case []any:
+		for i, item := range v {
+			redacted, itemChanged := c.redactValue(item)
+			v[i] = redacted
+			changed = changed || itemChanged
+		}
+	case map[string]any:
+		for key, item := range v {
+			if c.redacted(key) {
+				v[key] = Redacted
+				changed = true
+				continue
+			}
+
+			redacted, itemChanged := c.redactValue(item)
+			v[key] = redacted
+			changed = changed || itemChanged
+		}
+	}
+
+	return value, changed
+}
+
+func (c *redactingCore) redacted(key string) bool {
+	_, ok := c.keys[strings.ToLower(key)]
+	return ok
+}
+
+func maskEmails(s string) string {
+	if !strings.Contains(s, "@") {
+		return s
+	}
+
+	return emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+}
+
+// redactor wraps the core of a logger with the redacting core.
+func redactor(keys []string) zap.Option {
+	if len(keys) == 0 {
+		keys = DefaultRedactKeys
+	}
+
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return NewRedactingCore(core, keys)
+	})
+}
@@ -0,0 +1,66 @@
+package logger_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/core/pkg/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMaskEmail(t *testing.T) {
+	tests := []struct {
+		email    string
+		expected string
+	}{
+		{email: "john.doe@example.com", expected: "j***@example.com"},
+		{email: "élodie@example.fr", expected: "é***@example.fr"},
+		{email: "not an email", expected: logger.Redacted},
+		{email: "@example.com", expected: logger.Redacted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.email, func(t *testing.T) {
+			assert.Equal(t, test.expected, logger.MaskEmail(test.email))
+		})
+	}
+}
+
+func TestRedactingCore(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	log := zap.New(logger.NewRedactingCore(core, []string{"email", "Password"}))
+
+	log.With(zap.String("password", "hunter2")).Info("user john.doe@example.com created",
+		zap.String("email", "john.doe@example.com"),
+		logger.MaskedEmail("email", "jane@example.com"),
+		logger.HashedId("user", "jane@example.com"),
+		zap.String("user", `{"id":1,"email":"jane@example.com"}`),
+		zap.Error(errors.New(`invalid email "jane@example"com"`)),
+		zap.Error(errors.New("invalid email jane@example.com")),
+		zap.Any("query", url.Values{"email": {"jane@example.com"}, "page": {"1"}}),
+		zap.Int("attempt", 2),
+	)
+
+	entries := logs.All()
+	require.Len(t, entries, 1)
+
+	entry := entries[0]
+	assert.Equal(t, "user j***@example.com created", entry.Message)
+
+	fields := entry.Context
+	require.Len(t, fields, 9)
+	assert.Equal(t, logger.Redacted, fields[0].String)
+	assert.Equal(t, logger.Redacted, fields[1].String)
+	assert.Equal(t, "j***@example.com", fields[2].Interface.(interface{ String() string }).String())
+	assert.Len(t, fields[3].Interface.(interface{ String() string }).String(), 16)
+	assert.Equal(t, `{"id":1,"email":"j***@example.com"}`, fields[4].String)
+	assert.Equal(t, zapcore.ErrorType, fields[5].Type, "errors without an email are kept")
+	assert.Equal(t, "invalid email j***@example.com", fields[6].String)
+	assert.Equal(t, map[string]any{"email": logger.Redacted, "page": []any{"1"}}, fields[7].Interface)
+	assert.Equal(t, int64(2), fields[8].Integer)
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "viswals"
+
+// Row stages of the producer pipeline.
+const (
+	RowRead      = "read"
+	RowParsed    = "parsed"
+	RowRejected  = "rejected"
+	RowPublished = "published"
+	RowFailed    = "failed"
+)
+
+// Message outcomes of the consumer.
+const (
+	MessageConsumed     = "consumed"
+	MessageAcked        = "acked"
+	MessageNacked       = "nacked"
+	MessageRetried      = "retried"
+	MessageDeadLettered = "dead_lettered"
+)
+
+// Cache lookup results.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// Registry holds the metrics of the services, along with the Go runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Rows counts the rows of the producer input by stage.
+	Rows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "producer",
+		Name:      "rows_total",
+		Help:      "Rows of the producer input by stage: read, parsed, rejected, published or failed.",
+	}, []string{"stage"})
+
+	// Messages counts the messages of the consumer by outcome.
+	Messages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "messages_total",
+		Help:      "Messages of the consumer by outcome: consumed, acked, nacked, retried or dead_lettered.",
+	}, []string{"queue", "outcome"})
+
+	// WorkersBusy is the number of consumer workers processing a message.
+	WorkersBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "workers_busy",
+		Help:      "Number of consumer workers processing a message.",
+	})
+
+	// DBWriteDuration observes the latency of the database writes by operation.
+	DBWriteDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "db_write_duration_seconds",
+		Help:      "Latency of the database writes by operation.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation"})
+
+	// CacheRequests counts the cache lookups by result.
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups by operation and result: hit or miss.",
+	}, []string{"operation", "result"})
+
+	// EncryptionErrors counts the failed encryptions and decryptions.
+	EncryptionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "encryption_errors_total",
+		Help:      "Failed encryptions and decryptions by operation.",
+	}, []string{"operation"})
+
+	// HTTPRequests counts the HTTP requests by method, route and status code.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "code"})
+
+	// HTTPRequestDuration observes the latency of the HTTP requests by method and route.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the HTTP requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Rows,
+		Messages,
+		WorkersBusy,
+		DBWriteDuration,
+		CacheRequests,
+		EncryptionErrors,
+		HTTPRequests,
+		HTTPRequestDuration,
+	)
+}
+
+// Handler serves the metrics of the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// NewServer creates a server exposing the metrics on /metrics, for services without an HTTP API of their own.
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 3 * time.Second,
+	}
+}
+
+// ObserveDuration observes the time elapsed since start on the histogram.
+func ObserveDuration(observer prometheus.Observer, start time.Time) {
+	observer.Observe(time.Since(start).Seconds())
+}
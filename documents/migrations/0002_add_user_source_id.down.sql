@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_source_id_key;
+ALTER TABLE users DROP COLUMN IF EXISTS source_id;
+
+COMMIT;
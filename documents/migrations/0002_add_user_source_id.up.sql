@@ -0,0 +1,7 @@
+BEGIN;
+
+-- id of the user in the source CSV file, used to make ingestion idempotent
+ALTER TABLE users ADD COLUMN IF NOT EXISTS source_id BIGINT;
+ALTER TABLE users ADD CONSTRAINT users_source_id_key UNIQUE (source_id);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_index_key;
+ALTER TABLE users DROP COLUMN IF EXISTS email_index;
+
+COMMIT;
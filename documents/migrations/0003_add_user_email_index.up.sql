@@ -0,0 +1,11 @@
+BEGIN;
+
+-- keyed HMAC of the normalized email, emails are encrypted with a random nonce so their ciphertexts never match.
+-- Users written before the column existed have no index until the re-encryption job fills it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_index TEXT;
+ALTER TABLE users ADD CONSTRAINT users_email_index_key UNIQUE (email_index);
+
+-- a unique ciphertext does not make the email unique, the index does
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+-- keyed HMAC of the plaintext of the encrypted fields, the ciphertexts change on every write so a change of the
+-- fields is detected through it. Users written before the column existed have none until the re-encryption job fills it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pii_digest TEXT;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+-- fails when a deleted user shares its email with another user
+DROP INDEX IF EXISTS users_email_index_key;
+ALTER TABLE users ADD CONSTRAINT users_email_index_key UNIQUE (email_index);
+
+COMMIT;
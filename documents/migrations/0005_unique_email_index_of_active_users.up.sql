@@ -0,0 +1,7 @@
+BEGIN;
+
+-- only the users which are not deleted own their email, a deleted user frees it for a new one
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_index_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_index_key ON users (email_index) WHERE deleted_at IS NULL;
+
+COMMIT;
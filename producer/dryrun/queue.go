@@ -0,0 +1,65 @@
+package dryrun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/viswals/core/infrastructure/rabbitmq"
+)
+
+var (
+	ErrConsumeNotSupported = errors.New("consuming is not supported in dry run mode")
+)
+
+// Queue implements the queue service without a broker, it writes every published message body
+// as a line to the underlying writer, which produces NDJSON for JSON messages.
+type Queue struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// New creates a dry run queue writing the published messages to the given writer.
+func New(writer io.Writer) *Queue {
+	return &Queue{
+		writer: writer,
+	}
+}
+
+func (q *Queue) PublishWithContext(ctx context.Context, options ...rabbitmq.PublishOption) error {
+	var opts rabbitmq.PublishOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	// messages are published by concurrent workers, keep lines intact
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := q.writer.Write(append(opts.Body, '\n'))
+
+	return err
+}
+
+// PublishWithConfirm writes the message like PublishWithContext, a written message is considered confirmed.
+func (q *Queue) PublishWithConfirm(ctx context.Context, options ...rabbitmq.PublishOption) error {
+	return q.PublishWithContext(ctx, options...)
+}
+
+func (q *Queue) ConsumeWithContext(ctx context.Context, queue string, options ...rabbitmq.ConsumeOption) (<-chan amqp.Delivery, error) {
+	return nil, ErrConsumeNotSupported
+}
+
+func (q *Queue) QueueDeclare(name string, options ...rabbitmq.QueueOption) (amqp.Queue, error) {
+	return amqp.Queue{Name: name}, nil
+}
+
+func (q *Queue) QueueInspect(name string) (amqp.Queue, error) {
+	return amqp.Queue{Name: name}, nil
+}
+
+func (q *Queue) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return nil
+}
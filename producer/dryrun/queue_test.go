@@ -0,0 +1,24 @@
+package dryrun_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals/core/infrastructure/rabbitmq"
+	"github.com/viswals/producer/dryrun"
+)
+
+func TestQueuePublish(t *testing.T) {
+	var buf bytes.Buffer
+	queue := dryrun.New(&buf)
+
+	err := queue.PublishWithContext(context.Background(), rabbitmq.WithBody([]byte(`{"Id":1}`)))
+	assert.NoError(t, err)
+
+	err = queue.PublishWithConfirm(context.Background(), rabbitmq.WithBody([]byte(`{"Id":2}`)))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "{\"Id\":1}\n{\"Id\":2}\n", buf.String())
+}
@@ -1,12 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
 	"github.com/viswals/core/infrastructure/rabbitmq"
+	"github.com/viswals/core/interfaces"
 	"github.com/viswals/core/pkg/logger"
+	"github.com/viswals/core/pkg/metrics"
+	"github.com/viswals/core/pkg/tracing"
 	"github.com/viswals/producer/config"
+	"github.com/viswals/producer/dryrun"
 	"github.com/viswals/producer/usecase"
 	"go.uber.org/zap"
 )
@@ -18,17 +30,37 @@ func main() {
 		panic("failed to load configuration")
 	}
 
+	// filepath flag
+	filepathFlag := flag.String("filepath", "./users.csv", "Path to the CSV or NDJSON input file, optionally gzip compressed, - reads the standard input")
+	formatFlag := flag.String("format", "", "Format of the input file, csv or ndjson (default detected from the file extension)")
+	mappingFlag := flag.String("mapping", config.CSVMappingFile, "Path to the YAML or JSON file mapping CSV header columns to user fields")
+	rejectedFileFlag := flag.String("rejected-file", "", "Path to the CSV file rejected rows are written to (default <filepath>.rejected.csv)")
+	maxErrorRateFlag := flag.Float64("max-error-rate", 1, "Maximum share of rejected rows (0-1) before the run is considered failed")
+	dryRunFlag := flag.Bool("dry-run", false, "Read, parse and validate the input file without connecting to RabbitMQ, messages are written instead of published")
+	dryRunOutputFlag := flag.String("dry-run-output", "", "Path to the NDJSON file dry run messages are written to (default stdout)")
+	checkpointFileFlag := flag.String("checkpoint-file", "", "Path to the file the progress of the run is recorded in (default <filepath>.checkpoint.json)")
+	watchFlag := flag.String("watch", "", "Directory to watch for input files, every new file is published and moved to its done/ or failed/ subdirectory")
+	watchIntervalFlag := flag.Duration("watch-interval", 5*time.Second, "How often the watched directory is polled for new files")
+	resumeFlag := flag.Bool("resume", false, "Continue from the checkpoint of a previous run of the same file instead of the first row")
+	metricsAddrFlag := flag.String("metrics-addr", config.MetricsAddr, "Address to serve Prometheus metrics on, for example :9090 (default disabled)")
+	flag.Parse()
+
+	// dry run messages are written to stdout by default, the logs must not be interleaved with them
+	if *dryRunFlag && *dryRunOutputFlag == "" {
+		for i, path := range config.Logger.OutputPaths {
+			if path == "stdout" {
+				config.Logger.OutputPaths[i] = "stderr"
+			}
+		}
+	}
+
 	// initialize logger
-	logger, err := logger.NewDefaultLogger()
+	logger, _, err := logger.NewFromConfig(config.Logger)
 	if err != nil {
-		panic("failed to initialize logger")
+		panic("failed to initialize logger: " + err.Error())
 	}
 	logger.Info("Logger initialized")
 
-	// filepath flag
-	filepathFlag := flag.String("filepath", "./users.csv", "Path to the CSV file")
-	flag.Parse()
-
 	if filepathFlag == nil {
 		logger.Fatal("no csv file specified")
 	}
@@ -39,32 +71,171 @@ func main() {
 		logger.Error("csv file path can not be empty")
 	}
 
-	// initialize rabbitmq connection
-	rmq, err := rabbitmq.New(config.RabbitMQURL, 10, time.Second*5)
+	format, err := usecase.ParseFormat(*formatFlag)
 	if err != nil {
-		logger.Fatal("failed to initialize RabbitMQ", zap.Error(err))
+		logger.Fatal("invalid input format", zap.Error(err))
 	}
-	defer rmq.Channel.Close()
-	defer rmq.Close()
 
-	// declare queue
-	queue, err := rmq.QueueDeclare(config.QueueName, rabbitmq.WithDurable(true))
+	if *dryRunFlag && *resumeFlag {
+		logger.Fatal("--resume can not be combined with --dry-run")
+	}
+
+	if *dryRunFlag && *watchFlag != "" {
+		logger.Fatal("--watch can not be combined with --dry-run")
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName:    config.ServiceName,
+		ServiceVersion: config.ServiceVersion,
+		Exporter:       config.TracingExporter,
+	})
 	if err != nil {
-		logger.Fatal("failed to declare queue", zap.Error(err))
+		logger.Fatal("failed to set up tracing", zap.Error(err))
+	}
+	defer func() {
+		// flush the spans of the last rows
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush spans", zap.Error(err))
+		}
+	}()
+
+	var queueService interfaces.IQueueService
+	queueName := config.QueueName
+
+	if *dryRunFlag {
+		// dry run writes the messages instead of publishing them, no broker is required
+		output := os.Stdout
+		if *dryRunOutputFlag != "" {
+			output, err = os.Create(*dryRunOutputFlag)
+			if err != nil {
+				logger.Fatal("failed to create dry run output file", zap.Error(err))
+			}
+			defer output.Close()
+		}
+
+		logger.Info("dry run, messages will not be published", zap.String("output", output.Name()))
+		queueService = dryrun.New(output)
+	} else {
+		rmq, queue := connectRabbitMQ(config, logger)
+		defer rmq.Close()
+
+		queueService = rmq
+		queueName = queue.Name
 	}
-	logger.Info("Queue declared", zap.Any("queue", queue))
 
 	// initialize service layer
-	usecase := usecase.New(
-		rmq,
+	usecaseOptions := []usecase.Option{
 		usecase.WithLogger(logger),
-	)
+		usecase.WithInputFormat(format),
+		usecase.WithMaxErrorRate(*maxErrorRateFlag),
+		usecase.WithWatchInterval(*watchIntervalFlag),
+	}
+	if *mappingFlag != "" {
+		mapping, err := usecase.LoadColumnMapping(*mappingFlag)
+		if err != nil {
+			logger.Fatal("failed to load CSV column mapping", zap.Error(err))
+		}
+
+		usecaseOptions = append(usecaseOptions, usecase.WithColumnMapping(mapping))
+	}
+	if *rejectedFileFlag != "" {
+		usecaseOptions = append(usecaseOptions, usecase.WithRejectedRowsFile(*rejectedFileFlag))
+	}
+	if config.PublisherConfirms && !*dryRunFlag {
+		usecaseOptions = append(usecaseOptions, usecase.WithPublisherConfirms(config.PublishRetries))
+	}
+	if !*dryRunFlag && filepath != usecase.StdinPath {
+		// dry runs publish nothing, so they must not move the checkpoint
+		checkpointFile := *checkpointFileFlag
+		if checkpointFile == "" {
+			checkpointFile = usecase.CheckpointPath(filepath)
+		}
+
+		usecaseOptions = append(usecaseOptions, usecase.WithCheckpointFile(checkpointFile))
+		if *resumeFlag {
+			usecaseOptions = append(usecaseOptions, usecase.WithResume())
+		}
+	}
+
+	usecase := usecase.New(queueService, usecaseOptions...)
+
+	// stop reading input on shutdown, rows already read are still published and checkpointed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *metricsAddrFlag != "" {
+		metricsServer := metrics.NewServer(*metricsAddrFlag)
+		go func() {
+			logger.Info("serving metrics", zap.String("addr", *metricsAddrFlag))
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("failed to serve metrics", zap.Error(err))
+			}
+		}()
+		defer metricsServer.Close()
+	}
+
+	if *watchFlag != "" {
+		err = usecase.Watch(ctx, *watchFlag, queueName)
+		if err != nil {
+			logger.Fatal("failed to watch directory", zap.Error(err))
+		}
+
+		logger.Info("Producer stopped")
+		return
+	}
 
-	// publish CSV data to the queue
-	err = usecase.PublishCSVDataToQueue(filepath, queue.Name)
+	// publish input data to the queue
+	summary, err := usecase.PublishFileToQueue(ctx, filepath, queueName)
+	if errors.Is(err, context.Canceled) {
+		logger.Fatal("interrupted before the end of the input, run again with --resume to continue", zap.Any("summary", summary))
+	}
 	if err != nil {
-		logger.Fatal("failed to publish CSV rows to queue", zap.Error(err))
+		logger.Fatal("failed to publish input rows to queue", zap.Error(err))
+	}
+
+	if summary.Failed > 0 {
+		logger.Fatal("failed to publish some input rows to the queue", zap.Any("summary", summary))
 	}
 
-	logger.Info("All CSV rows have been successfully published to the queue")
+	if summary.ErrorRate() > *maxErrorRateFlag {
+		logger.Fatal("rejected input rows exceed the maximum error rate", zap.Any("summary", summary),
+			zap.Float64("errorRate", summary.ErrorRate()), zap.Float64("maxErrorRate", *maxErrorRateFlag))
+	}
+
+	logger.Info("All valid input rows have been successfully published to the queue", zap.Any("summary", summary))
+}
+
+// connectRabbitMQ connects to RabbitMQ and declares the queue messages are published to.
+func connectRabbitMQ(config *config.Config, logger *otelzap.Logger) (*rabbitmq.RabbitMQ, amqp.Queue) {
+	// initialize rabbitmq connection
+	rmqOptions := []rabbitmq.Option{rabbitmq.WithLogger(logger)}
+	if config.PublisherConfirms {
+		rmqOptions = append(rmqOptions, rabbitmq.WithConfirms())
+	}
+
+	rmq, err := rabbitmq.New(config.RabbitMQURL, 10, time.Second*5, rmqOptions...)
+	if err != nil {
+		logger.Fatal("failed to initialize RabbitMQ", zap.Error(err))
+	}
+
+	// log connection state changes, the connection is re-established automatically
+	go func() {
+		for event := range rmq.NotifyState(make(chan rabbitmq.StateEvent, 10)) {
+			logger.Info("RabbitMQ connection state changed", zap.Stringer("state", event.State), zap.Int("attempt", event.Attempt), zap.Error(event.Err))
+		}
+	}()
+
+	// declare queue
+	// arguments must match the consumer's declaration of the same queue
+	queue, err := rmq.QueueDeclare(config.QueueName, rabbitmq.WithDurable(true),
+		rabbitmq.WithDeadLetterExchange("", rabbitmq.DeadLetterQueueName(config.QueueName)))
+	if err != nil {
+		logger.Fatal("failed to declare queue", zap.Error(err))
+	}
+	logger.Info("Queue declared", zap.Any("queue", queue))
+
+	return rmq, queue
 }
@@ -0,0 +1,207 @@
+package usecase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCheckpointInterval = time.Second
+)
+
+var (
+	ErrCheckpointMismatch = errors.New("checkpoint does not match the input file")
+)
+
+// CheckpointPath returns the default path of the checkpoint file of the given input file.
+func CheckpointPath(inputPath string) string {
+	return strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".checkpoint.json"
+}
+
+// Checkpoint records how far an input file has been processed.
+// Every row before Offset has been published ( confirmed by the broker when publisher confirms are enabled ) or rejected.
+type Checkpoint struct {
+	// input file the checkpoint belongs to, a resumed run refuses a file which changed since
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	// Offset is the byte offset right after the last processed row, in the uncompressed input
+	Offset int64 `json:"offset"`
+	// Line is the line number of the last processed row
+	Line      int       `json:"line"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LoadCheckpoint reads a checkpoint file, it returns os.ErrNotExist if no checkpoint has been written yet.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint Checkpoint
+	err = json.Unmarshal(data, &checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint file: %w", err)
+	}
+
+	return &checkpoint, nil
+}
+
+// Save writes the checkpoint atomically, a crash while saving leaves the previous checkpoint in place.
+func (cp *Checkpoint) Save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Matches reports whether the checkpoint has been written for the given, unchanged, input file.
+func (cp *Checkpoint) Matches(info os.FileInfo) bool {
+	return cp.Size == info.Size() && cp.ModTime.Equal(info.ModTime())
+}
+
+// checkpointTracker advances the checkpoint as rows are completed.
+// Rows are published concurrently and complete out of order, so the checkpoint only moves past a row
+// once every row before it has completed as well. A row which failed to publish holds the checkpoint
+// back for the rest of the run, so a resumed run publishes it again.
+// A nil tracker ignores every call, it is used when checkpointing is disabled.
+type checkpointTracker struct {
+	path     string
+	interval time.Duration
+
+	mu         sync.Mutex
+	checkpoint Checkpoint
+	// sequence number of the next row the checkpoint waits for
+	next uint64
+	// rows completed ahead of next
+	completed map[uint64]checkpointRow
+	// sequence number of the first failed row, if any
+	failedSeq *uint64
+	dirty     bool
+	savedAt   time.Time
+}
+
+type checkpointRow struct {
+	offset int64
+	line   int
+}
+
+func newCheckpointTracker(path string, checkpoint Checkpoint, interval time.Duration) *checkpointTracker {
+	return &checkpointTracker{
+		path:       path,
+		interval:   interval,
+		checkpoint: checkpoint,
+		completed:  make(map[uint64]checkpointRow),
+		savedAt:    time.Now(),
+	}
+}
+
+// Complete marks the row with the given sequence number as processed, offset is the byte offset right after it.
+// Once the checkpoint advanced it is saved, at most once per interval.
+func (t *checkpointTracker) Complete(seq uint64, offset int64, line int) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.failedSeq != nil && seq > *t.failedSeq {
+		return nil // the checkpoint can not move past the failed row anymore
+	}
+
+	t.completed[seq] = checkpointRow{offset: offset, line: line}
+	for {
+		row, ok := t.completed[t.next]
+		if !ok {
+			break
+		}
+
+		delete(t.completed, t.next)
+		t.checkpoint.Offset = row.offset
+		t.checkpoint.Line = row.line
+		t.next++
+		t.dirty = true
+	}
+
+	if !t.dirty || time.Since(t.savedAt) < t.interval {
+		return nil
+	}
+
+	return t.save()
+}
+
+// Fail marks the row with the given sequence number as failed.
+func (t *checkpointTracker) Fail(seq uint64) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.failedSeq == nil || seq < *t.failedSeq {
+		t.failedSeq = &seq
+	}
+
+	// rows completed after the failed one are no longer needed
+	for completed := range t.completed {
+		if completed > seq {
+			delete(t.completed, completed)
+		}
+	}
+}
+
+// Flush saves the checkpoint if it advanced since the last save.
+func (t *checkpointTracker) Flush() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.dirty {
+		return nil
+	}
+
+	return t.save()
+}
+
+// Checkpoint returns the current checkpoint.
+func (t *checkpointTracker) Checkpoint() Checkpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.checkpoint
+}
+
+func (t *checkpointTracker) save() error {
+	t.checkpoint.UpdatedAt = time.Now()
+
+	err := t.checkpoint.Save(t.path)
+	if err != nil {
+		return err
+	}
+
+	t.dirty = false
+	t.savedAt = time.Now()
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package usecase_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals/core/dto"
+	"github.com/viswals/core/infrastructure/rabbitmq"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+	"github.com/viswals/producer/usecase"
+)
+
+func TestPublishCSVDataToQueue_ResumeFromCheckpoint(t *testing.T) {
+	csvContent := `id,first_name,last_name,email,created_at,deleted_at,merged_at,parent_user_id
+1,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1
+2,Emily,Tamm,EmilyTamm@gmail.edu,1361367320000,-1,-1,-1
+3,Arlo,Berg,ArloBerg@gmail.edu,1361367320000,-1,-1,-1
+`
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "users.csv")
+	require.NoError(t, os.WriteFile(csvFile, []byte(csvContent), 0644))
+	checkpointFile := usecase.CheckpointPath(csvFile)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	// first run, the row with id 2 fails to publish so the checkpoint stays after the row with id 1
+	failingQueue := mock_interfaces.NewMockIQueueService(ctrl)
+	failingQueue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, options ...rabbitmq.PublishOption) error {
+			if publishedId(options) == 2 {
+				return errors.New("connection lost")
+			}
+			return nil
+		}).Times(3)
+
+	producer := usecase.New(failingQueue, usecase.WithLogger(mockLogger), usecase.WithCheckpointFile(checkpointFile))
+	summary, err := producer.PublishCSVDataToQueue(csvFile, "test_queue")
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+
+	checkpoint, err := usecase.LoadCheckpoint(checkpointFile)
+	require.NoError(t, err)
+	assert.Equal(t, 2, checkpoint.Line)
+
+	// resumed run starts from the failed row
+	var mu sync.Mutex
+	var ids []int64
+	queue := mock_interfaces.NewMockIQueueService(ctrl)
+	queue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, options ...rabbitmq.PublishOption) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ids = append(ids, publishedId(options))
+			return nil
+		}).Times(2)
+
+	producer = usecase.New(queue, usecase.WithLogger(mockLogger), usecase.WithCheckpointFile(checkpointFile), usecase.WithResume())
+	summary, err = producer.PublishCSVDataToQueue(csvFile, "test_queue")
+	require.NoError(t, err)
+	assert.Equal(t, usecase.PublishSummary{Read: 2, Valid: 2, Published: 2}, summary)
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	assert.Equal(t, []int64{2, 3}, ids)
+
+	checkpoint, err = usecase.LoadCheckpoint(checkpointFile)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(csvContent)), checkpoint.Offset)
+	assert.Equal(t, 4, checkpoint.Line)
+
+	// the input file changed since the checkpoint was written
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(csvFile, later, later))
+
+	_, err = producer.PublishCSVDataToQueue(csvFile, "test_queue")
+	assert.ErrorIs(t, err, usecase.ErrCheckpointMismatch)
+}
+
+func publishedId(options []rabbitmq.PublishOption) int64 {
+	var opts rabbitmq.PublishOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	var user dto.RawUserData
+	json.Unmarshal(opts.Body, &user)
+
+	return user.Id
+}
@@ -0,0 +1,293 @@
+package usecase
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/viswals/core/dto"
+)
+
+// StdinPath is the input path reading the rows from the standard input.
+const StdinPath = "-"
+
+// Format is the format of the rows of an input file.
+type Format string
+
+const (
+	// FormatAuto detects the format from the extension of the input file.
+	FormatAuto Format = ""
+	// FormatCSV reads CSV files with a header row, see ColumnMapping.
+	FormatCSV Format = "csv"
+	// FormatNDJSON reads files holding one JSON encoded dto.RawUserData per line.
+	FormatNDJSON Format = "ndjson"
+)
+
+// ParseFormat parses the name of a format, an empty name detects the format automatically.
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case FormatAuto, FormatCSV, FormatNDJSON:
+		return format, nil
+	case "jsonl":
+		return FormatNDJSON, nil
+	}
+
+	return FormatAuto, fmt.Errorf("unknown input format %q, expected csv or ndjson", name)
+}
+
+// DetectFormat returns the format of the input file from its extension, ignoring a .gz suffix.
+// The standard input and unknown extensions are read as CSV.
+func DetectFormat(path string) Format {
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(strings.ToLower(path), ".gz")))
+
+	switch ext {
+	case ".ndjson", ".jsonl", ".json":
+		return FormatNDJSON
+	}
+
+	return FormatCSV
+}
+
+// InputReader reads the rows of an input file.
+type InputReader interface {
+	// Header returns the column names of the rows, used for the rejected rows file.
+	Header() []string
+	// Next returns the next row, or io.EOF once the input is exhausted.
+	// A row which can not be read is returned with a *RowError, the following rows can still be read.
+	Next() (Row, error)
+	// Parse converts a row to user data.
+	Parse(row Row) (dto.RawUserData, error)
+	// Id returns the id of the row without parsing the other fields.
+	Id(row Row) (int64, error)
+}
+
+// Row is a single row of an input file.
+type Row struct {
+	// Line is the line number the row starts at
+	Line int
+	// Offset is the byte offset right after the row, in the uncompressed input
+	Offset int64
+	// Record holds the raw fields of the row, written to the rejected rows file
+	Record []string
+}
+
+// RowError reports a row which can not be read.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// openInput opens the input file, gzip compressed files are decompressed transparently.
+func (c *ProducerUsecase) openInput(path string, format Format) (InputReader, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closer := io.Closer(file)
+	buffered := bufio.NewReader(file)
+
+	// gzip streams are detected from their magic number, so compressed files work whatever their name
+	magic, _ := buffered.Peek(2)
+	var reader io.Reader = buffered
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to read gzip input: %w", err)
+		}
+
+		reader = gz
+		closer = multiCloser{gz, file}
+	}
+
+	if format == FormatAuto {
+		format = DetectFormat(path)
+	}
+
+	var input InputReader
+	switch format {
+	case FormatNDJSON:
+		input = newNDJSONReader(reader)
+	default:
+		input, err = newCSVReader(reader, c.columnMapping, c.ParseCSVRecordToUserData)
+	}
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+
+	return input, closer, nil
+}
+
+// spoolStdin copies the standard input to a temporary file, which is read twice to validate the parent user ids.
+func spoolStdin() (string, error) {
+	file, err := os.CreateTemp("", "producer-stdin-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to buffer standard input: %w", err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, os.Stdin)
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to buffer standard input: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+type multiCloser []io.Closer
+
+func (closers multiCloser) Close() error {
+	var errs []error
+	for _, closer := range closers {
+		errs = append(errs, closer.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// csvReader reads CSV files, the header row locates the mapped columns.
+type csvReader struct {
+	reader  *csv.Reader
+	header  []string
+	columns ColumnIndex
+	parse   func(record []string, columns ColumnIndex) (dto.RawUserData, error)
+}
+
+func newCSVReader(r io.Reader, mapping ColumnMapping, parse func([]string, ColumnIndex) (dto.RawUserData, error)) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // records may miss trailing optional columns
+
+	// locate the mapped columns using the header row
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns, err := mapping.Index(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csvReader{
+		reader:  reader,
+		header:  header,
+		columns: columns,
+		parse:   parse,
+	}, nil
+}
+
+func (r *csvReader) Header() []string {
+	return r.header
+}
+
+func (r *csvReader) Next() (Row, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return Row{}, err
+	}
+
+	row := Row{Offset: r.reader.InputOffset(), Record: record}
+
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			row.Line = parseErr.StartLine
+			return row, &RowError{Line: row.Line, Err: err}
+		}
+
+		return row, err
+	}
+
+	row.Line, _ = r.reader.FieldPos(0)
+
+	return row, nil
+}
+
+func (r *csvReader) Parse(row Row) (dto.RawUserData, error) {
+	return r.parse(row.Record, r.columns)
+}
+
+func (r *csvReader) Id(row Row) (int64, error) {
+	return strconv.ParseInt(r.columns.Value(row.Record, FieldId), 10, 64)
+}
+
+// ndjsonReader reads files holding one JSON object per line, blank lines are skipped.
+type ndjsonReader struct {
+	reader *bufio.Reader
+	line   int
+	offset int64
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	return &ndjsonReader{
+		reader: bufio.NewReader(r),
+	}
+}
+
+func (r *ndjsonReader) Header() []string {
+	return []string{"record"}
+}
+
+func (r *ndjsonReader) Next() (Row, error) {
+	for {
+		// ReadBytes has no line length limit, unlike bufio.Scanner
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return Row{}, err
+		}
+		if err != nil && err != io.EOF {
+			return Row{}, err
+		}
+
+		r.line++
+		r.offset += int64(len(line))
+
+		record := strings.TrimSpace(string(line))
+		if record == "" {
+			continue
+		}
+
+		return Row{Line: r.line, Offset: r.offset, Record: []string{record}}, nil
+	}
+}
+
+func (r *ndjsonReader) Parse(row Row) (dto.RawUserData, error) {
+	var user dto.RawUserData
+
+	err := json.Unmarshal([]byte(row.Record[0]), &user)
+	if err != nil {
+		return user, fmt.Errorf("invalid JSON record: %w", err)
+	}
+
+	return user, nil
+}
+
+func (r *ndjsonReader) Id(row Row) (int64, error) {
+	var user struct {
+		Id int64 `json:"id"`
+	}
+
+	err := json.Unmarshal([]byte(row.Record[0]), &user)
+
+	return user.Id, err
+}
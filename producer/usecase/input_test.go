@@ -0,0 +1,148 @@
+package usecase_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+	"github.com/viswals/producer/usecase"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected usecase.Format
+	}{
+		{path: "users.csv", expected: usecase.FormatCSV},
+		{path: "users.csv.gz", expected: usecase.FormatCSV},
+		{path: "users.ndjson", expected: usecase.FormatNDJSON},
+		{path: "USERS.JSONL.GZ", expected: usecase.FormatNDJSON},
+		{path: "users.json", expected: usecase.FormatNDJSON},
+		{path: usecase.StdinPath, expected: usecase.FormatCSV},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			assert.Equal(t, test.expected, usecase.DetectFormat(test.path))
+		})
+	}
+}
+
+func TestPublishCSVDataToQueue_InputFormats(t *testing.T) {
+	csvContent := `id,first_name,last_name,email,created_at,deleted_at,merged_at,parent_user_id
+8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1
+31,Emily,Tamm,EmilyTamm@gmail.edu,1361367320000,-1,-1,8
+`
+	ndjsonContent := `{"Id":8,"FirstName":"Hanah","LastName":"Schmidt","Email":"Hanah_Schmidt1965@gmail.edu","CreatedAt":1361218223000,"DeletedAt":-1,"MergedAt":-1,"ParentUserId":-1}
+
+{"Id":31,"FirstName":"Emily","LastName":"Tamm","Email":"EmilyTamm@gmail.edu","CreatedAt":1361367320000,"DeletedAt":-1,"MergedAt":-1,"ParentUserId":8}
+{"Id":"not a number"}`
+
+	tests := []struct {
+		name     string
+		file     string
+		content  []byte
+		format   usecase.Format
+		expected usecase.PublishSummary
+	}{
+		{
+			name:     "gzip compressed CSV",
+			file:     "users.csv.gz",
+			content:  gzipped(t, csvContent),
+			expected: usecase.PublishSummary{Read: 2, Valid: 2, Published: 2},
+		},
+		{
+			name:     "NDJSON",
+			file:     "users.ndjson",
+			content:  []byte(ndjsonContent),
+			expected: usecase.PublishSummary{Read: 3, Valid: 2, Rejected: 1, Published: 2},
+		},
+		{
+			name:     "gzip compressed NDJSON",
+			file:     "users.jsonl.gz",
+			content:  gzipped(t, ndjsonContent),
+			expected: usecase.PublishSummary{Read: 3, Valid: 2, Rejected: 1, Published: 2},
+		},
+		{
+			name:     "format flag overrides the extension",
+			file:     "users.txt",
+			content:  []byte(ndjsonContent),
+			format:   usecase.FormatNDJSON,
+			expected: usecase.PublishSummary{Read: 3, Valid: 2, Rejected: 1, Published: 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, test.file)
+			require.NoError(t, os.WriteFile(path, test.content, 0644))
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockLogger := mock_interfaces.NewMockILogger(ctrl)
+			mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+			mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+			mockQueue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).Return(nil).Times(int(test.expected.Published))
+
+			producer := usecase.New(mockQueue, usecase.WithLogger(mockLogger), usecase.WithInputFormat(test.format),
+				usecase.WithRejectedRowsFile(filepath.Join(dir, "rejected.csv")))
+
+			summary, err := producer.PublishCSVDataToQueue(path, "test_queue")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, summary)
+		})
+	}
+}
+
+func TestPublishCSVDataToQueue_Stdin(t *testing.T) {
+	csvContent := `id,first_name,last_name,email,created_at,deleted_at,merged_at,parent_user_id
+8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1
+`
+	dir := t.TempDir()
+	stdinFile := filepath.Join(dir, "stdin")
+	require.NoError(t, os.WriteFile(stdinFile, gzipped(t, csvContent), 0644))
+
+	stdin, err := os.Open(stdinFile)
+	require.NoError(t, err)
+	defer stdin.Close()
+
+	originalStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = originalStdin }()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+
+	mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+	mockQueue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+	producer := usecase.New(mockQueue, usecase.WithLogger(mockLogger), usecase.WithRejectedRowsFile(filepath.Join(dir, "rejected.csv")))
+
+	summary, err := producer.PublishCSVDataToQueue(usecase.StdinPath, "test_queue")
+	assert.NoError(t, err)
+	assert.Equal(t, usecase.PublishSummary{Read: 1, Valid: 1, Published: 1}, summary)
+}
+
+func gzipped(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	return buf.Bytes()
+}
@@ -0,0 +1,150 @@
+package usecase
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fields of dto.RawUserData which can be mapped to CSV columns.
+const (
+	FieldId           = "id"
+	FieldFirstName    = "first_name"
+	FieldLastName     = "last_name"
+	FieldEmail        = "email"
+	FieldCreatedAt    = "created_at"
+	FieldDeletedAt    = "deleted_at"
+	FieldMergedAt     = "merged_at"
+	FieldParentUserId = "parent_user_id"
+)
+
+var knownFields = []string{FieldId, FieldFirstName, FieldLastName, FieldEmail, FieldCreatedAt, FieldDeletedAt, FieldMergedAt, FieldParentUserId}
+
+// ColumnMapping maps the user data fields to the CSV header names which can hold them.
+// Header names are matched case insensitively, columns which are not mapped are ignored.
+type ColumnMapping struct {
+	// Columns lists the accepted header names for every field, the first one found in the header is used.
+	Columns map[string][]string `json:"columns" yaml:"columns"`
+	// Required lists the fields which must be present in the header.
+	Required []string `json:"required" yaml:"required"`
+}
+
+// ColumnIndex holds the position of every mapped field in the CSV records.
+type ColumnIndex map[string]int
+
+// DefaultColumnMapping returns the mapping used when no mapping file is configured.
+func DefaultColumnMapping() ColumnMapping {
+	return ColumnMapping{
+		Columns: map[string][]string{
+			FieldId:           {"id", "user_id"},
+			FieldFirstName:    {"first_name", "firstname"},
+			FieldLastName:     {"last_name", "lastname"},
+			FieldEmail:        {"email", "email_address"},
+			FieldCreatedAt:    {"created_at"},
+			FieldDeletedAt:    {"deleted_at"},
+			FieldMergedAt:     {"merged_at"},
+			FieldParentUserId: {"parent_user_id", "parent_id"},
+		},
+		Required: []string{FieldId, FieldEmail},
+	}
+}
+
+// LoadColumnMapping reads a column mapping from a YAML or JSON file.
+func LoadColumnMapping(path string) (ColumnMapping, error) {
+	var mapping ColumnMapping
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return mapping, fmt.Errorf("failed to read column mapping file: %w", err)
+	}
+
+	// YAML is a superset of JSON, so both formats are parsed the same way
+	err = yaml.Unmarshal(data, &mapping)
+	if err != nil {
+		return mapping, fmt.Errorf("failed to parse column mapping file: %w", err)
+	}
+
+	err = mapping.Validate()
+	if err != nil {
+		return mapping, err
+	}
+
+	return mapping, nil
+}
+
+// Validate ensures the mapping only refers to known fields and maps every required field.
+func (m ColumnMapping) Validate() error {
+	for field := range m.Columns {
+		if !isKnownField(field) {
+			return fmt.Errorf("unknown field %q in column mapping", field)
+		}
+	}
+
+	for _, field := range m.Required {
+		if !isKnownField(field) {
+			return fmt.Errorf("unknown required field %q in column mapping", field)
+		}
+
+		if len(m.Columns[field]) == 0 {
+			return fmt.Errorf("required field %q has no column in mapping", field)
+		}
+	}
+
+	return nil
+}
+
+// Index finds the position of the mapped fields in the CSV header.
+// It returns an error if a required field has no column in the header.
+func (m ColumnMapping) Index(header []string) (ColumnIndex, error) {
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff") // byte order mark written by some spreadsheet exports
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, exists := positions[name]; !exists {
+			positions[name] = i
+		}
+	}
+
+	index := make(ColumnIndex)
+	for field, names := range m.Columns {
+		for _, name := range names {
+			if position, ok := positions[strings.ToLower(strings.TrimSpace(name))]; ok {
+				index[field] = position
+				break
+			}
+		}
+	}
+
+	for _, field := range m.Required {
+		if _, ok := index[field]; !ok {
+			return nil, fmt.Errorf("required column %q is missing from the CSV header, expected one of %v", field, m.Columns[field])
+		}
+	}
+
+	return index, nil
+}
+
+// Value returns the value of the field in the record, or an empty string if the field is not mapped or missing.
+func (ci ColumnIndex) Value(record []string, field string) string {
+	position, ok := ci[field]
+	if !ok || position >= len(record) {
+		return ""
+	}
+
+	return strings.TrimSpace(record[position])
+}
+
+func isKnownField(field string) bool {
+	for _, known := range knownFields {
+		if field == known {
+			return true
+		}
+	}
+
+	return false
+}
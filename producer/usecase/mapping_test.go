@@ -0,0 +1,125 @@
+package usecase_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals/producer/usecase"
+)
+
+func TestColumnMappingIndex(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      []string
+		expected    usecase.ColumnIndex
+		expectedErr bool
+	}{
+		{
+			name:   "reordered columns",
+			header: []string{"email", "id", "last_name", "first_name"},
+			expected: usecase.ColumnIndex{
+				usecase.FieldEmail:     0,
+				usecase.FieldId:        1,
+				usecase.FieldLastName:  2,
+				usecase.FieldFirstName: 3,
+			},
+		},
+		{
+			name:   "renamed and extra columns",
+			header: []string{"\ufeffUser_Id", "country", " FirstName ", "email_address", "parent_id"},
+			expected: usecase.ColumnIndex{
+				usecase.FieldId:           0,
+				usecase.FieldFirstName:    2,
+				usecase.FieldEmail:        3,
+				usecase.FieldParentUserId: 4,
+			},
+		},
+		{
+			name:        "missing required column",
+			header:      []string{"id", "first_name", "last_name"},
+			expectedErr: true,
+		},
+	}
+
+	mapping := usecase.DefaultColumnMapping()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index, err := mapping.Index(test.header)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, index)
+		})
+	}
+}
+
+func TestParseCSVRecordToUserData_MissingColumns(t *testing.T) {
+	producer := usecase.New(nil)
+
+	columns, err := usecase.DefaultColumnMapping().Index([]string{"email", "merged_at", "id"})
+	assert.NoError(t, err)
+
+	user, err := producer.ParseCSVRecordToUserData([]string{"john@example.com", "1361218223000", "42"}, columns)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), user.Id)
+	assert.Equal(t, "john@example.com", user.Email)
+	assert.Equal(t, int64(1361218223000), user.MergedAt)
+	assert.Equal(t, int64(0), user.DeletedAt)
+	assert.Equal(t, "", user.FirstName)
+}
+
+func TestLoadColumnMapping(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		content     string
+		expectedErr bool
+	}{
+		{
+			name:    "yaml mapping",
+			file:    "mapping.yaml",
+			content: "columns:\n  id: [user_id]\n  email: [mail]\nrequired: [id, email]\n",
+		},
+		{
+			name:    "json mapping",
+			file:    "mapping.json",
+			content: `{"columns": {"id": ["user_id"], "email": ["mail"]}, "required": ["id"]}`,
+		},
+		{
+			name:        "unknown field",
+			file:        "unknown.yaml",
+			content:     "columns:\n  phone: [phone]\n",
+			expectedErr: true,
+		},
+		{
+			name:        "required field without column",
+			file:        "required.yaml",
+			content:     "columns:\n  id: [id]\nrequired: [email]\n",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			mapping, err := usecase.LoadColumnMapping(path)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"user_id"}, mapping.Columns[usecase.FieldId])
+		})
+	}
+}
@@ -2,18 +2,33 @@ package usecase
 
 import (
 	"context"
-	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/viswals/core/dto"
 	"github.com/viswals/core/infrastructure/rabbitmq"
+	"github.com/viswals/core/pkg/metrics"
+	"github.com/viswals/core/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-func (c *ProducerUsecase) PublishUserDataToQueue(ctx context.Context, queue string, userdata dto.RawUserData) error {
+// PublishUserDataToQueue publishes the user as a JSON message, the trace context of ctx is propagated in its headers.
+func (c *ProducerUsecase) PublishUserDataToQueue(ctx context.Context, queue string, userdata dto.RawUserData) (err error) {
+	ctx, span := tracing.Start(ctx, "publish "+queue, trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		semconv.MessagingSystemRabbitmq,
+		semconv.MessagingDestinationName(queue),
+		attribute.Int64("user.source_id", userdata.Id),
+	))
+	defer func() { tracing.End(span, err) }()
 
 	message, err := json.Marshal(userdata)
 	if err != nil {
@@ -22,29 +37,159 @@ func (c *ProducerUsecase) PublishUserDataToQueue(ctx context.Context, queue stri
 	}
 
 	// publish message to RabbitMQ queue
-	err = c.rmq.PublishWithContext(ctx, rabbitmq.WithContentType("application/json"), rabbitmq.WithBody(message), rabbitmq.WithRoutingKey(queue))
+	if c.confirms {
+		err = c.publishWithConfirm(ctx, queue, message)
+	} else {
+		err = c.rmq.PublishWithContext(ctx, rabbitmq.WithContentType("application/json"), rabbitmq.WithBody(message), rabbitmq.WithRoutingKey(queue),
+			rabbitmq.WithTraceContext(ctx))
+	}
 	if err != nil {
 		c.logger.Error("failed to publish message to queue", zap.Error(err))
 		return err
 	}
 
-	c.logger.Info("message published to queue", zap.String("user", string(message)))
+	c.logger.Info("message published to queue", zap.Int64("source_id", userdata.Id))
 
 	return nil
 }
 
-func (c *ProducerUsecase) PublishCSVDataToQueue(filepath string, queue string) error {
-	file, err := os.Open(filepath)
+// publishWithConfirm publishes a persistent, mandatory message and waits for the broker to confirm it.
+// Nacked messages are published again, unroutable ones are not as they would be returned again.
+func (c *ProducerUsecase) publishWithConfirm(ctx context.Context, queue string, message []byte) error {
+	var err error
+
+	for attempt := 0; attempt <= c.publishRetries; attempt++ {
+		if attempt > 0 {
+			c.logger.Warn("retrying message publish", zap.Int("attempt", attempt), zap.Error(err))
+			time.Sleep(c.retryDelay)
+		}
+
+		err = c.rmq.PublishWithConfirm(ctx,
+			rabbitmq.WithContentType("application/json"),
+			rabbitmq.WithBody(message),
+			rabbitmq.WithRoutingKey(queue),
+			rabbitmq.WithMandatory(true),
+			rabbitmq.WithPersistent(true),
+			rabbitmq.WithTraceContext(ctx),
+		)
+		if err == nil || errors.Is(err, rabbitmq.ErrReturned) || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// inputRow is a valid row of the input file waiting to be published.
+type inputRow struct {
+	// seq is the position of the row in this run, used to track the checkpoint
+	seq    uint64
+	offset int64
+	line   int
+	user   dto.RawUserData
+	// ctx carries the span of the row, it ends once the row is published
+	ctx  context.Context
+	span trace.Span
+}
+
+// PublishCSVDataToQueue reads, validates and publishes every row of the input file.
+// Besides CSV, NDJSON files, gzip compressed files and the standard input ( StdinPath ) are read, see WithInputFormat.
+// Rejected rows are written with their line number and reason to the rejected rows file.
+// With a checkpoint file, the progress is recorded so an interrupted run can be resumed, see WithResume.
+func (c *ProducerUsecase) PublishCSVDataToQueue(filepath string, queue string) (PublishSummary, error) {
+	return c.PublishFileToQueue(context.Background(), filepath, queue)
+}
+
+// PublishFileToQueue works like PublishCSVDataToQueue, it stops reading the input once the context is done.
+// Rows already read are still published and recorded in the checkpoint, so the run can be resumed.
+func (c *ProducerUsecase) PublishFileToQueue(ctx context.Context, filepath string, queue string) (PublishSummary, error) {
+	return c.publishFile(ctx, filepath, queue, runSettings{
+		rejectedPath:   c.rejectedRowsFile,
+		checkpointFile: c.checkpointFile,
+		resume:         c.resume,
+	})
+}
+
+// runSettings holds the files of a single run.
+type runSettings struct {
+	rejectedPath   string
+	checkpointFile string
+	resume         bool
+}
+
+func (c *ProducerUsecase) publishFile(ctx context.Context, filepath string, queue string, run runSettings) (summary PublishSummary, err error) {
+	inputPath := filepath
+	rejectedPath := run.rejectedPath
+	checkpointFile := run.checkpointFile
+
+	if filepath == StdinPath {
+		// the standard input can only be read once, keep a copy to read it again
+		inputPath, err = spoolStdin()
+		if err != nil {
+			c.logger.Error("failed to read standard input", zap.Error(err))
+			return summary, err
+		}
+		defer os.Remove(inputPath)
+
+		if rejectedPath == "" {
+			rejectedPath = "stdin.rejected.csv"
+		}
+
+		// nothing identifies the input of a previous run
+		checkpointFile = ""
+	}
+
+	if rejectedPath == "" {
+		rejectedPath = RejectedRowsPath(filepath)
+	}
+
+	reader, closer, err := c.openInput(inputPath, c.inputFormat)
 	if err != nil {
-		c.logger.Error("failed to open CSV file", zap.Error(err))
-		return err
+		c.logger.Error("failed to open input file", zap.Error(err))
+		return summary, err
 	}
-	defer file.Close()
+	defer closer.Close()
 
-	reader := csv.NewReader(file)
+	// ids of the file are required to validate parent user ids
+	knownIds, err := c.collectIds(inputPath)
+	if err != nil {
+		c.logger.Error("failed to collect input ids", zap.Error(err))
+		return summary, err
+	}
+
+	// rows up to the checkpoint have been processed by a previous run
+	var resumeOffset int64
+	var tracker *checkpointTracker
+
+	if checkpointFile != "" {
+		checkpoint, err := c.loadCheckpoint(checkpointFile, filepath, run.resume)
+		if err != nil {
+			c.logger.Error("failed to load checkpoint", zap.String("checkpoint", checkpointFile), zap.Error(err))
+			return summary, err
+		}
+
+		if checkpoint.Offset > 0 {
+			resumeOffset = checkpoint.Offset
+			c.logger.Info("resuming input file from checkpoint", zap.Int64("offset", checkpoint.Offset), zap.Int("line", checkpoint.Line))
+		}
+
+		tracker = newCheckpointTracker(checkpointFile, checkpoint, c.checkpointInterval)
+	} else if run.resume {
+		return summary, errors.New("resuming requires a checkpoint file")
+	}
+
+	rejected := newRejectedRowsWriter(rejectedPath, reader.Header(), run.resume)
+
+	// rows are processed once published or rejected, the checkpoint moves past them
+	complete := func(seq uint64, offset int64, line int) {
+		err := tracker.Complete(seq, offset, line)
+		if err != nil {
+			c.logger.Error("failed to save checkpoint", zap.Error(err))
+		}
+	}
 
 	// buffered channel to hold users data
-	userDataCh := make(chan dto.RawUserData, 100)
+	rowCh := make(chan inputRow, 100)
 
 	// number of worker goroutines
 	workerCount := 5
@@ -52,29 +197,95 @@ func (c *ProducerUsecase) PublishCSVDataToQueue(filepath string, queue string) e
 	// waitgroup to track goroutines
 	var wg sync.WaitGroup
 
-	// goroutine for reading data from CSV file and writing data back into the channel
+	// counters shared by worker goroutines
+	var published, failed atomic.Int64
+
+	// error which stopped reading the input, if any
+	var readErr error
+
+	// goroutine for reading data from the input file and writing data back into the channel
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(userDataCh) // close channel when done reading
+		defer close(rowCh) // close channel when done reading
+
+		reject := func(line int, reason error, record []string) {
+			summary.Rejected++
+			metrics.Rows.WithLabelValues(metrics.RowRejected).Inc()
+
+			err := rejected.Write(line, reason.Error(), record)
+			if err != nil {
+				c.logger.Error("failed to write rejected record", zap.Error(err))
+			}
+		}
 
-		for {
-			record, err := reader.Read()
+		// rows already read are published after the context is done, so their spans must outlive it
+		rowsCtx := context.WithoutCancel(ctx)
+
+		for seq := uint64(0); ; {
+			// stop reading on shutdown, the rows already read are still published
+			if ctx.Err() != nil {
+				readErr = ctx.Err()
+				return
+			}
+
+			row, err := reader.Next()
 			if err == io.EOF {
 				break
 			}
+
+			var rowErr *RowError
+			if err != nil && !errors.As(err, &rowErr) {
+				c.logger.Error("failed to read input file", zap.Error(err))
+				readErr = err
+				return
+			}
+
+			// skip the rows processed by the previous run
+			if row.Offset <= resumeOffset {
+				continue
+			}
+
+			summary.Read++
+			metrics.Rows.WithLabelValues(metrics.RowRead).Inc()
+			rowSeq := seq
+			seq++
+
+			// every row starts its own trace, which the consumer continues up to the database
+			rowCtx, span := tracing.Start(rowsCtx, "process row", trace.WithNewRoot(), trace.WithAttributes(
+				attribute.String("input.file", filepath),
+				attribute.Int("input.line", row.Line),
+			))
+
 			if err != nil {
-				c.logger.Error("failed to read CSV record", zap.Error(err))
+				c.logger.Error("failed to read record", zap.Error(err))
+				reject(row.Line, err, row.Record)
+				complete(rowSeq, row.Offset, row.Line)
+				tracing.End(span, err)
 				continue
 			}
 
-			userData, err := c.ParseCSVRecordToUserData(record)
+			userData, err := reader.Parse(row)
 			if err != nil {
-				c.logger.Error("failed to parse CSV record", zap.Error(err))
+				c.logger.Error("failed to parse record", zap.Int("line", row.Line), zap.Error(err))
+				reject(row.Line, err, row.Record)
+				complete(rowSeq, row.Offset, row.Line)
+				tracing.End(span, err)
 				continue
 			}
+			metrics.Rows.WithLabelValues(metrics.RowParsed).Inc()
 
-			userDataCh <- userData
+			err = ValidateUserData(userData, knownIds)
+			if err != nil {
+				c.logger.Error("record failed validation", zap.Int("line", row.Line), zap.Int64("source_id", userData.Id), zap.Error(err))
+				reject(row.Line, err, row.Record)
+				complete(rowSeq, row.Offset, row.Line)
+				tracing.End(span, err)
+				continue
+			}
+
+			summary.Valid++
+			rowCh <- inputRow{seq: rowSeq, offset: row.Offset, line: row.Line, user: userData, ctx: rowCtx, span: span}
 		}
 	}()
 
@@ -83,11 +294,18 @@ func (c *ProducerUsecase) PublishCSVDataToQueue(filepath string, queue string) e
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			for userData := range userDataCh {
-				err := c.PublishUserDataToQueue(context.Background(), queue, userData)
+			for row := range rowCh {
+				err := c.PublishUserDataToQueue(row.ctx, queue, row.user)
+				tracing.End(row.span, err)
 				if err != nil {
-					c.logger.Error("failed to publish user data to queue", zap.Error(err))
+					failed.Add(1)
+					metrics.Rows.WithLabelValues(metrics.RowFailed).Inc()
+					tracker.Fail(row.seq)
+					c.logger.Error("failed to publish user data to queue", zap.Int("line", row.line), zap.Error(err))
 				} else {
+					published.Add(1)
+					metrics.Rows.WithLabelValues(metrics.RowPublished).Inc()
+					complete(row.seq, row.offset, row.line)
 					c.logger.Info("successfully published user data to queue")
 				}
 			}
@@ -95,6 +313,102 @@ func (c *ProducerUsecase) PublishCSVDataToQueue(filepath string, queue string) e
 	}
 
 	wg.Wait() // wait for all goroutines to finish
-	c.logger.Info("All CSV data processed and published to queue")
-	return nil
+
+	summary.Published = int(published.Load())
+	summary.Failed = int(failed.Load())
+
+	err = rejected.Close()
+	if err != nil {
+		c.logger.Error("failed to close rejected rows file", zap.Error(err))
+	}
+
+	if tracker != nil {
+		err = tracker.Flush()
+		if err != nil {
+			c.logger.Error("failed to save checkpoint", zap.Error(err))
+			return summary, err
+		}
+
+		checkpoint := tracker.Checkpoint()
+		c.logger.Info("checkpoint saved", zap.String("checkpoint", checkpointFile), zap.Int64("offset", checkpoint.Offset), zap.Int("line", checkpoint.Line))
+	}
+
+	if errors.Is(readErr, context.Canceled) || errors.Is(readErr, context.DeadlineExceeded) {
+		c.logger.Warn("stopped reading input file before its end", zap.Any("summary", summary), zap.Error(readErr))
+		return summary, readErr
+	}
+
+	if readErr != nil {
+		return summary, fmt.Errorf("failed to read input file: %w", readErr)
+	}
+
+	c.logger.Info("All CSV data processed and published to queue", zap.Any("summary", summary), zap.Bool("confirmed", c.confirms))
+
+	return summary, nil
+}
+
+// loadCheckpoint returns the checkpoint the run starts from.
+// Unless resuming, or if no checkpoint has been written yet, it starts from the first row.
+func (c *ProducerUsecase) loadCheckpoint(checkpointFile string, filepath string, resume bool) (Checkpoint, error) {
+	info, err := os.Stat(filepath)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	checkpoint := Checkpoint{
+		File:    filepath,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	if !resume {
+		return checkpoint, nil
+	}
+
+	previous, err := LoadCheckpoint(checkpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		c.logger.Info("no checkpoint found, starting from the first row", zap.String("checkpoint", checkpointFile))
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+
+	if !previous.Matches(info) {
+		return checkpoint, fmt.Errorf("%w, remove %s to start over", ErrCheckpointMismatch, checkpointFile)
+	}
+
+	return *previous, nil
+}
+
+// collectIds reads the ids of all the rows of the input file, rows with an invalid id are ignored.
+func (c *ProducerUsecase) collectIds(filepath string) (map[int64]struct{}, error) {
+	reader, closer, err := c.openInput(filepath, c.inputFormat)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	ids := make(map[int64]struct{})
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := reader.Id(row)
+		if err == nil {
+			ids[id] = struct{}{}
+		}
+	}
+
+	return ids, nil
 }
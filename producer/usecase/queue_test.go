@@ -1,11 +1,15 @@
 package usecase_test
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/viswals/core/dto"
+	"github.com/viswals/core/infrastructure/rabbitmq"
 	mock_interfaces "github.com/viswals/core/interfaces/mocks"
 	"github.com/viswals/producer/usecase"
 )
@@ -14,7 +18,8 @@ import (
 
 func TestPublishCSVDataToQueue_Success_ValidCSVFile(t *testing.T) {
 
-	csvContent := `8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1
+	csvContent := `id,first_name,last_name,email,created_at,deleted_at,merged_at,parent_user_id
+8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1
 31,Emily,Tamm,EmilyTamm@gmail.edu,1361367320000,-1,-1,-1`
 
 	tmpfile, err := os.CreateTemp("", "test-*.csv")
@@ -43,23 +48,25 @@ func TestPublishCSVDataToQueue_Success_ValidCSVFile(t *testing.T) {
 	mockQueue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).Return(nil).Times(2)
 	// expect success logs for the published messages
 	mockLogger.EXPECT().Info(gomock.Any()).Times(2)
-	// expect the final completion message
-	mockLogger.EXPECT().Info("All CSV data processed and published to queue").Times(1)
+	// expect the final completion message with the summary
+	mockLogger.EXPECT().Info("All CSV data processed and published to queue", gomock.Any(), gomock.Any()).Times(1)
 
 	// initialize the producer with the mocks
 	producer := usecase.New(mockQueue, usecase.WithLogger(mockLogger))
 
 	// run the test: publish CSV data to the queue
-	err = producer.PublishCSVDataToQueue(tmpfile.Name(), "test_queue")
+	summary, err := producer.PublishCSVDataToQueue(tmpfile.Name(), "test_queue")
 
 	// check if the error is as expected (no error for success case)
 	assert.NoError(t, err)
+	assert.Equal(t, usecase.PublishSummary{Read: 2, Valid: 2, Rejected: 0, Published: 2, Failed: 0}, summary)
 }
 
 func TestPublishCSVDataToQueue_Failure_InValidCSVFile(t *testing.T) {
 
 	// prepare csv with invalid content
-	csvContent := `8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1
+	csvContent := `id,first_name,last_name,email,created_at,deleted_at,merged_at,parent_user_id
+8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1
 invalid-id,Emily,Tamm,EmilyTamm@gmail.edu,1361367320000,-1,-1,-1`
 
 	// temp file for testing
@@ -88,15 +95,62 @@ invalid-id,Emily,Tamm,EmilyTamm@gmail.edu,1361367320000,-1,-1,-1`
 	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).Times(2)
 	// we expect this to be called once, as out of two records one is buggy.
 	mockQueue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).Return(nil).Times(1)
-	// expect the final completion message
-	mockLogger.EXPECT().Info(gomock.Any()).Times(1)
+	// expect the final completion message with the summary
+	mockLogger.EXPECT().Info("All CSV data processed and published to queue", gomock.Any(), gomock.Any()).Times(1)
 
 	// initialize the producer with the mocks
-	producer := usecase.New(mockQueue, usecase.WithLogger(mockLogger))
+	rejectedFile := filepath.Join(t.TempDir(), "rejected.csv")
+	producer := usecase.New(mockQueue, usecase.WithLogger(mockLogger), usecase.WithRejectedRowsFile(rejectedFile))
 
 	// run the test: publish CSV data to the queue
-	err = producer.PublishCSVDataToQueue(tmpfile.Name(), "test_queue")
+	summary, err := producer.PublishCSVDataToQueue(tmpfile.Name(), "test_queue")
 
 	// there should be no error, as it logs the errors if any.
 	assert.NoError(t, err)
+	assert.Equal(t, usecase.PublishSummary{Read: 2, Valid: 1, Rejected: 1, Published: 1, Failed: 0}, summary)
+
+	// the invalid record is reported with its line number
+	rejected, err := os.ReadFile(rejectedFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rejected), "line,reason,id,first_name")
+	assert.Contains(t, string(rejected), `3,"invalid id in record`)
+}
+
+func TestPublishUserDataToQueue_PublisherConfirms(t *testing.T) {
+	tests := []struct {
+		name        string
+		results     []error
+		expectedErr error
+	}{
+		{name: "confirmed", results: []error{nil}, expectedErr: nil},
+		{name: "nacked then confirmed", results: []error{rabbitmq.ErrNacked, nil}, expectedErr: nil},
+		{name: "nacked until retries are exhausted", results: []error{rabbitmq.ErrNacked, rabbitmq.ErrNacked, rabbitmq.ErrNacked}, expectedErr: rabbitmq.ErrNacked},
+		{name: "returned is not retried", results: []error{rabbitmq.ErrReturned}, expectedErr: rabbitmq.ErrReturned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+			mockLogger := mock_interfaces.NewMockILogger(ctrl)
+
+			mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+			var calls []*gomock.Call
+			for _, result := range tt.results {
+				calls = append(calls, mockQueue.EXPECT().PublishWithConfirm(gomock.Any(), gomock.Any()).Return(result))
+			}
+			gomock.InOrder(calls...)
+
+			// two retries allow three attempts in total
+			producer := usecase.New(mockQueue, usecase.WithLogger(mockLogger), usecase.WithPublisherConfirms(2))
+
+			err := producer.PublishUserDataToQueue(context.Background(), "test_queue", dto.RawUserData{Id: 1})
+			assert.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
 }
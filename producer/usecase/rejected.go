@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RejectedRowsPath returns the default path of the file holding the rejected rows of the given input file.
+func RejectedRowsPath(inputPath string) string {
+	return strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".rejected.csv"
+}
+
+// rejectedRowsWriter writes rejected rows with their line number and rejection reason to a CSV file.
+// The file is only created once the first row is rejected.
+type rejectedRowsWriter struct {
+	path   string
+	header []string
+	// append keeps the rows rejected by a previous run of the same file
+	append bool
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newRejectedRowsWriter(path string, header []string, append bool) *rejectedRowsWriter {
+	return &rejectedRowsWriter{
+		path:   path,
+		header: header,
+		append: append,
+	}
+}
+
+// Write appends a rejected row, record may be nil if the row could not be read at all.
+func (w *rejectedRowsWriter) Write(line int, reason string, record []string) error {
+	if w.writer == nil {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if w.append {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+
+		file, err := os.OpenFile(w.path, flag, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create rejected rows file: %w", err)
+		}
+
+		w.file = file
+		w.writer = csv.NewWriter(file)
+
+		// an appended file already starts with the header
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		if info.Size() == 0 {
+			err = w.writer.Write(append([]string{"line", "reason"}, w.header...))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.writer.Write(append([]string{strconv.Itoa(line), reason}, record...))
+}
+
+// Close flushes the written rows and closes the file, if any row has been rejected.
+func (w *rejectedRowsWriter) Close() error {
+	if w.writer == nil {
+		return nil
+	}
+
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
@@ -0,0 +1,20 @@
+package usecase
+
+// PublishSummary reports the outcome of a CSV run.
+// When publisher confirms are enabled, only messages confirmed by the broker are counted as published.
+type PublishSummary struct {
+	Read      int `json:"read"`
+	Valid     int `json:"valid"`
+	Rejected  int `json:"rejected"`
+	Published int `json:"published"`
+	Failed    int `json:"failed"`
+}
+
+// ErrorRate returns the share of read rows which have been rejected.
+func (s PublishSummary) ErrorRate() float64 {
+	if s.Read == 0 {
+		return 0
+	}
+
+	return float64(s.Rejected) / float64(s.Read)
+}
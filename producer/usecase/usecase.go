@@ -1,14 +1,38 @@
 package usecase
 
 import (
+	"time"
+
 	"github.com/viswals/core/infrastructure/rabbitmq"
 	"github.com/viswals/core/interfaces"
 	"github.com/viswals/core/pkg/logger"
 )
 
+const (
+	defaultPublishRetryDelay = 500 * time.Millisecond
+)
+
 type ProducerUsecase struct {
 	rmq    interfaces.IQueueService
 	logger interfaces.ILogger
+
+	// publisher confirms
+	confirms       bool
+	publishRetries int
+	retryDelay     time.Duration
+
+	inputFormat      Format
+	columnMapping    ColumnMapping
+	rejectedRowsFile string
+
+	// checkpointing, see WithCheckpointFile
+	checkpointFile     string
+	resume             bool
+	checkpointInterval time.Duration
+
+	// watch mode, see Watch
+	watchInterval time.Duration
+	maxErrorRate  float64
 }
 
 // set default values for producer
@@ -21,6 +45,22 @@ func (p *ProducerUsecase) setDefaults() {
 
 		p.logger = logger
 	}
+
+	if p.retryDelay == 0 {
+		p.retryDelay = defaultPublishRetryDelay
+	}
+
+	if p.checkpointInterval == 0 {
+		p.checkpointInterval = defaultCheckpointInterval
+	}
+
+	if p.watchInterval == 0 {
+		p.watchInterval = defaultWatchInterval
+	}
+
+	if p.columnMapping.Columns == nil {
+		p.columnMapping = DefaultColumnMapping()
+	}
 }
 
 type Option func(*ProducerUsecase)
@@ -37,9 +77,71 @@ func WithLogger(logger interfaces.ILogger) Option {
 	}
 }
 
+// WithPublisherConfirms makes the producer wait for the broker to confirm every message.
+// Nacked messages are published again up to maxRetries times. The queue service must have confirms enabled.
+func WithPublisherConfirms(maxRetries int) Option {
+	return func(p *ProducerUsecase) {
+		p.confirms = true
+		p.publishRetries = maxRetries
+	}
+}
+
+// WithInputFormat sets the format of the input files, by default it is detected from their extension.
+func WithInputFormat(format Format) Option {
+	return func(p *ProducerUsecase) {
+		p.inputFormat = format
+	}
+}
+
+// WithColumnMapping sets how the CSV header columns are mapped to the user data fields.
+func WithColumnMapping(mapping ColumnMapping) Option {
+	return func(p *ProducerUsecase) {
+		p.columnMapping = mapping
+	}
+}
+
+// WithRejectedRowsFile sets the file rejected rows are written to.
+// By default they are written next to the input file, see RejectedRowsPath.
+func WithRejectedRowsFile(path string) Option {
+	return func(p *ProducerUsecase) {
+		p.rejectedRowsFile = path
+	}
+}
+
+// WithCheckpointFile makes the producer record in the given file how far the CSV file has been processed.
+// Combined with publisher confirms, only rows confirmed by the broker are recorded as processed.
+func WithCheckpointFile(path string) Option {
+	return func(p *ProducerUsecase) {
+		p.checkpointFile = path
+	}
+}
+
+// WithResume makes the producer continue from the checkpoint of a previous run instead of the first row.
+// It requires WithCheckpointFile, the run starts from the first row if no checkpoint has been written yet.
+func WithResume() Option {
+	return func(p *ProducerUsecase) {
+		p.resume = true
+	}
+}
+
+// WithWatchInterval sets how often the watched directory is polled for new files.
+func WithWatchInterval(interval time.Duration) Option {
+	return func(p *ProducerUsecase) {
+		p.watchInterval = interval
+	}
+}
+
+// WithMaxErrorRate sets the share of rejected rows (0-1) above which a watched file is moved to the failed directory.
+func WithMaxErrorRate(rate float64) Option {
+	return func(p *ProducerUsecase) {
+		p.maxErrorRate = rate
+	}
+}
+
 func New(rmq interfaces.IQueueService, options ...Option) *ProducerUsecase {
 	usecase := &ProducerUsecase{
-		rmq: rmq,
+		rmq:          rmq,
+		maxErrorRate: 1,
 	}
 
 	for _, option := range options {
@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/viswals/core/dto"
+)
+
+const (
+	// minValidEpoch is 1990-01-01 in milliseconds, lower values are most likely expressed in seconds.
+	minValidEpoch int64 = 631152000000
+	// maxClockSkew is how far in the future a timestamp can be before it is considered invalid.
+	maxClockSkew = 24 * time.Hour
+)
+
+// ValidateUserData checks the parsed user data against the business rules and returns every violated rule.
+// Parent ids are only checked when knownIds is not nil, it should hold all the ids of the source file.
+func ValidateUserData(user dto.RawUserData, knownIds map[int64]struct{}) error {
+	var errs []error
+
+	if user.Id <= 0 {
+		errs = append(errs, fmt.Errorf("id must be positive"))
+	}
+
+	if strings.TrimSpace(user.FirstName) == "" {
+		errs = append(errs, fmt.Errorf("first name is empty"))
+	}
+
+	if strings.TrimSpace(user.LastName) == "" {
+		errs = append(errs, fmt.Errorf("last name is empty"))
+	}
+
+	if address, err := mail.ParseAddress(user.Email); err != nil || address.Address != user.Email {
+		// the value is left out, the logs only mask valid addresses and the rejected rows file keeps the row
+		errs = append(errs, fmt.Errorf("invalid email"))
+	}
+
+	maxValidEpoch := time.Now().Add(maxClockSkew).UnixMilli()
+	timestamps := []struct {
+		field string
+		epoch int64
+	}{
+		{field: FieldCreatedAt, epoch: user.CreatedAt},
+		{field: FieldDeletedAt, epoch: user.DeletedAt},
+		{field: FieldMergedAt, epoch: user.MergedAt},
+	}
+
+	for _, timestamp := range timestamps {
+		// zero and negative values mean the timestamp is not set
+		if timestamp.epoch > 0 && (timestamp.epoch < minValidEpoch || timestamp.epoch > maxValidEpoch) {
+			errs = append(errs, fmt.Errorf("%s %d is out of range", timestamp.field, timestamp.epoch))
+		}
+	}
+
+	if knownIds != nil && user.ParentUserId > 0 {
+		if _, ok := knownIds[user.ParentUserId]; !ok {
+			errs = append(errs, fmt.Errorf("parent user id %d does not reference a known id", user.ParentUserId))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,66 @@
+package usecase_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/viswals/core/dto"
+	"github.com/viswals/producer/usecase"
+)
+
+func TestValidateUserData(t *testing.T) {
+	valid := dto.RawUserData{
+		Id:           8,
+		FirstName:    "Hanah",
+		LastName:     "Schmidt",
+		Email:        "Hanah_Schmidt1965@gmail.edu",
+		CreatedAt:    1361218223000,
+		DeletedAt:    -1,
+		MergedAt:     -1,
+		ParentUserId: -1,
+	}
+
+	knownIds := map[int64]struct{}{8: {}, 31: {}}
+
+	tests := []struct {
+		name        string
+		modify      func(user *dto.RawUserData)
+		expectedErr string
+		// leaked is a value the error must not hold
+		leaked string
+	}{
+		{name: "valid user", modify: func(user *dto.RawUserData) {}},
+		{name: "known parent", modify: func(user *dto.RawUserData) { user.ParentUserId = 31 }},
+		{name: "invalid email", modify: func(user *dto.RawUserData) { user.Email = "hanah.schmidt" }, expectedErr: "invalid email", leaked: "hanah.schmidt"},
+		{name: "email with display name", modify: func(user *dto.RawUserData) { user.Email = "Hanah <hanah@gmail.edu>" }, expectedErr: "invalid email", leaked: "hanah@gmail.edu"},
+		{name: "empty first name", modify: func(user *dto.RawUserData) { user.FirstName = " " }, expectedErr: "first name is empty"},
+		{name: "empty last name", modify: func(user *dto.RawUserData) { user.LastName = "" }, expectedErr: "last name is empty"},
+		{name: "epoch in seconds", modify: func(user *dto.RawUserData) { user.CreatedAt = 1361218223 }, expectedErr: "created_at 1361218223 is out of range"},
+		{name: "epoch in the future", modify: func(user *dto.RawUserData) { user.MergedAt = time.Now().Add(48 * time.Hour).UnixMilli() }, expectedErr: "merged_at"},
+		{name: "unknown parent", modify: func(user *dto.RawUserData) { user.ParentUserId = 99 }, expectedErr: "parent user id 99"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user := valid
+			test.modify(&user)
+
+			err := usecase.ValidateUserData(user, knownIds)
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorContains(t, err, test.expectedErr)
+			if test.leaked != "" {
+				assert.NotContains(t, err.Error(), test.leaked)
+			}
+		})
+	}
+}
+
+func TestPublishSummaryErrorRate(t *testing.T) {
+	assert.Equal(t, 0.0, usecase.PublishSummary{}.ErrorRate())
+	assert.Equal(t, 0.4, usecase.PublishSummary{Read: 10, Rejected: 4}.ErrorRate())
+}
@@ -0,0 +1,262 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultWatchInterval = 5 * time.Second
+
+	// subdirectories of the watched directory processed files are moved to
+	DoneDir   = "done"
+	FailedDir = "failed"
+)
+
+// suffixes of the files which are never picked up from the watched directory
+var ignoredSuffixes = []string{".rejected.csv", ".checkpoint.json", ".report.json", ".tmp", ".part"}
+
+// FileReport is written next to every processed file of the watched directory.
+type FileReport struct {
+	File       string         `json:"file"`
+	Status     string         `json:"status"`
+	Summary    PublishSummary `json:"summary"`
+	Error      string         `json:"error,omitempty"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+}
+
+// Watch publishes every file landing in the directory until the context is done.
+// A file is picked up once its size did not change between two polls, so files which are still being written are left alone.
+// Processed files are moved with their report and rejected rows to the done or failed subdirectory.
+// A file interrupted by the context being done stays in the directory and is resumed from its checkpoint on the next start.
+func (c *ProducerUsecase) Watch(ctx context.Context, dir string, queue string) error {
+	for _, subdir := range []string{DoneDir, FailedDir} {
+		err := os.MkdirAll(filepath.Join(dir, subdir), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create %s directory: %w", subdir, err)
+		}
+	}
+
+	c.logger.Info("watching directory for input files", zap.String("dir", dir), zap.Duration("interval", c.watchInterval))
+
+	// sizes seen on the previous poll, files are processed once their size is stable
+	sizes := make(map[string]int64)
+
+	// files processed but which could not be moved out of the directory, they are not published again unless replaced
+	stuck := make(map[string]watchedFile)
+
+	ticker := time.NewTicker(c.watchInterval)
+	defer ticker.Stop()
+
+	for {
+		files, err := pendingFiles(dir)
+		if err != nil {
+			c.logger.Error("failed to list watched directory", zap.String("dir", dir), zap.Error(err))
+		}
+
+		seen := make(map[string]int64, len(files))
+		for _, file := range files {
+			seen[file.name] = file.size
+
+			if previous, ok := sizes[file.name]; !ok || previous != file.size {
+				continue // still being written, or seen for the first time
+			}
+
+			if stuckFile, ok := stuck[file.name]; ok && stuckFile == file {
+				continue
+			}
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			moved := c.processWatchedFile(ctx, dir, file.name, queue)
+			if !moved && ctx.Err() == nil {
+				stuck[file.name] = file
+			}
+			delete(seen, file.name)
+		}
+		sizes = seen
+
+		// forget the stuck files removed or replaced since
+		if err == nil {
+			current := make(map[string]watchedFile, len(files))
+			for _, file := range files {
+				current[file.name] = file
+			}
+
+			for name, file := range stuck {
+				if current[name] != file {
+					delete(stuck, name)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			c.logger.Info("stopped watching directory", zap.String("dir", dir))
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// processWatchedFile publishes a file of the watched directory and moves it to the done or failed subdirectory.
+// It reports whether the file left the directory, an interrupted file or one which could not be moved stays.
+func (c *ProducerUsecase) processWatchedFile(ctx context.Context, dir string, name string, queue string) bool {
+	path := filepath.Join(dir, name)
+
+	// working files are hidden so they are not picked up, they are moved along with the file
+	run := runSettings{
+		rejectedPath:   filepath.Join(dir, "."+name+".rejected.csv"),
+		checkpointFile: filepath.Join(dir, "."+name+".checkpoint.json"),
+		resume:         true,
+	}
+
+	c.logger.Info("processing input file", zap.String("file", path))
+
+	report := FileReport{File: name, StartedAt: time.Now()}
+	summary, err := c.publishFile(ctx, path, queue, run)
+	report.FinishedAt = time.Now()
+	report.Summary = summary
+
+	if errors.Is(err, context.Canceled) {
+		c.logger.Warn("input file interrupted, it is resumed on the next start", zap.String("file", path), zap.Any("summary", summary))
+		return false
+	}
+
+	switch {
+	case err != nil:
+		report.Error = err.Error()
+	case summary.Failed > 0:
+		report.Error = fmt.Sprintf("%d rows failed to publish", summary.Failed)
+	case summary.ErrorRate() > c.maxErrorRate:
+		report.Error = fmt.Sprintf("rejected rows rate %.4f exceeds the maximum error rate %.4f", summary.ErrorRate(), c.maxErrorRate)
+	}
+
+	report.Status = DoneDir
+	if report.Error != "" {
+		report.Status = FailedDir
+	}
+
+	target, err := moveProcessedFile(path, run, report)
+	if err != nil && target == path && report.Status == DoneDir {
+		c.logger.Error("failed to move processed input file, moving it to failed", zap.String("file", path), zap.Error(err))
+
+		// published, but a file left in the directory would be published again
+		report.Status = FailedDir
+		report.Error = fmt.Sprintf("published but failed to move to %s: %s", DoneDir, err)
+		target, err = moveProcessedFile(path, run, report)
+	}
+	if err != nil {
+		c.logger.Error("failed to move processed input file", zap.String("file", path), zap.String("status", report.Status), zap.Error(err))
+		if target == path {
+			c.logger.Error("input file stays in the watched directory, it is not published again until replaced", zap.String("file", path))
+			return false
+		}
+		return true
+	}
+
+	if report.Status == FailedDir {
+		c.logger.Error("input file failed", zap.String("file", target), zap.String("reason", report.Error), zap.Any("summary", summary))
+		return true
+	}
+
+	c.logger.Info("input file processed", zap.String("file", target), zap.Any("summary", summary))
+	return true
+}
+
+// moveProcessedFile moves the file and its rejected rows to the subdirectory of its status and writes its report there.
+// It returns the new path of the file.
+func moveProcessedFile(path string, run runSettings, report FileReport) (string, error) {
+	dir := filepath.Join(filepath.Dir(path), report.Status)
+
+	// a file with the same name may have been processed before
+	target := uniquePath(filepath.Join(dir, report.File))
+	base := strings.TrimSuffix(target, filepath.Ext(target))
+
+	err := os.Rename(path, target)
+	if err != nil {
+		return path, err
+	}
+
+	err = os.Rename(run.rejectedPath, base+".rejected.csv")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return target, err
+	}
+
+	err = os.Remove(run.checkpointFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return target, err
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return target, err
+	}
+
+	return target, os.WriteFile(base+".report.json", data, 0644)
+}
+
+type watchedFile struct {
+	name    string
+	size    int64
+	modTime int64
+}
+
+// pendingFiles lists the input files of the directory with their size, sorted by name.
+func pendingFiles(dir string) ([]watchedFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []watchedFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !isInputFile(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed since it was listed
+		}
+
+		files = append(files, watchedFile{name: entry.Name(), size: info.Size(), modTime: info.ModTime().UnixNano()})
+	}
+
+	return files, nil
+}
+
+func isInputFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	for _, suffix := range ignoredSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// uniquePath adds a timestamp to the name of the file if the path is already taken.
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(path, ext), time.Now().Format("20060102T150405.000"), ext)
+}
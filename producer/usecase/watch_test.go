@@ -0,0 +1,154 @@
+package usecase_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mock_interfaces "github.com/viswals/core/interfaces/mocks"
+	"github.com/viswals/producer/usecase"
+)
+
+func TestWatch(t *testing.T) {
+	validContent := `id,first_name,last_name,email,created_at,deleted_at,merged_at,parent_user_id
+8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1
+`
+	invalidContent := `id,first_name,last_name,email,created_at,deleted_at,merged_at,parent_user_id
+invalid-id,Emily,Tamm,EmilyTamm@gmail.edu,1361367320000,-1,-1,-1
+`
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "valid.csv"), []byte(validContent), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "invalid.csv"), []byte(invalidContent), 0644))
+	// left over by a one-shot run, never picked up
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.rejected.csv"), []byte(invalidContent), 0644))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLogger := mock_interfaces.NewMockILogger(ctrl)
+	mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Warn(gomock.Any(), gomock.Any()).AnyTimes()
+	mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+	mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+	mockQueue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+	producer := usecase.New(mockQueue, usecase.WithLogger(mockLogger), usecase.WithWatchInterval(10*time.Millisecond), usecase.WithMaxErrorRate(0.5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- producer.Watch(ctx, dir, "test_queue")
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, validErr := os.Stat(filepath.Join(dir, usecase.DoneDir, "valid.report.json"))
+		_, invalidErr := os.Stat(filepath.Join(dir, usecase.FailedDir, "invalid.report.json"))
+		return validErr == nil && invalidErr == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+
+	// processed files are moved with their report and rejected rows
+	assert.FileExists(t, filepath.Join(dir, usecase.DoneDir, "valid.csv"))
+	assert.FileExists(t, filepath.Join(dir, usecase.FailedDir, "invalid.csv"))
+	assert.FileExists(t, filepath.Join(dir, usecase.FailedDir, "invalid.rejected.csv"))
+	assert.FileExists(t, filepath.Join(dir, "old.rejected.csv"))
+	assert.NoFileExists(t, filepath.Join(dir, "valid.csv"))
+
+	data, err := os.ReadFile(filepath.Join(dir, usecase.FailedDir, "invalid.report.json"))
+	require.NoError(t, err)
+
+	var report usecase.FileReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, usecase.FailedDir, report.Status)
+	assert.Equal(t, usecase.PublishSummary{Read: 1, Rejected: 1}, report.Summary)
+	assert.Contains(t, report.Error, "exceeds the maximum error rate")
+}
+
+func TestWatch_MoveFails(t *testing.T) {
+	content := `id,first_name,last_name,email,created_at,deleted_at,merged_at,parent_user_id
+8,Hanah,Schmidt,Hanah_Schmidt1965@gmail.edu,1361218223000,-1,-1,-1
+`
+
+	tests := []struct {
+		name string
+		// subdirectories replaced by a file, so nothing can be moved into them
+		broken    []string
+		movedTo   string
+		reportErr string
+	}{
+		{name: "moved to failed", broken: []string{usecase.DoneDir}, movedTo: usecase.FailedDir, reportErr: "published but failed to move to done"},
+		{name: "left in the directory", broken: []string{usecase.DoneDir, usecase.FailedDir}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockLogger := mock_interfaces.NewMockILogger(ctrl)
+			mockLogger.EXPECT().Info(gomock.Any(), gomock.Any()).AnyTimes()
+			mockLogger.EXPECT().Error(gomock.Any(), gomock.Any()).AnyTimes()
+
+			var published atomic.Int32
+			mockQueue := mock_interfaces.NewMockIQueueService(ctrl)
+			mockQueue.EXPECT().PublishWithContext(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, options ...any) error {
+				published.Add(1)
+				return nil
+			}).AnyTimes()
+
+			producer := usecase.New(mockQueue, usecase.WithLogger(mockLogger), usecase.WithWatchInterval(10*time.Millisecond))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- producer.Watch(ctx, dir, "test_queue")
+			}()
+
+			assert.Eventually(t, func() bool {
+				_, err := os.Stat(filepath.Join(dir, usecase.FailedDir))
+				return err == nil
+			}, 5*time.Second, 10*time.Millisecond)
+
+			for _, subdir := range tt.broken {
+				require.NoError(t, os.Remove(filepath.Join(dir, subdir)))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, subdir), nil, 0644))
+			}
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "users.csv"), []byte(content), 0644))
+
+			assert.Eventually(t, func() bool {
+				return published.Load() > 0
+			}, 5*time.Second, 10*time.Millisecond)
+
+			// the file is published once, whether or not it could be moved
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			assert.NoError(t, <-done)
+			assert.Equal(t, int32(1), published.Load())
+
+			if tt.movedTo == "" {
+				assert.FileExists(t, filepath.Join(dir, "users.csv"))
+				return
+			}
+
+			assert.NoFileExists(t, filepath.Join(dir, "users.csv"))
+			data, err := os.ReadFile(filepath.Join(dir, tt.movedTo, "users.report.json"))
+			require.NoError(t, err)
+
+			var report usecase.FileReport
+			require.NoError(t, json.Unmarshal(data, &report))
+			assert.Contains(t, report.Error, tt.reportErr)
+		})
+	}
+}